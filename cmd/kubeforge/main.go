@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/internal/wait"
+	"github.com/ochestra-tech/kubeforge/pkg/config"
 	"github.com/ochestra-tech/kubeforge/pkg/container"
 	"github.com/ochestra-tech/kubeforge/pkg/distro"
+	"github.com/ochestra-tech/kubeforge/pkg/firewall"
 	"github.com/ochestra-tech/kubeforge/pkg/kubernetes"
 	"github.com/ochestra-tech/kubeforge/pkg/network"
+	"github.com/ochestra-tech/kubeforge/pkg/proxy"
 	"github.com/ochestra-tech/kubeforge/pkg/system"
 	"github.com/ochestra-tech/kubeforge/pkg/util"
 )
@@ -20,7 +29,18 @@ const (
 	Version = "1.0.0"
 )
 
+// detectPluginTimeout bounds the retries of network plugin detection after kubeadm init
+const detectPluginTimeout = 2 * time.Minute
+
 func main() {
+	// Subcommands manage an existing cluster; without one, set up this node
+	if runSubcommand(logger.New()) {
+		return
+	}
+
+	configPath := flag.String("config", "", "Path to the KubeForge configuration file (default "+config.DefaultPath+" if present)")
+	flag.Parse()
+
 	// Initialize logger
 	log := logger.New()
 
@@ -40,7 +60,37 @@ func main() {
 		os.Exit(1)
 	}
 
-	log.Info("Detected Linux distribution: %s %s", dist.Name, dist.Version)
+	log.Info("Detected Linux distribution: %s %s (%s)", dist.Name, dist.Version, dist.Arch)
+
+	// Load configuration file, if any
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Error("Error loading configuration: %v", err)
+		os.Exit(1)
+	}
+	cfg.Network.Arch = dist.Arch
+
+	// Keep SELinux enforcing only when asked to; permissive is the kubeadm default
+	selinuxMode := cfg.System.SELinux
+	if selinuxMode == "" {
+		selinuxMode = system.SELinuxPermissive
+		if system.SELinuxState() != system.SELinuxStateDisabled &&
+			util.PromptYesNo("Keep SELinux in enforcing mode (installs container-selinux)?") {
+			selinuxMode = system.SELinuxEnforcing
+		}
+	}
+
+	if err := system.Preflight(dist, selinuxMode, log); err != nil {
+		log.Error("Preflight checks failed: %v", err)
+		os.Exit(1)
+	}
+
+	// Proxy settings must be in place before the first download
+	cfg.Proxy.AddClusterNoProxy(cfg.Kubernetes.PodCIDR, cfg.Kubernetes.ServiceCIDR, nodeIPs(cfg.Kubernetes, cfg.Network))
+	if err := proxy.Configure(&cfg.Proxy, dist, log); err != nil {
+		log.Error("Failed to configure proxy: %v", err)
+		os.Exit(1)
+	}
 
 	// Perform installation steps
 	if err := system.UpdateSystem(dist, log); err != nil {
@@ -53,22 +103,55 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := system.DisableSwap(log); err != nil {
-		log.Error("Failed to disable swap: %v", err)
+	// Stable names and synchronized clocks are needed for certificates and etcd
+	if err := system.ConfigureTimeSync(dist, log); err != nil {
+		log.Error("Failed to configure time synchronization: %v", err)
+		os.Exit(1)
+	}
+
+	if cfg.Kubernetes.NodeName != "" {
+		if err := system.SetHostname(cfg.Kubernetes.NodeName, log); err != nil {
+			log.Error("Failed to set hostname: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := system.UpdateHosts(cfg.Kubernetes.Hosts, log); err != nil {
+		log.Error("Failed to update /etc/hosts: %v", err)
 		os.Exit(1)
 	}
 
-	if err := system.ConfigureSystem(log); err != nil {
+	// Clusters using the kubelet NodeSwap feature keep swap on
+	if cfg.Kubernetes.SwapBehavior == "" {
+		if err := system.DisableSwap(log); err != nil {
+			log.Error("Failed to disable swap: %v", err)
+			os.Exit(1)
+		}
+	} else {
+		log.Info("Keeping swap enabled (NodeSwap behavior: %s)", cfg.Kubernetes.SwapBehavior)
+	}
+
+	// Kernel modules and sysctls, extended once the network plugin is known
+	profile := system.DefaultProfile()
+	profile.AddModules(cfg.System.KernelModules...)
+	profile.SetSysctls(cfg.System.Sysctls)
+
+	if err := system.ConfigureSystem(profile, log); err != nil {
 		log.Error("Failed to configure system: %v", err)
 		os.Exit(1)
 	}
 
+	if err := system.ConfigureSELinux(dist, selinuxMode, log); err != nil {
+		log.Error("Failed to configure SELinux: %v", err)
+		os.Exit(1)
+	}
+
 	if err := container.InstallContainerd(dist, log); err != nil {
 		log.Error("Failed to install containerd: %v", err)
 		os.Exit(1)
 	}
 
-	if err := kubernetes.Install(dist, log); err != nil {
+	if err := kubernetes.Install(dist, cfg.Kubernetes.KubernetesVersion, log); err != nil {
 		log.Error("Failed to install Kubernetes components: %v", err)
 		os.Exit(1)
 	}
@@ -77,15 +160,61 @@ func main() {
 	isControlPlane := util.PromptYesNo("Is this a control plane (master) node?")
 
 	// Create Kubernetes configuration
-	kubeConfig := kubernetes.DefaultConfig()
+	kubeConfig := cfg.Kubernetes
 	kubeConfig.IsControlPlane = isControlPlane
 
 	if isControlPlane {
 		// Get configuration parameters
-		defaultIP := util.GetDefaultIP()
-		kubeConfig.PodCIDR = util.PromptWithDefault("Enter Pod Network CIDR", kubeConfig.PodCIDR)
-		kubeConfig.ServiceCIDR = util.PromptWithDefault("Enter Service CIDR", kubeConfig.ServiceCIDR)
-		kubeConfig.APIServerAddr = util.PromptWithDefault("Enter API Server Advertise Address", defaultIP)
+		for {
+			kubeConfig.PodCIDR = util.PromptWithDefault("Enter Pod Network CIDR (IPv4,IPv6 for dual-stack)", kubeConfig.PodCIDR)
+			kubeConfig.ServiceCIDR = util.PromptWithDefault("Enter Service CIDR (IPv4,IPv6 for dual-stack)", kubeConfig.ServiceCIDR)
+
+			// Check the choice before kubeadm bakes it into the cluster
+			err := cfg.Validate()
+			if err == nil {
+				networkConfig := *cfg.Network
+				networkConfig.PodCIDR = kubeConfig.PodCIDR
+				err = network.CheckHostNetworks(&networkConfig, kubeConfig.ServiceCIDR)
+			}
+			if err != nil {
+				log.Error("%v", err)
+				continue
+			}
+			break
+		}
+		cfg.Network.PodCIDR = kubeConfig.PodCIDR
+		stackMode := cfg.Network.StackMode()
+
+		// IPv6 and dual-stack nodes need IPv6 forwarding before kubeadm's preflight checks
+		if stackMode != network.IPv4Only {
+			profile.AddIPv6()
+			if err := system.ConfigureSystem(profile, log); err != nil {
+				log.Error("Failed to configure system: %v", err)
+				os.Exit(1)
+			}
+		}
+
+		// The kubelet, API server and network plugin all use this node address
+		nodeAddress, err := network.SelectNodeAddress(cfg.Network)
+		if err != nil {
+			log.Error("Failed to select the node address: %v", err)
+			os.Exit(1)
+		}
+		if kubeConfig.NodeIP == "" {
+			kubeConfig.NodeIP, err = nodeAddress.NodeIP(stackMode)
+			if err != nil {
+				log.Error("Failed to select the node address: %v", err)
+				os.Exit(1)
+			}
+			log.Info("Using node address %s on %s", kubeConfig.NodeIP, nodeAddress.Interface)
+		}
+
+		// The advertise address is asked for when the node address lacks the family
+		defaultAPIServerAddr := ""
+		if primary, err := nodeAddress.Primary(stackMode); err == nil {
+			defaultAPIServerAddr = primary.String()
+		}
+		kubeConfig.APIServerAddr = util.PromptWithDefault("Enter API Server Advertise Address", defaultAPIServerAddr)
 		kubeConfig.ClusterName = util.PromptWithDefault("Enter Cluster Name", kubeConfig.ClusterName)
 
 		// Check if HA setup is needed
@@ -93,7 +222,79 @@ func main() {
 		if kubeConfig.HighAvailability {
 			kubeConfig.ControlPlaneEndpoint = util.PromptWithDefault(
 				"Enter control plane endpoint (DNS/IP:port)",
-				fmt.Sprintf("%s:6443", kubeConfig.APIServerAddr))
+				net.JoinHostPort(kubeConfig.APIServerAddr, "6443"))
+		}
+
+		// Cilium's kube-proxy replacement has to be chosen before kubeadm installs kube-proxy
+		if cfg.Network.Plugin == network.Cilium && cfg.Network.KubeProxyReplacement {
+			kubeConfig.ProxyMode = kubernetes.ProxyModeNone
+			log.Info("Skipping kube-proxy, Cilium will replace it")
+		} else {
+			// nftables is only offered when the installed release has it
+			modes := kubernetes.SupportedProxyModes(kubeConfig)
+			for {
+				defaultMode := kubeConfig.ProxyMode
+				if defaultMode == "" {
+					defaultMode = kubernetes.ProxyModeIPTables
+				}
+				prompt := fmt.Sprintf("kube-proxy mode (%s)", strings.Join(modes, ", "))
+				kubeConfig.ProxyMode = util.PromptWithDefault(prompt, defaultMode)
+
+				if kubeConfig.ProxyMode == kubernetes.ProxyModeIPVS {
+					defaultScheduler := kubeConfig.IPVSScheduler
+					if defaultScheduler == "" {
+						defaultScheduler = "rr"
+					}
+					prompt = fmt.Sprintf("IPVS scheduler (%s)", strings.Join(kubernetes.IPVSSchedulers, ", "))
+					kubeConfig.IPVSScheduler = util.PromptWithDefault(prompt, defaultScheduler)
+				} else {
+					kubeConfig.IPVSScheduler = ""
+				}
+
+				err := cfg.Validate()
+				if err == nil {
+					err = kubernetes.ProxyModeSupported(kubeConfig)
+				}
+				if err != nil {
+					log.Error("%v", err)
+					continue
+				}
+				break
+			}
+		}
+
+		if kubeConfig.ProxyMode == kubernetes.ProxyModeIPVS {
+			if err := configureIPVS(dist, profile, kubeConfig.IPVSScheduler, log); err != nil {
+				log.Error("Failed to configure IPVS: %v", err)
+				os.Exit(1)
+			}
+		}
+
+		// The prompts may have changed the networks that must bypass the proxy
+		if cfg.Proxy.Enabled() {
+			cfg.Proxy.AddClusterNoProxy(kubeConfig.PodCIDR, kubeConfig.ServiceCIDR, nodeIPs(kubeConfig, cfg.Network))
+			if err := proxy.Configure(&cfg.Proxy, dist, log); err != nil {
+				log.Error("Failed to configure proxy: %v", err)
+				os.Exit(1)
+			}
+		}
+
+		// Make the control plane endpoint resolvable even without DNS
+		if endpointHost, _, err := net.SplitHostPort(kubeConfig.ControlPlaneEndpoint); err == nil {
+			if kubeConfig.Hosts == nil {
+				kubeConfig.Hosts = make(map[string]string)
+			}
+			kubeConfig.Hosts[endpointHost] = kubeConfig.APIServerAddr
+			if err := system.UpdateHosts(kubeConfig.Hosts, log); err != nil {
+				log.Error("Failed to update /etc/hosts: %v", err)
+				os.Exit(1)
+			}
+		}
+
+		// Open control plane ports before other nodes try to join
+		if err := firewall.Configure(true, nil, log); err != nil {
+			log.Error("Failed to configure firewall: %v", err)
+			os.Exit(1)
 		}
 
 		// Initialize control plane
@@ -102,53 +303,67 @@ func main() {
 			os.Exit(1)
 		}
 
-		// Install Calico network plugin
-		networkConfig := network.DefaultConfig()
+		networkConfig := cfg.Network
 		networkConfig.PodCIDR = kubeConfig.PodCIDR
-
-		// Check if a network plugin is already installed
-		existingPlugin, err := network.GetCurrentPlugin(log)
-		if err == nil {
+		networkConfig.KubeProxyNFTables = kubeConfig.ProxyMode == kubernetes.ProxyModeNFTables
+
+		// Always install a CNI on a cluster without one; ask before replacing one.
+		// The API server may still be settling after kubeadm init, so retry the
+		// detection rather than install over a plugin that went unseen.
+		installCNI := true
+		var existingPlugin *network.PluginStatus
+		detectCtx, cancel := context.WithTimeout(context.Background(), detectPluginTimeout)
+		err = wait.Retry(detectCtx, 5*time.Second, func() error {
+			var err error
+			existingPlugin, err = network.GetCurrentPlugin(log)
+			if err != nil {
+				log.Warn("Failed to detect network plugin, retrying: %v", err)
+			}
+			return err
+		})
+		cancel()
+		if err != nil {
+			log.Error("Failed to detect network plugin: %v", err)
+			os.Exit(1)
+		}
+		if existingPlugin.Plugin != network.None {
 			log.Info("Detected existing network plugin: %s", existingPlugin)
-			if !util.PromptYesNo("Network plugin already installed. Proceed with reinstallation?") {
+			installCNI = util.PromptYesNo("Network plugin already installed. Proceed with reinstallation?")
+			if !installCNI {
 				log.Info("Skipping network plugin installation")
-				// Skip network installation
-			} else {
-				log.Info("Installing network plugin...")
+			}
+		}
 
-				// Ask user which network plugin to use
-				pluginOptions := []string{"Calico", "Flannel", "Weave", "Cilium"}
-				fmt.Println("Available network plugins:")
-				for i, plugin := range pluginOptions {
-					fmt.Printf("%d. %s\n", i+1, plugin)
-				}
+		if installCNI {
+			log.Info("Installing network plugin...")
 
-				selectedPlugin := util.PromptWithDefault("Select network plugin (1-4)", "1")
-				pluginIndex, _ := strconv.Atoi(selectedPlugin)
+			// Ask user which network plugin to use; without kube-proxy it has to be Cilium
+			if kubeConfig.ProxyMode == kubernetes.ProxyModeNone {
+				log.Info("Installing Cilium with its kube-proxy replacement")
+			} else {
+				// kube-proxy is installed, so Cilium must not replace it
+				networkConfig.KubeProxyReplacement = false
+				networkConfig.EnableeBPF = false
+				promptNetworkPlugin(networkConfig, log)
+			}
 
-				if pluginIndex >= 1 && pluginIndex <= len(pluginOptions) {
-					pluginName := pluginOptions[pluginIndex-1]
-					networkConfig.Plugin = network.Plugin(strings.ToLower(pluginName))
+			// Apply the kernel modules and sysctls the plugin needs
+			profile.AddNetwork(networkConfig)
+			if err := system.ConfigureSystem(profile, log); err != nil {
+				log.Error("Failed to configure system: %v", err)
+				os.Exit(1)
+			}
 
-					// If Calico is selected, offer additional configuration options
-					if networkConfig.Plugin == network.Calico {
-						enableEncryption := util.PromptYesNo("Enable WireGuard encryption?")
-						networkConfig.EnableEncryption = enableEncryption
-					}
+			// Install the selected network plugin
+			if err := network.InstallPlugin(networkConfig, log); err != nil {
+				log.Error("Failed to install %s network plugin: %v", networkConfig.Plugin, err)
+				os.Exit(1)
+			}
 
-					// Install the selected network plugin
-					if err := network.InstallPlugin(networkConfig, log); err != nil {
-						log.Error("Failed to install %s network plugin: %v", networkConfig.Plugin, err)
-						os.Exit(1)
-					}
-				} else {
-					log.Error("Invalid selection, defaulting to Calico")
-					networkConfig.Plugin = network.Calico
-					if err := network.InstallPlugin(networkConfig, log); err != nil {
-						log.Error("Failed to install Calico network plugin: %v", err)
-						os.Exit(1)
-					}
-				}
+			// Open the ports the plugin needs between nodes
+			if err := firewall.Configure(true, networkConfig, log); err != nil {
+				log.Error("Failed to configure firewall: %v", err)
+				os.Exit(1)
 			}
 		}
 
@@ -166,6 +381,27 @@ func main() {
 			}
 		}
 
+		if util.PromptYesNo("Verify NetworkPolicy enforcement?") {
+			check, err := network.CheckPolicyEnforcement(log)
+			if err != nil {
+				log.Warn("NetworkPolicy check failed: %v", err)
+			} else if !check.Enforced() && check.Plugin == network.Flannel &&
+				util.PromptYesNo("Replace Flannel with Canal to enforce NetworkPolicy?") {
+				if err := network.EnableCanal(networkConfig, log); err != nil {
+					log.Error("Failed to install Canal: %v", err)
+					os.Exit(1)
+				}
+				if err := firewall.Configure(true, networkConfig, log); err != nil {
+					log.Error("Failed to configure firewall: %v", err)
+					os.Exit(1)
+				}
+				if err := firewall.Teardown(network.Flannel, networkConfig, log); err != nil {
+					log.Error("Failed to configure firewall: %v", err)
+					os.Exit(1)
+				}
+			}
+		}
+
 		// Generate join command
 		joinCommand, err := kubernetes.GenerateJoinCommand(log)
 		if err != nil {
@@ -201,6 +437,86 @@ func main() {
 			"")
 
 		if joinCmd != "" {
+			if err := addJoinEndpointHost(joinCmd, cfg.Kubernetes, log); err != nil {
+				log.Error("Failed to make the control plane endpoint resolvable: %v", err)
+				os.Exit(1)
+			}
+
+			// The kubelet, and reading the cluster settings below, reach the
+			// API server through the join endpoint
+			if cfg.Proxy.Enabled() {
+				if host, err := kubernetes.JoinEndpointHost(joinCmd); err == nil {
+					cfg.Proxy.AddNoProxy(host)
+				}
+				cfg.Proxy.SetEnv()
+			}
+
+			// The cluster's pod CIDR, kube-proxy mode and network plugin apply,
+			// whatever the local config says
+			joinSettings, err := kubernetes.ReadJoinSettings(joinCmd)
+			if err != nil {
+				log.Error("Failed to read the cluster settings: %v", err)
+				os.Exit(1)
+			}
+
+			// containerd and the kubelet must reach the cluster's own networks directly
+			if cfg.Proxy.Enabled() {
+				cfg.Proxy.AddClusterNoProxy(joinSettings.PodCIDR, joinSettings.ServiceCIDR, nodeIPs(cfg.Kubernetes, cfg.Network))
+				if err := proxy.Configure(&cfg.Proxy, dist, log); err != nil {
+					log.Error("Failed to configure proxy: %v", err)
+					os.Exit(1)
+				}
+			}
+
+			// kube-proxy runs in the cluster's mode on every node
+			if joinSettings.ProxyMode == kubernetes.ProxyModeIPVS {
+				if err := configureIPVS(dist, profile, joinSettings.IPVSScheduler, log); err != nil {
+					log.Error("Failed to configure IPVS: %v", err)
+					os.Exit(1)
+				}
+			}
+
+			// Open worker and network plugin ports before joining, for the
+			// plugin the control plane installed
+			networkConfig := cfg.Network
+			networkConfig.PodCIDR = joinSettings.PodCIDR
+			joinSettings.Network.ApplyTo(networkConfig)
+			log.Info("Cluster network plugin: %s", networkConfig.Plugin)
+
+			profile.AddNetwork(networkConfig)
+			if err := system.ConfigureSystem(profile, log); err != nil {
+				log.Error("Failed to configure system: %v", err)
+				os.Exit(1)
+			}
+			if err := firewall.Configure(false, networkConfig, log); err != nil {
+				log.Error("Failed to configure firewall: %v", err)
+				os.Exit(1)
+			}
+
+			// The join command carries no kubelet flags, so the node IP goes in the kubelet defaults
+			nodeIP := cfg.Kubernetes.NodeIP
+			if nodeIP == "" {
+				nodeAddress, err := network.SelectNodeAddress(networkConfig)
+				if err != nil {
+					log.Error("Failed to select the node address: %v", err)
+					os.Exit(1)
+				}
+				nodeIP, err = nodeAddress.NodeIP(networkConfig.StackMode())
+				if err != nil {
+					log.Error("Failed to select the node address: %v", err)
+					os.Exit(1)
+				}
+			}
+			if err := kubernetes.SetKubeletNodeIP(dist, nodeIP, log); err != nil {
+				log.Error("Failed to set kubelet node IP: %v", err)
+				os.Exit(1)
+			}
+
+			// With NodeSwap the cluster kubelet config allows swap; kubeadm still checks for it
+			if cfg.Kubernetes.SwapBehavior != "" {
+				joinCmd += " --ignore-preflight-errors=Swap"
+			}
+
 			if err := kubernetes.JoinCluster(joinCmd, log); err != nil {
 				log.Error("Failed to join the cluster: %v", err)
 				os.Exit(1)
@@ -212,3 +528,137 @@ func main() {
 
 	log.Info("Kubernetes installation completed successfully!")
 }
+
+// addJoinEndpointHost adds the control plane endpoint of the join command
+// to /etc/hosts when it is a name DNS can't resolve. The address comes from
+// kubernetes.apiServerAddr or a prompt; names in kubernetes.hosts are
+// already there.
+func addJoinEndpointHost(joinCmd string, kubeConfig *kubernetes.Config, log *logger.Logger) error {
+	host, err := kubernetes.JoinEndpointHost(joinCmd)
+	if err != nil {
+		return err
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if _, ok := kubeConfig.Hosts[host]; ok {
+		return nil
+	}
+	if _, err := net.LookupHost(host); err == nil {
+		return nil
+	}
+
+	address := kubeConfig.APIServerAddr
+	if address == "" {
+		address = util.PromptWithDefault(fmt.Sprintf("%s does not resolve, enter the control plane address", host), "")
+	}
+	if net.ParseIP(address) == nil {
+		return fmt.Errorf("%s does not resolve and %q is not an IP address", host, address)
+	}
+
+	if kubeConfig.Hosts == nil {
+		kubeConfig.Hosts = make(map[string]string)
+	}
+	kubeConfig.Hosts[host] = address
+	return system.UpdateHosts(kubeConfig.Hosts, log)
+}
+
+// configureIPVS installs the IPVS tools and loads the modules kube-proxy
+// needs in IPVS mode
+func configureIPVS(dist *distro.Distribution, profile *system.Profile, scheduler string, log *logger.Logger) error {
+	if err := system.InstallIPVSTools(dist, log); err != nil {
+		return err
+	}
+
+	profile.AddIPVS(scheduler)
+	return system.ConfigureSystem(profile, log)
+}
+
+// promptNetworkPlugin asks which network plugin the cluster uses and its options
+func promptNetworkPlugin(networkConfig *network.Config, log *logger.Logger) {
+	pluginOptions := []string{"Calico", "Flannel", "Weave", "Cilium", "Canal"}
+	fmt.Println("Available network plugins:")
+	for i, plugin := range pluginOptions {
+		fmt.Printf("%d. %s\n", i+1, plugin)
+	}
+
+	selectedPlugin := util.PromptWithDefault("Select network plugin (1-5)", "1")
+	pluginIndex, _ := strconv.Atoi(selectedPlugin)
+
+	if pluginIndex >= 1 && pluginIndex <= len(pluginOptions) {
+		pluginName := pluginOptions[pluginIndex-1]
+		networkConfig.Plugin = network.Plugin(strings.ToLower(pluginName))
+	} else {
+		log.Error("Invalid selection, defaulting to Calico")
+		networkConfig.Plugin = network.Calico
+	}
+
+	// If Calico is selected, offer additional configuration options
+	if networkConfig.Plugin == network.Calico {
+		for {
+			prompt := fmt.Sprintf("Calico encapsulation (%s)", strings.Join(network.CalicoEncapsulations, ", "))
+			networkConfig.Encapsulation = util.PromptWithDefault(prompt, networkConfig.Encapsulation)
+			networkConfig.EnableBGP = util.PromptYesNoDefault("Enable BGP?", networkConfig.EnableBGP)
+
+			if err := network.ValidateCalico(networkConfig); err != nil {
+				log.Error("%v", err)
+				continue
+			}
+			break
+		}
+		networkConfig.EnableEncryption = util.PromptYesNoDefault("Enable WireGuard encryption?", networkConfig.EnableEncryption)
+	}
+
+	// Cilium routing, IPAM and Hubble
+	if networkConfig.Plugin == network.Cilium {
+		for {
+			prompt := fmt.Sprintf("Cilium routing mode (%s, %s)", network.RoutingTunnel, network.RoutingNative)
+			networkConfig.RoutingMode = util.PromptWithDefault(prompt, networkConfig.RoutingMode)
+			prompt = fmt.Sprintf("Cilium IPAM mode (%s, %s)", network.IPAMClusterPool, network.IPAMKubernetes)
+			networkConfig.IPAMMode = util.PromptWithDefault(prompt, networkConfig.IPAMMode)
+
+			if err := network.ValidateCilium(networkConfig); err != nil {
+				log.Error("%v", err)
+				continue
+			}
+			break
+		}
+		networkConfig.EnableHubble = util.PromptYesNoDefault("Enable Hubble?", networkConfig.EnableHubble)
+		networkConfig.EnableHubbleUI = networkConfig.EnableHubble && util.PromptYesNoDefault("Enable the Hubble UI?", networkConfig.EnableHubbleUI)
+		networkConfig.EnableEncryption = util.PromptYesNoDefault("Enable WireGuard encryption?", networkConfig.EnableEncryption)
+	}
+
+	// Offer the embedded manifest versions when there is a choice
+	if versions := network.SupportedVersions[networkConfig.Plugin]; len(versions) > 1 {
+		defaultVersion, err := network.PluginVersion(networkConfig)
+		if err != nil {
+			log.Warn("%v", err)
+			defaultVersion = versions[0]
+		}
+		prompt := fmt.Sprintf("%s version (%s)", networkConfig.Plugin, strings.Join(versions, ", "))
+		networkConfig.Version = util.PromptWithDefault(prompt, defaultVersion)
+	}
+}
+
+// nodeIPs returns the addresses of this node and the configured cluster
+// nodes, and the control plane endpoint host
+func nodeIPs(kubeConfig *kubernetes.Config, networkConfig *network.Config) []string {
+	ips := []string{kubeConfig.APIServerAddr}
+	if host, _, err := net.SplitHostPort(kubeConfig.ControlPlaneEndpoint); err == nil {
+		ips = append(ips, host)
+	}
+	if nodeAddress, err := network.SelectNodeAddress(networkConfig); err == nil {
+		for _, addr := range []netip.Addr{nodeAddress.IPv4, nodeAddress.IPv6} {
+			if addr.IsValid() {
+				ips = append(ips, addr.String())
+			}
+		}
+	}
+	if kubeConfig.NodeIP != "" {
+		ips = append(ips, strings.Split(kubeConfig.NodeIP, ",")...)
+	}
+	for _, ip := range kubeConfig.Hosts {
+		ips = append(ips, ip)
+	}
+	return ips
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/pkg/config"
+	"github.com/ochestra-tech/kubeforge/pkg/distro"
+	"github.com/ochestra-tech/kubeforge/pkg/firewall"
+	"github.com/ochestra-tech/kubeforge/pkg/kubernetes"
+	"github.com/ochestra-tech/kubeforge/pkg/network"
+	"github.com/ochestra-tech/kubeforge/pkg/system"
+)
+
+const networkUsage = `usage: kubeforge network migrate --to <cilium|calico|flannel> [--pod-cidr CIDR] [--config FILE]
+       kubeforge network diagnose [--json] [--egress-url URL]
+       kubeforge network policy [--install-canal] [--config FILE]`
+
+// runNetwork runs a "kubeforge network" subcommand on a control plane node
+func runNetwork(args []string, log *logger.Logger) error {
+	if len(args) == 0 {
+		return errors.New(networkUsage)
+	}
+
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:], log)
+	case "diagnose":
+		return runDiagnose(args[1:], log)
+	case "policy":
+		return runPolicy(args[1:], log)
+	default:
+		return fmt.Errorf("unknown network command %q\n%s", args[0], networkUsage)
+	}
+}
+
+// runMigrate moves the cluster to another network plugin
+func runMigrate(args []string, log *logger.Logger) error {
+	flags := flag.NewFlagSet("network migrate", flag.ExitOnError)
+	to := flags.String("to", "", "Network plugin to migrate to: cilium, calico or flannel")
+	podCIDR := flags.String("pod-cidr", "", "Pod CIDR for the new plugin, one the current plugin does not use (default kubernetes.podCIDR)")
+	configPath := flags.String("config", "", "Path to the KubeForge configuration file (default "+config.DefaultPath+" if present)")
+	flags.Parse(args)
+
+	if *to == "" {
+		return errors.New(networkUsage)
+	}
+	if err := network.ValidateMigrationTarget(network.Plugin(*to)); err != nil {
+		return err
+	}
+
+	if !system.CheckRoot() {
+		return fmt.Errorf("this command must be run as root")
+	}
+
+	dist, err := distro.Detect()
+	if err != nil {
+		return fmt.Errorf("error detecting distribution: %v", err)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("error loading configuration: %v", err)
+	}
+
+	networkConfig := cfg.Network
+	networkConfig.Arch = dist.Arch
+	if networkConfig.Plugin != network.Plugin(*to) {
+		// The configured version belongs to the other plugin
+		networkConfig.Version = ""
+	}
+	networkConfig.Plugin = network.Plugin(*to)
+	// kube-proxy is already running
+	networkConfig.KubeProxyReplacement = false
+	networkConfig.EnableeBPF = false
+	networkConfig.PodCIDR = cfg.Kubernetes.PodCIDR
+	if *podCIDR != "" {
+		networkConfig.PodCIDR = *podCIDR
+	}
+
+	// Flannel has to write nftables rules when kube-proxy does
+	client, err := kube.New()
+	if err != nil {
+		return err
+	}
+	proxyMode, _, err := kubernetes.ClusterProxyMode(context.Background(), client)
+	if err != nil {
+		return err
+	}
+	networkConfig.KubeProxyNFTables = proxyMode == kubernetes.ProxyModeNFTables
+
+	// Kernel modules, sysctls and ports for the new plugin on this node
+	profile := system.DefaultProfile()
+	profile.AddModules(cfg.System.KernelModules...)
+	profile.SetSysctls(cfg.System.Sysctls)
+	profile.AddNetwork(networkConfig)
+	if err := system.ConfigureSystem(profile, log); err != nil {
+		return fmt.Errorf("failed to configure system: %v", err)
+	}
+
+	// Open the new plugin's ports here and on every other node before it
+	// starts; the old plugin's ports are only closed on this node
+	network.SetMigrationTunnelPort(networkConfig)
+	if err := firewall.Configure(true, networkConfig, log); err != nil {
+		return fmt.Errorf("failed to configure firewall: %v", err)
+	}
+
+	nodeSetup := firewall.OpenScript(firewall.PluginPorts(networkConfig))
+	from, err := network.Migrate(networkConfig, nodeSetup, log)
+	if err != nil {
+		return err
+	}
+
+	return firewall.Teardown(from, networkConfig, log)
+}
+
+// runDiagnose checks pod, Service, DNS, egress and host networking across
+// all nodes and prints the results
+func runDiagnose(args []string, log *logger.Logger) error {
+	flags := flag.NewFlagSet("network diagnose", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the results as JSON")
+	egressURL := flags.String("egress-url", network.DefaultEgressURL, "URL the egress check fetches, empty to skip it")
+	flags.Parse(args)
+
+	if *asJSON {
+		// Keep stdout for the JSON document
+		log.InfoLogger.SetOutput(os.Stderr)
+		log.WarnLogger.SetOutput(os.Stderr)
+	}
+
+	diagnosis, err := network.RunDiagnostics(network.DiagnoseOptions{EgressURL: *egressURL}, log)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(diagnosis)
+	} else {
+		err = diagnosis.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+
+	if diagnosis.Failed > 0 {
+		return fmt.Errorf("%d of %d network checks failed", diagnosis.Failed, len(diagnosis.Results))
+	}
+	return nil
+}
+
+// runPolicy verifies that NetworkPolicy is enforced and, on Flannel, can
+// switch the cluster to Canal to enforce it
+func runPolicy(args []string, log *logger.Logger) error {
+	flags := flag.NewFlagSet("network policy", flag.ExitOnError)
+	installCanal := flags.Bool("install-canal", false, "Replace Flannel with Canal if policies are not enforced")
+	configPath := flags.String("config", "", "Path to the KubeForge configuration file (default "+config.DefaultPath+" if present)")
+	flags.Parse(args)
+
+	check, err := network.CheckPolicyEnforcement(log)
+	if err != nil {
+		return err
+	}
+	if check.Enforced() {
+		return nil
+	}
+	if check.Plugin != network.Flannel || !*installCanal {
+		if check.Plugin == network.Flannel {
+			return errors.New("NetworkPolicy is not enforced, run \"kubeforge network policy --install-canal\" to add Calico's policy enforcement")
+		}
+		return fmt.Errorf("NetworkPolicy is not enforced by %s", check.Plugin)
+	}
+
+	if !system.CheckRoot() {
+		return fmt.Errorf("this command must be run as root")
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("error loading configuration: %v", err)
+	}
+
+	networkConfig := cfg.Network
+	networkConfig.Arch = distro.HostArch()
+	networkConfig.PodCIDR = cfg.Kubernetes.PodCIDR
+	if err := network.EnableCanal(networkConfig, log); err != nil {
+		return err
+	}
+
+	if err := firewall.Configure(true, networkConfig, log); err != nil {
+		return fmt.Errorf("failed to configure firewall: %v", err)
+	}
+	if err := firewall.Teardown(network.Flannel, networkConfig, log); err != nil {
+		return fmt.Errorf("failed to configure firewall: %v", err)
+	}
+
+	check, err = network.CheckPolicyEnforcement(log)
+	if err != nil {
+		return err
+	}
+	if !check.Enforced() {
+		return fmt.Errorf("NetworkPolicy is still not enforced by %s", check.Plugin)
+	}
+	return nil
+}
+
+// runSubcommand runs the subcommand named by the first argument, if any,
+// and reports whether there was one
+func runSubcommand(log *logger.Logger) bool {
+	if len(os.Args) < 2 || os.Args[1] != "network" {
+		return false
+	}
+
+	if err := runNetwork(os.Args[2:], log); err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+	return true
+}
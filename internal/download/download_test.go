@@ -0,0 +1,193 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+)
+
+// testBody is what the test server serves
+var testBody = bytes.Repeat([]byte("kubeforge download test\n"), 1000)
+
+// testDownloader returns a Downloader caching in a temporary directory
+func testDownloader(t *testing.T) *Downloader {
+	d := New(logger.New())
+	d.CacheDir = t.TempDir()
+	return d
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFetchResumesCutOffDownload(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// Promise the whole file, send half of it and drop the connection
+			w.Header().Set("Content-Length", fmt.Sprint(len(testBody)))
+			w.Write(testBody[:len(testBody)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		want := fmt.Sprintf("bytes=%d-", len(testBody)/2)
+		if got := r.Header.Get("Range"); got != want {
+			t.Errorf("retry asked for range %q, want %q", got, want)
+		}
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(testBody))
+	}))
+	defer server.Close()
+
+	d := testDownloader(t)
+	data, err := d.FetchBytes(server.URL+"/file", Options{SHA256: sha256Hex(testBody)})
+	if err != nil {
+		t.Fatalf("FetchBytes() = %v", err)
+	}
+	if !bytes.Equal(data, testBody) {
+		t.Errorf("FetchBytes() returned %d bytes, want the %d served", len(data), len(testBody))
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("server got %d requests, want 2", got)
+	}
+}
+
+func TestFetchSHA256Mismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testBody)
+	}))
+	defer server.Close()
+
+	d := testDownloader(t)
+	_, err := d.Fetch(server.URL+"/file", Options{SHA256: sha256Hex([]byte("something else"))})
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("Fetch() = %v, want a sha256 mismatch", err)
+	}
+
+	dest := d.cachePath(server.URL + "/file")
+	for _, file := range []string{dest, dest + ".part"} {
+		if _, err := os.Stat(file); err == nil {
+			t.Errorf("%s was kept after the mismatch", file)
+		}
+	}
+}
+
+func TestFetchReusesVerifiedCache(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write(testBody)
+	}))
+	defer server.Close()
+
+	d := testDownloader(t)
+	url := server.URL + "/file"
+
+	for i := 0; i < 2; i++ {
+		if _, err := d.Fetch(url, Options{SHA256: sha256Hex(testBody)}); err != nil {
+			t.Fatalf("Fetch() = %v", err)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("server got %d requests for a verified file, want 1", got)
+	}
+
+	// A corrupted cache entry fails verification and is downloaded again
+	if err := os.WriteFile(d.cachePath(url), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Fetch(url, Options{SHA256: sha256Hex(testBody)}); err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("server got %d requests after corrupting the cache, want 2", got)
+	}
+
+	// Without a checksum the copy is never trusted
+	if _, err := d.Fetch(url, Options{}); err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("server got %d requests for an unverified file, want 3", got)
+	}
+}
+
+func TestPublishedChecksum(t *testing.T) {
+	sum := sha256Hex([]byte("Packages"))
+	md5 := "0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name    string
+		data    string
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{"bare sum", sum + "\n", "anything", sum, false},
+		{"sha256sum", sha256Hex([]byte("other")) + "  other.tar.gz\n" + sum + "  file.tar.gz\n", "file.tar.gz", sum, false},
+		{"binary mode", sum + " *file.tar.gz\n", "file.tar.gz", sum, false},
+		{"upper case", strings.ToUpper(sum) + "  file.tar.gz\n", "file.tar.gz", sum, false},
+		{
+			"apt release",
+			"Origin: Docker\nMD5Sum:\n " + md5 + " 1234 stable/binary-amd64/Packages\nSHA256:\n " + sum + " 1234 stable/binary-amd64/Packages\n",
+			"stable/binary-amd64/Packages", sum, false,
+		},
+		{"apt release without sha256", "MD5Sum:\n " + md5 + " 1234 Packages\n", "Packages", "", true},
+		{"missing entry", sum + "  other.tar.gz\n", "file.tar.gz", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := publishedChecksum([]byte(tt.data), tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("publishedChecksum() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("publishedChecksum() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDearmor(t *testing.T) {
+	key := []byte{0x99, 0x01, 0x0d, 0x04, 0x5e, 0x10, 0x20, 0x30}
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []byte
+		wantErr bool
+	}{
+		{"armored", "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n" + encoded + "\n=abcd\n-----END PGP PUBLIC KEY BLOCK-----\n", key, false},
+		{"armor headers", "-----BEGIN PGP PUBLIC KEY BLOCK-----\nVersion: GnuPG v2\nComment: test\n\n" + encoded + "\n=abcd\n-----END PGP PUBLIC KEY BLOCK-----\n", key, false},
+		{"no blank line after the header", "-----BEGIN PGP PUBLIC KEY BLOCK-----\n" + encoded + "\n-----END PGP PUBLIC KEY BLOCK-----\n", key, false},
+		{"text before the block", "Here is our key:\r\n-----BEGIN PGP PUBLIC KEY BLOCK-----\r\n\r\n" + encoded + "\r\n-----END PGP PUBLIC KEY BLOCK-----\r\n", key, false},
+		{"binary", string(key), key, false},
+		{"unterminated", "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n" + encoded + "\n", nil, true},
+		{"other armor", "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Dearmor([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dearmor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Dearmor() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package download
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Fingerprints of the repository signing keys KubeForge trusts
+const (
+	DockerKeyFingerprint     = "9DC858229FC7DD38854AE2D88D81803C0EBFCD88"
+	KubernetesKeyFingerprint = "DE15B14486CD377B9E876E1A234654DA9A296436"
+)
+
+// InstallKey downloads an armored public key, checks that it carries the
+// expected fingerprint and writes it to dest as a binary keyring for apt
+func (d *Downloader) InstallKey(url, fingerprint, dest string) error {
+	file, err := d.Fetch(url, Options{})
+	if err != nil {
+		return err
+	}
+
+	if err := verifyFingerprint(file, fingerprint); err != nil {
+		return fmt.Errorf("key %s: %v", url, err)
+	}
+
+	armored, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	key, err := Dearmor(armored)
+	if err != nil {
+		return fmt.Errorf("key %s: %v", url, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dest, key, 0644)
+}
+
+// VerifyRepository checks the detached signature of a repository's signed
+// index, such as an apt Release file, against keyring, then checks the
+// package index at indexURL against the SHA256 the signed index publishes
+// for it. Run after InstallKey it proves the key really signs the
+// repository before apt is pointed at it.
+func (d *Downloader) VerifyRepository(url, signatureURL, indexURL, keyring string) error {
+	release, err := d.FetchBytes(url, Options{SignatureURL: signatureURL, Keyring: keyring})
+	if err != nil {
+		return err
+	}
+
+	// Release files list their indexes relative to their own directory
+	name := strings.TrimPrefix(indexURL, path.Dir(url)+"/")
+	sum, err := publishedChecksum(release, name)
+	if err != nil {
+		return fmt.Errorf("%s: %v", url, err)
+	}
+
+	_, err = d.Fetch(indexURL, Options{SHA256: sum})
+	return err
+}
+
+// verifyFingerprint checks that the key file contains a key with the given fingerprint
+func verifyFingerprint(file, fingerprint string) error {
+	output, err := exec.Command("gpg", "--show-keys", "--with-colons", file).Output()
+	if err != nil {
+		return fmt.Errorf("failed to read key: %v", err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		// fpr:::::::::<fingerprint>:
+		fields := strings.Split(line, ":")
+		if len(fields) > 9 && fields[0] == "fpr" && strings.EqualFold(fields[9], fingerprint) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("fingerprint %s not found", fingerprint)
+}
+
+// Dearmor converts an ASCII-armored OpenPGP block to its binary form,
+// like gpg --dearmor. Binary input is returned unchanged.
+func Dearmor(data []byte) ([]byte, error) {
+	text := string(data)
+	begin := strings.Index(text, "-----BEGIN PGP")
+	if begin < 0 {
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----")) {
+			return nil, fmt.Errorf("unsupported armor")
+		}
+		return data, nil
+	}
+
+	lines := strings.Split(text[begin:], "\n")
+	var body strings.Builder
+	inHeaders := true
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "-----END PGP"):
+			return base64.StdEncoding.DecodeString(body.String())
+		case inHeaders:
+			// Armor headers end at the first blank line
+			if line == "" {
+				inHeaders = false
+			} else if !strings.Contains(line, ": ") {
+				inHeaders = false
+				body.WriteString(line)
+			}
+		case strings.HasPrefix(line, "="):
+			// CRC24 checksum line
+		default:
+			body.WriteString(line)
+		}
+	}
+
+	return nil, fmt.Errorf("unterminated armor block")
+}
@@ -0,0 +1,226 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+// mappingTimeout bounds how long to wait for a CRD applied earlier in
+// the same manifest to be served
+const mappingTimeout = 30 * time.Second
+
+// DecodeManifest splits a multi-document YAML or JSON manifest into objects,
+// expanding List kinds and skipping empty documents
+func DecodeManifest(manifest []byte) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 4096)
+
+	var objects []*unstructured.Unstructured
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to decode manifest: %v", err)
+		}
+
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("manifest object %q has no apiVersion or kind", obj.GetName())
+		}
+
+		if obj.IsList() {
+			err := obj.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode %s: %v", obj.GetKind(), err)
+			}
+			continue
+		}
+
+		objects = append(objects, obj)
+	}
+
+	return objects, nil
+}
+
+// Apply server-side applies every object in a manifest, in order
+func (c *Client) Apply(ctx context.Context, manifest []byte) error {
+	return c.apply(ctx, manifest, false)
+}
+
+// ForceApply server-side applies every object in a manifest, in order,
+// taking over fields another field manager owns, like kubectl apply
+// --server-side --force-conflicts. It is for objects KubeForge owns, such
+// as a network plugin it reinstalls over one applied by kubectl.
+func (c *Client) ForceApply(ctx context.Context, manifest []byte) error {
+	return c.apply(ctx, manifest, true)
+}
+
+// apply applies the objects of a manifest, forcing conflicts if asked to
+func (c *Client) apply(ctx context.Context, manifest []byte, force bool) error {
+	objects, err := DecodeManifest(manifest)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if err := c.applyObject(ctx, obj, force); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyObject server-side applies a single object. Fields owned by another
+// field manager are not taken over; the conflict is returned as a *ConflictError.
+func (c *Client) ApplyObject(ctx context.Context, obj *unstructured.Unstructured) error {
+	return c.applyObject(ctx, obj, false)
+}
+
+// applyObject server-side applies a single object, forcing conflicts if asked to
+func (c *Client) applyObject(ctx context.Context, obj *unstructured.Unstructured, force bool) error {
+	gvk := obj.GroupVersionKind()
+
+	resource, err := c.resourceFor(ctx, obj, true)
+	if err != nil {
+		return &ApplyError{GVK: gvk, Namespace: obj.GetNamespace(), Name: obj.GetName(), Err: err}
+	}
+
+	_, err = resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: c.FieldManager, Force: force})
+	if apierrors.IsConflict(err) {
+		conflict := &ConflictError{GVK: gvk, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+		if status, ok := err.(apierrors.APIStatus); ok && status.Status().Details != nil {
+			conflict.Causes = status.Status().Details.Causes
+		}
+		return conflict
+	}
+	if err != nil {
+		return &ApplyError{GVK: gvk, Namespace: obj.GetNamespace(), Name: obj.GetName(), Err: err}
+	}
+
+	return nil
+}
+
+// MergePatch applies a JSON merge patch to an existing object
+func (c *Client) MergePatch(ctx context.Context, apiVersion, kind, namespace, name string, patch []byte) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	gvk := obj.GroupVersionKind()
+
+	resource, err := c.resourceFor(ctx, obj, true)
+	if err == nil {
+		_, err = resource.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: c.FieldManager})
+	}
+	if err != nil {
+		return &ApplyError{GVK: gvk, Namespace: namespace, Name: name, Err: err}
+	}
+
+	return nil
+}
+
+// Delete removes every object in a manifest, ignoring ones already gone
+func (c *Client) Delete(ctx context.Context, manifest []byte) error {
+	objects, err := DecodeManifest(manifest)
+	if err != nil {
+		return err
+	}
+
+	// Delete in reverse so namespaced objects go before their namespace and CRDs
+	for i := len(objects) - 1; i >= 0; i-- {
+		obj := objects[i]
+
+		resource, err := c.resourceFor(ctx, obj, false)
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = resource.Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s: %v", objectRef(obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName()), err)
+		}
+	}
+
+	return nil
+}
+
+// resourceFor maps an object to its dynamic resource client, scoped to
+// the object's namespace (or "default") for namespaced kinds. With
+// waitForCRD, kinds the cluster doesn't serve yet are retried for a while.
+func (c *Client) resourceFor(ctx context.Context, obj *unstructured.Unstructured, waitForCRD bool) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+
+	mapping, err := c.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) && waitForCRD {
+		mapping, err = c.waitForMapping(ctx, obj)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return c.Dynamic.Resource(mapping.Resource), nil
+	}
+
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	return c.Dynamic.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// waitForMapping resets cached discovery until the object's kind is served
+func (c *Client) waitForMapping(ctx context.Context, obj *unstructured.Unstructured) (*meta.RESTMapping, error) {
+	gvk := obj.GroupVersionKind()
+
+	resettable, ok := c.Mapper.(meta.ResettableRESTMapper)
+	if !ok {
+		return nil, fmt.Errorf("kind %s is not served by the cluster", gvk)
+	}
+
+	var mapping *meta.RESTMapping
+	err := wait.PollUntilContextTimeout(ctx, time.Second, mappingTimeout, true, func(ctx context.Context) (bool, error) {
+		resettable.Reset()
+
+		var err error
+		mapping, err = c.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		if wait.Interrupted(err) {
+			return nil, fmt.Errorf("kind %s is not served by the cluster", gvk)
+		}
+		return nil, err
+	}
+
+	return mapping, nil
+}
@@ -0,0 +1,339 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	configMapGVK = schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	namespaceGVK = schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}
+	ippoolGVK    = schema.GroupVersionKind{Group: "crd.projectcalico.org", Version: "v1", Kind: "IPPool"}
+
+	configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	namespaceGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	ippoolGVR    = schema.GroupVersionResource{Group: "crd.projectcalico.org", Version: "v1", Resource: "ippools"}
+)
+
+const testManifest = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: test
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: test
+data:
+  key: value
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: defaulted
+`
+
+// testMapper maps the core kinds used in the tests
+func testMapper() *meta.DefaultRESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(configMapGVK, meta.RESTScopeNamespace)
+	mapper.Add(namespaceGVK, meta.RESTScopeRoot)
+	return mapper
+}
+
+// newTestClient returns a client on the client-go fakes and the fake
+// dynamic client, whose actions the tests inspect
+func newTestClient(mapper meta.RESTMapper) (*Client, *dynamicfake.FakeDynamicClient) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			configMapGVR: "ConfigMapList",
+			namespaceGVR: "NamespaceList",
+			ippoolGVR:    "IPPoolList",
+		})
+	return NewForClients(fake.NewSimpleClientset(), dynamicClient, mapper), dynamicClient
+}
+
+// applyAction is a server-side apply seen by the fake dynamic client
+type applyAction struct {
+	resource  string
+	namespace string
+	name      string
+	patch     string
+}
+
+// recordApplies answers every apply patch with the patched object and
+// records it
+func recordApplies(dynamicClient *dynamicfake.FakeDynamicClient) *[]applyAction {
+	var applies []applyAction
+	dynamicClient.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		applies = append(applies, applyAction{
+			resource:  patch.GetResource().Resource,
+			namespace: patch.GetNamespace(),
+			name:      patch.GetName(),
+			patch:     string(patch.GetPatch()),
+		})
+
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		return true, obj, nil
+	})
+	return &applies
+}
+
+func TestDecodeManifest(t *testing.T) {
+	manifest := testManifest + `---
+# only a comment
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: listed
+`
+	objects, err := DecodeManifest([]byte(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, obj := range objects {
+		names = append(names, obj.GetKind()+"/"+obj.GetName())
+	}
+	want := "Namespace/test ConfigMap/settings ConfigMap/defaulted ConfigMap/listed"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("objects = %s, want %s", got, want)
+	}
+
+	if _, err := DecodeManifest([]byte("metadata:\n  name: nokind\n")); err == nil {
+		t.Error("object without apiVersion and kind was accepted")
+	}
+}
+
+func TestApply(t *testing.T) {
+	client, dynamicClient := newTestClient(testMapper())
+	applies := recordApplies(dynamicClient)
+
+	if err := client.Apply(context.Background(), []byte(testManifest)); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	want := []applyAction{
+		{resource: "namespaces", name: "test"},
+		{resource: "configmaps", namespace: "test", name: "settings"},
+		{resource: "configmaps", namespace: metav1.NamespaceDefault, name: "defaulted"},
+	}
+	if len(*applies) != len(want) {
+		t.Fatalf("got %d applies, want %d", len(*applies), len(want))
+	}
+	for i, got := range *applies {
+		if got.resource != want[i].resource || got.namespace != want[i].namespace || got.name != want[i].name {
+			t.Errorf("apply %d = %s %s/%s, want %s %s/%s", i,
+				got.resource, got.namespace, got.name, want[i].resource, want[i].namespace, want[i].name)
+		}
+	}
+	if !strings.Contains((*applies)[1].patch, `"key":"value"`) {
+		t.Errorf("apply patch %s lacks the object's data", (*applies)[1].patch)
+	}
+
+	// The fake dynamic client drops the apply options, so check what the
+	// client sends them with
+	if client.FieldManager != FieldManager {
+		t.Errorf("field manager = %q, want %q", client.FieldManager, FieldManager)
+	}
+}
+
+func TestApplyConflict(t *testing.T) {
+	client, dynamicClient := newTestClient(testMapper())
+	recordApplies(dynamicClient)
+	dynamicClient.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		err := apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, "settings", errors.New("Apply failed with 1 conflict"))
+		err.ErrStatus.Details.Causes = []metav1.StatusCause{{
+			Type:    metav1.CauseTypeFieldManagerConflict,
+			Message: `conflict with "kubectl-edit": .data.key`,
+			Field:   ".data.key",
+		}}
+		return true, nil, err
+	})
+
+	err := client.Apply(context.Background(), []byte(testManifest))
+
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("error %v (%T) is not a *ConflictError", err, err)
+	}
+	if conflict.GVK != configMapGVK || conflict.Namespace != "test" || conflict.Name != "settings" {
+		t.Errorf("conflict on %s %s/%s", conflict.GVK, conflict.Namespace, conflict.Name)
+	}
+	if len(conflict.Causes) != 1 || conflict.Causes[0].Field != ".data.key" {
+		t.Errorf("causes = %v", conflict.Causes)
+	}
+	want := `conflict applying ConfigMap/test/settings: conflict with "kubectl-edit": .data.key`
+	if err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestApplyError(t *testing.T) {
+	client, dynamicClient := newTestClient(testMapper())
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "test", errors.New("denied"))
+	dynamicClient.PrependReactor("patch", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, forbidden
+	})
+
+	err := client.Apply(context.Background(), []byte(testManifest))
+
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("error %v (%T) is not an *ApplyError", err, err)
+	}
+	if applyErr.GVK != namespaceGVK || applyErr.Name != "test" {
+		t.Errorf("error for %s %s", applyErr.GVK, applyErr.Name)
+	}
+	if !apierrors.IsForbidden(err) {
+		t.Errorf("ApplyError does not unwrap to the API error: %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "failed to apply Namespace/test: ") {
+		t.Errorf("error = %q", err)
+	}
+
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		t.Error("a forbidden error was reported as a conflict")
+	}
+}
+
+func TestApplyUnknownKind(t *testing.T) {
+	// A plain DefaultRESTMapper can't be reset, so the kind is reported at once
+	client, _ := newTestClient(testMapper())
+	manifest := "apiVersion: crd.projectcalico.org/v1\nkind: IPPool\nmetadata:\n  name: pool\n"
+
+	err := client.Apply(context.Background(), []byte(manifest))
+
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("error %v (%T) is not an *ApplyError", err, err)
+	}
+	if !strings.Contains(err.Error(), "is not served by the cluster") {
+		t.Errorf("error = %q", err)
+	}
+}
+
+// resettingMapper starts serving a kind after it has been reset a number
+// of times, like discovery picking up a CRD that was just created
+type resettingMapper struct {
+	*meta.DefaultRESTMapper
+	gvk    schema.GroupVersionKind
+	scope  meta.RESTScope
+	after  int
+	resets int
+}
+
+func (m *resettingMapper) Reset() {
+	m.resets++
+	if m.resets == m.after {
+		m.Add(m.gvk, m.scope)
+	}
+}
+
+func TestWaitForMapping(t *testing.T) {
+	mapper := &resettingMapper{DefaultRESTMapper: testMapper(), gvk: ippoolGVK, scope: meta.RESTScopeRoot, after: 2}
+	client, dynamicClient := newTestClient(mapper)
+	applies := recordApplies(dynamicClient)
+
+	manifest := "apiVersion: crd.projectcalico.org/v1\nkind: IPPool\nmetadata:\n  name: pool\n"
+	if err := client.Apply(context.Background(), []byte(manifest)); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	if mapper.resets != 2 {
+		t.Errorf("discovery was reset %d times, want 2", mapper.resets)
+	}
+	if len(*applies) != 1 || (*applies)[0].resource != "ippools" || (*applies)[0].namespace != "" {
+		t.Errorf("applies = %+v", *applies)
+	}
+}
+
+func TestWaitForMappingCancelled(t *testing.T) {
+	mapper := &resettingMapper{DefaultRESTMapper: testMapper(), gvk: ippoolGVK, scope: meta.RESTScopeRoot}
+	client, _ := newTestClient(mapper)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(ippoolGVK)
+	obj.SetName("pool")
+	_, err := client.waitForMapping(ctx, obj)
+	if err == nil || !strings.Contains(err.Error(), "is not served by the cluster") {
+		t.Errorf("error = %v, want the kind reported as not served", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	client, dynamicClient := newTestClient(testMapper())
+
+	var deleted []string
+	dynamicClient.PrependReactor("delete", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.DeleteAction).GetName()
+		deleted = append(deleted, action.GetResource().Resource+"/"+name)
+		if name == "defaulted" {
+			return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+		}
+		return true, nil, nil
+	})
+
+	// Kinds the cluster doesn't serve are already gone
+	manifest := testManifest + "---\napiVersion: crd.projectcalico.org/v1\nkind: IPPool\nmetadata:\n  name: pool\n"
+	if err := client.Delete(context.Background(), []byte(manifest)); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	want := "configmaps/defaulted configmaps/settings namespaces/test"
+	if got := strings.Join(deleted, " "); got != want {
+		t.Errorf("deleted %s, want %s", got, want)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	client, dynamicClient := newTestClient(testMapper())
+
+	var patched string
+	dynamicClient.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.MergePatchType {
+			t.Errorf("patch type = %s", patch.GetPatchType())
+		}
+		patched = patch.GetNamespace() + "/" + patch.GetName() + " " + string(patch.GetPatch())
+		return true, &unstructured.Unstructured{}, nil
+	})
+
+	err := client.MergePatch(context.Background(), "v1", "ConfigMap", "kube-system", "kube-proxy", []byte(`{"data":{"a":"b"}}`))
+	if err != nil {
+		t.Fatalf("MergePatch: %v", err)
+	}
+	if want := `kube-system/kube-proxy {"data":{"a":"b"}}`; patched != want {
+		t.Errorf("patched %s, want %s", patched, want)
+	}
+}
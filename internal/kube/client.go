@@ -0,0 +1,128 @@
+// Package kube is KubeForge's client for the Kubernetes API. It applies
+// manifests with server-side apply in place of shelling out to kubectl.
+package kube
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/ochestra-tech/kubeforge/pkg/proxy"
+)
+
+// AdminKubeconfig is the cluster-admin kubeconfig written by kubeadm init
+const AdminKubeconfig = "/etc/kubernetes/admin.conf"
+
+// FieldManager identifies KubeForge as the owner of the fields it applies
+const FieldManager = "kubeforge"
+
+// Client bundles the typed and dynamic clients for one cluster
+type Client struct {
+	Clientset    kubernetes.Interface
+	Dynamic      dynamic.Interface
+	Mapper       meta.RESTMapper
+	FieldManager string
+
+	// config is nil for clients built from fakes, which can't exec into pods
+	config *rest.Config
+}
+
+// clients holds the client of each kubeconfig New has loaded, so every
+// operation in a run shares one discovery cache instead of rebuilding it
+var (
+	clientsMu sync.Mutex
+	clients   = make(map[string]*Client)
+)
+
+// New returns the client for the kubeconfig in $KUBECONFIG, or the kubeadm
+// admin kubeconfig if that is unset. The client is created on first use
+// and shared afterwards.
+func New() (*Client, error) {
+	path := KubeconfigPath()
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if client, ok := clients[path]; ok {
+		return client, nil
+	}
+
+	client, err := NewFromKubeconfig(path)
+	if err != nil {
+		return nil, err
+	}
+	clients[path] = client
+	return client, nil
+}
+
+// KubeconfigPath returns $KUBECONFIG, or the kubeadm admin kubeconfig if
+// that is unset
+func KubeconfigPath() string {
+	if path := os.Getenv("KUBECONFIG"); path != "" {
+		return path
+	}
+	return AdminKubeconfig
+}
+
+// NewFromKubeconfig returns a client for the kubeconfig at path
+func NewFromKubeconfig(path string) (*Client, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, &KubeconfigError{Path: path, Err: err}
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", path)
+	if err != nil {
+		return nil, &KubeconfigError{Path: path, Err: err}
+	}
+	// client-go would use the cached http.ProxyFromEnvironment
+	config.Proxy = proxy.FromEnvironment
+
+	return NewForConfig(config)
+}
+
+// NewForConfig returns a client for a REST config
+func NewForConfig(config *rest.Config) (*Client, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
+	}
+
+	// Discovery is cached and reset when a kind is missing, so CRDs
+	// created earlier in a manifest become usable later in the same one
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+
+	client := NewForClients(clientset, dynamicClient, mapper)
+	client.config = config
+	return client, nil
+}
+
+// NewForClients wraps existing clients, such as the client-go fakes
+func NewForClients(clientset kubernetes.Interface, dynamicClient dynamic.Interface, mapper meta.RESTMapper) *Client {
+	return &Client{
+		Clientset:    clientset,
+		Dynamic:      dynamicClient,
+		Mapper:       mapper,
+		FieldManager: FieldManager,
+	}
+}
+
+// Host returns the API server URL, or "" for clients built from fakes
+func (c *Client) Host() string {
+	if c.config == nil {
+		return ""
+	}
+	return c.config.Host
+}
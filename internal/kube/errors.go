@@ -0,0 +1,66 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// KubeconfigError reports a kubeconfig that is missing or unusable
+type KubeconfigError struct {
+	Path string
+	Err  error
+}
+
+func (e *KubeconfigError) Error() string {
+	return fmt.Sprintf("failed to load kubeconfig %s: %v", e.Path, e.Err)
+}
+
+func (e *KubeconfigError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyError reports an object that could not be applied
+type ApplyError struct {
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+	Err       error
+}
+
+func (e *ApplyError) Error() string {
+	return fmt.Sprintf("failed to apply %s: %v", objectRef(e.GVK, e.Namespace, e.Name), e.Err)
+}
+
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// ConflictError reports fields of an object that are owned by another
+// field manager, which server-side apply refuses to take over
+type ConflictError struct {
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+	Causes    []metav1.StatusCause
+}
+
+func (e *ConflictError) Error() string {
+	fields := make([]string, 0, len(e.Causes))
+	for _, cause := range e.Causes {
+		fields = append(fields, cause.Message)
+	}
+
+	return fmt.Sprintf("conflict applying %s: %s", objectRef(e.GVK, e.Namespace, e.Name), strings.Join(fields, "; "))
+}
+
+// objectRef formats an object as kind/namespace/name for messages
+func objectRef(gvk schema.GroupVersionKind, namespace, name string) string {
+	if namespace == "" {
+		return fmt.Sprintf("%s/%s", gvk.Kind, name)
+	}
+
+	return fmt.Sprintf("%s/%s/%s", gvk.Kind, namespace, name)
+}
@@ -0,0 +1,457 @@
+// Package wait blocks until cluster resources are ready. Readiness is
+// judged the way kubectl rollout status does: pod Ready conditions,
+// DaemonSet and Deployment rollout counters and node Ready conditions.
+// Deadlines come from the context; on timeout the pods holding things up
+// are logged with the reason they are stuck.
+package wait
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+)
+
+// TimeoutError reports what was not ready when the deadline passed
+type TimeoutError struct {
+	What   string
+	Reason string
+	Stuck  []PodStatus
+}
+
+func (e *TimeoutError) Error() string {
+	msg := fmt.Sprintf("timed out waiting for %s", e.What)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if len(e.Stuck) > 0 {
+		msg += fmt.Sprintf(" (%d pods not ready)", len(e.Stuck))
+	}
+	return msg
+}
+
+// PodStatus describes why a pod is not ready
+type PodStatus struct {
+	Namespace string
+	Name      string
+	Node      string
+	Reason    string
+}
+
+// Pods waits until at least one pod matches selector in namespace (all
+// namespaces if empty) and every matching pod is Ready. Pods that ran to
+// completion are ignored.
+func Pods(ctx context.Context, client *kube.Client, namespace, selector string, log *logger.Logger) error {
+	var pods map[string]*corev1.Pod
+	start := func() { pods = make(map[string]*corev1.Pod) }
+
+	err := until(ctx, podListWatch(client, namespace, selector), &corev1.Pod{}, start, func(event watch.Event) (bool, error) {
+		pod, ok := event.Object.(*corev1.Pod)
+		if !ok {
+			return false, nil
+		}
+
+		key := pod.Namespace + "/" + pod.Name
+		if event.Type == watch.Deleted || pod.Status.Phase == corev1.PodSucceeded {
+			delete(pods, key)
+		} else {
+			pods[key] = pod
+		}
+
+		if len(pods) == 0 {
+			return false, nil
+		}
+		for _, p := range pods {
+			if !PodReady(p) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+
+	if isTimeout(ctx, err) {
+		reason := ""
+		if len(pods) == 0 {
+			reason = "no matching pods"
+		}
+		return timeout(ctx, client, "pods "+selector, reason, namespace, selector, log)
+	}
+	return err
+}
+
+// DaemonSet waits until a DaemonSet exists and has an up-to-date, ready
+// pod on every node it is scheduled to
+func DaemonSet(ctx context.Context, client *kube.Client, namespace, name string, log *logger.Logger) error {
+	daemonSets := client.Clientset.AppsV1().DaemonSets(namespace)
+	lw := namedListWatch(name,
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return daemonSets.List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return daemonSets.Watch(ctx, options)
+		})
+
+	var last *appsv1.DaemonSet
+	err := until(ctx, lw, &appsv1.DaemonSet{}, nil, func(event watch.Event) (bool, error) {
+		ds, ok := event.Object.(*appsv1.DaemonSet)
+		if !ok || event.Type == watch.Deleted {
+			last = nil
+			return false, nil
+		}
+
+		last = ds
+		return DaemonSetReady(ds), nil
+	})
+
+	if isTimeout(ctx, err) {
+		what := fmt.Sprintf("daemonset %s/%s", namespace, name)
+		if last == nil {
+			return timeout(ctx, client, what, "not found", "", "", log)
+		}
+
+		reason := fmt.Sprintf("%d of %d pods ready", last.Status.NumberReady, last.Status.DesiredNumberScheduled)
+		return timeout(ctx, client, what, reason, namespace, metav1.FormatLabelSelector(last.Spec.Selector), log)
+	}
+	return err
+}
+
+// Deployment waits until a Deployment exists, has rolled out its latest
+// spec and reports the Available condition
+func Deployment(ctx context.Context, client *kube.Client, namespace, name string, log *logger.Logger) error {
+	deployments := client.Clientset.AppsV1().Deployments(namespace)
+	lw := namedListWatch(name,
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return deployments.List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return deployments.Watch(ctx, options)
+		})
+
+	var last *appsv1.Deployment
+	err := until(ctx, lw, &appsv1.Deployment{}, nil, func(event watch.Event) (bool, error) {
+		deployment, ok := event.Object.(*appsv1.Deployment)
+		if !ok || event.Type == watch.Deleted {
+			last = nil
+			return false, nil
+		}
+
+		last = deployment
+		return DeploymentAvailable(deployment), nil
+	})
+
+	if isTimeout(ctx, err) {
+		what := fmt.Sprintf("deployment %s/%s", namespace, name)
+		if last == nil {
+			return timeout(ctx, client, what, "not found", "", "", log)
+		}
+
+		reason := fmt.Sprintf("%d of %d replicas available", last.Status.AvailableReplicas, replicas(last))
+		return timeout(ctx, client, what, reason, namespace, metav1.FormatLabelSelector(last.Spec.Selector), log)
+	}
+	return err
+}
+
+// Nodes waits until every node in the cluster is Ready
+func Nodes(ctx context.Context, client *kube.Client, log *logger.Logger) error {
+	nodesClient := client.Clientset.CoreV1().Nodes()
+	lw := &cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return nodesClient.List(ctx, options)
+		},
+		WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return nodesClient.Watch(ctx, options)
+		},
+	}
+
+	var nodes map[string]*corev1.Node
+	start := func() { nodes = make(map[string]*corev1.Node) }
+
+	err := until(ctx, lw, &corev1.Node{}, start, func(event watch.Event) (bool, error) {
+		node, ok := event.Object.(*corev1.Node)
+		if !ok {
+			return false, nil
+		}
+
+		if event.Type == watch.Deleted {
+			delete(nodes, node.Name)
+		} else {
+			nodes[node.Name] = node
+		}
+
+		if len(nodes) == 0 {
+			return false, nil
+		}
+		for _, n := range nodes {
+			if !NodeReady(n) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+
+	if isTimeout(ctx, err) {
+		var notReady []string
+		for name, node := range nodes {
+			if !NodeReady(node) {
+				notReady = append(notReady, fmt.Sprintf("%s (%s)", name, nodeReadyMessage(node)))
+			}
+		}
+		sort.Strings(notReady)
+
+		for _, n := range notReady {
+			log.Warn("Node not ready: %s", n)
+		}
+		return &TimeoutError{What: "nodes", Reason: "not ready: " + strings.Join(notReady, ", ")}
+	}
+	return err
+}
+
+// Retry calls fn every interval until it succeeds or ctx is done, and
+// returns the last error on timeout
+func Retry(ctx context.Context, interval time.Duration, fn func() error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// PodReady reports whether a pod's Ready condition is true
+func PodReady(pod *corev1.Pod) bool {
+	return podCondition(pod, corev1.PodReady) == corev1.ConditionTrue
+}
+
+// DaemonSetReady reports whether a DaemonSet has rolled out its latest
+// spec with a ready pod on every scheduled node
+func DaemonSetReady(ds *appsv1.DaemonSet) bool {
+	status := ds.Status
+	return status.ObservedGeneration >= ds.Generation &&
+		status.DesiredNumberScheduled > 0 &&
+		status.UpdatedNumberScheduled == status.DesiredNumberScheduled &&
+		status.NumberReady == status.DesiredNumberScheduled
+}
+
+// DeploymentAvailable reports whether the controller has seen a
+// Deployment's latest spec and reports it Available, meaning at least the
+// minimum replicas its rollout strategy allows are serving
+func DeploymentAvailable(deployment *appsv1.Deployment) bool {
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// NodeReady reports whether a node's Ready condition is true
+func NodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// until runs the watch until condition holds or ctx is done. A watch the
+// API server closes early is started again from a fresh list, after
+// calling start (if set) to drop the state the condition built up.
+func until(ctx context.Context, lw cache.ListerWatcher, objType runtime.Object, start func(), condition watchtools.ConditionFunc) error {
+	for {
+		if start != nil {
+			start()
+		}
+
+		_, err := watchtools.UntilWithSync(ctx, lw, objType, nil, condition)
+		if !errors.Is(err, watchtools.ErrWatchClosed) || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// isTimeout reports whether a watch ended because its deadline passed
+func isTimeout(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() != nil
+}
+
+// timeout logs the pods matching selector that are not ready and returns
+// a TimeoutError describing them
+func timeout(ctx context.Context, client *kube.Client, what, reason, namespace, selector string, log *logger.Logger) error {
+	err := &TimeoutError{What: what, Reason: reason}
+	if selector == "" {
+		return err
+	}
+
+	// The wait's context has expired, so list with a fresh one
+	listCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	pods, listErr := client.Clientset.CoreV1().Pods(namespace).List(listCtx, metav1.ListOptions{LabelSelector: selector})
+	if listErr != nil {
+		log.Warn("Failed to list pods for %s: %v", what, listErr)
+		return err
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if PodReady(pod) || pod.Status.Phase == corev1.PodSucceeded {
+			continue
+		}
+		err.Stuck = append(err.Stuck, PodStatus{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Node:      pod.Spec.NodeName,
+			Reason:    PodProblem(pod),
+		})
+	}
+
+	log.Warn("%s is not ready: %s", what, reason)
+	for _, p := range err.Stuck {
+		node := p.Node
+		if node == "" {
+			node = "<unscheduled>"
+		}
+		log.Warn("  %s/%s on %s: %s", p.Namespace, p.Name, node, p.Reason)
+	}
+
+	return err
+}
+
+// PodProblem explains why a pod is not ready, preferring the most
+// specific signal: scheduling, then container states, then conditions
+func PodProblem(pod *corev1.Pod) string {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+			return fmt.Sprintf("%s: %s", condition.Reason, condition.Message)
+		}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.Ready {
+			continue
+		}
+
+		var problem string
+		switch {
+		case status.State.Waiting != nil && status.State.Waiting.Reason != "":
+			problem = status.State.Waiting.Reason
+			if status.State.Waiting.Message != "" {
+				problem += ": " + status.State.Waiting.Message
+			}
+		case status.State.Terminated != nil:
+			problem = fmt.Sprintf("%s (exit code %d)", status.State.Terminated.Reason, status.State.Terminated.ExitCode)
+		case status.State.Running != nil:
+			problem = "running but failing its readiness probe"
+		default:
+			continue
+		}
+
+		if status.RestartCount > 0 {
+			problem += fmt.Sprintf(", %d restarts", status.RestartCount)
+		}
+		return fmt.Sprintf("container %s: %s", status.Name, problem)
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Message != "" {
+			return condition.Message
+		}
+	}
+
+	return string(pod.Status.Phase)
+}
+
+// podListWatch lists and watches the pods matching selector
+func podListWatch(client *kube.Client, namespace, selector string) cache.ListerWatcher {
+	pods := client.Clientset.CoreV1().Pods(namespace)
+	return &cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector
+			return pods.List(ctx, options)
+		},
+		WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector
+			return pods.Watch(ctx, options)
+		},
+	}
+}
+
+// namedListWatch restricts a list and watch to the object called name
+func namedListWatch(name string,
+	list func(context.Context, metav1.ListOptions) (runtime.Object, error),
+	watchFunc func(context.Context, metav1.ListOptions) (watch.Interface, error)) cache.ListerWatcher {
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	return &cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return list(ctx, options)
+		},
+		WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return watchFunc(ctx, options)
+		},
+	}
+}
+
+// podCondition returns the status of a pod condition, or Unknown
+func podCondition(pod *corev1.Pod, conditionType corev1.PodConditionType) corev1.ConditionStatus {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status
+		}
+	}
+	return corev1.ConditionUnknown
+}
+
+// nodeReadyMessage returns the message of a node's Ready condition
+func nodeReadyMessage(node *corev1.Node) string {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Message
+		}
+	}
+	return "no Ready condition"
+}
+
+// replicas returns a Deployment's desired replica count, defaulting to 1
+func replicas(deployment *appsv1.Deployment) int32 {
+	if deployment.Spec.Replicas == nil {
+		return 1
+	}
+	return *deployment.Spec.Replicas
+}
@@ -0,0 +1,269 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+)
+
+func TestDaemonSetReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		generation int64
+		status     appsv1.DaemonSetStatus
+		want       bool
+	}{
+		{
+			name:       "rolled out",
+			generation: 2,
+			status:     appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 3},
+			want:       true,
+		},
+		{
+			name:       "latest spec not observed",
+			generation: 3,
+			status:     appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 3},
+		},
+		{
+			name:       "no pods scheduled",
+			generation: 1,
+			status:     appsv1.DaemonSetStatus{ObservedGeneration: 1},
+		},
+		{
+			name:       "old pods still running",
+			generation: 2,
+			status:     appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 2, NumberReady: 3},
+		},
+		{
+			name:       "pod not ready",
+			generation: 2,
+			status:     appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Generation: tt.generation},
+				Status:     tt.status,
+			}
+			if got := DaemonSetReady(ds); got != tt.want {
+				t.Errorf("DaemonSetReady() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeploymentAvailable(t *testing.T) {
+	available := func(status corev1.ConditionStatus) []appsv1.DeploymentCondition {
+		return []appsv1.DeploymentCondition{
+			{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue},
+			{Type: appsv1.DeploymentAvailable, Status: status},
+		}
+	}
+
+	tests := []struct {
+		name               string
+		generation         int64
+		observedGeneration int64
+		conditions         []appsv1.DeploymentCondition
+		want               bool
+	}{
+		{
+			name:               "available",
+			generation:         2,
+			observedGeneration: 2,
+			conditions:         available(corev1.ConditionTrue),
+			want:               true,
+		},
+		{
+			name:               "available for an older spec",
+			generation:         3,
+			observedGeneration: 2,
+			conditions:         available(corev1.ConditionTrue),
+		},
+		{
+			name:               "unavailable",
+			generation:         2,
+			observedGeneration: 2,
+			conditions:         available(corev1.ConditionFalse),
+		},
+		{
+			name:               "no Available condition",
+			generation:         1,
+			observedGeneration: 1,
+			conditions:         []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: tt.generation},
+				Status: appsv1.DeploymentStatus{
+					ObservedGeneration: tt.observedGeneration,
+					Conditions:         tt.conditions,
+				},
+			}
+			if got := DeploymentAvailable(deployment); got != tt.want {
+				t.Errorf("DeploymentAvailable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodProblem(t *testing.T) {
+	tests := []struct {
+		name   string
+		status corev1.PodStatus
+		want   string
+	}{
+		{
+			name: "unschedulable",
+			status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				Conditions: []corev1.PodCondition{{
+					Type:    corev1.PodScheduled,
+					Status:  corev1.ConditionFalse,
+					Reason:  "Unschedulable",
+					Message: "0/3 nodes are available: 3 node(s) had untolerated taint",
+				}},
+			},
+			want: "Unschedulable: 0/3 nodes are available: 3 node(s) had untolerated taint",
+		},
+		{
+			name: "crash looping",
+			status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "sidecar", Ready: true, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+					{
+						Name:         "calico-node",
+						RestartCount: 5,
+						State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+							Reason:  "CrashLoopBackOff",
+							Message: "back-off 2m40s restarting failed container",
+						}},
+					},
+				},
+			},
+			want: "container calico-node: CrashLoopBackOff: back-off 2m40s restarting failed container, 5 restarts",
+		},
+		{
+			name: "init container terminated",
+			status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				InitContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "install-cni",
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}},
+				}},
+			},
+			want: "container install-cni: Error (exit code 1)",
+		},
+		{
+			name: "failing readiness probe",
+			status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "coredns",
+					State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+				}},
+			},
+			want: "container coredns: running but failing its readiness probe",
+		},
+		{
+			name: "ready condition message",
+			status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				Conditions: []corev1.PodCondition{{
+					Type:    corev1.PodReady,
+					Status:  corev1.ConditionFalse,
+					Message: "containers with unready status: [app]",
+				}},
+			},
+			want: "containers with unready status: [app]",
+		},
+		{
+			name:   "phase only",
+			status: corev1.PodStatus{Phase: corev1.PodPending},
+			want:   "Pending",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PodProblem(&corev1.Pod{Status: tt.status}); got != tt.want {
+				t.Errorf("PodProblem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDaemonSetTimeout(t *testing.T) {
+	labels := map[string]string{"k8s-app": "calico-node"}
+	pod := func(name, node string, status corev1.PodStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: name, Labels: labels},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     status,
+		}
+	}
+
+	clientset := fake.NewSimpleClientset(
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "calico-node", Generation: 1},
+			Spec:       appsv1.DaemonSetSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+			Status: appsv1.DaemonSetStatus{
+				ObservedGeneration:     1,
+				DesiredNumberScheduled: 2,
+				UpdatedNumberScheduled: 2,
+				NumberReady:            1,
+			},
+		},
+		pod("calico-node-ready", "node-1", corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		}),
+		pod("calico-node-crashing", "node-2", corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:         "calico-node",
+				RestartCount: 3,
+				State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+			}},
+		}),
+	)
+	client := kube.NewForClients(clientset, nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err := DaemonSet(ctx, client, "kube-system", "calico-node", logger.New())
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("DaemonSet() error = %v, want a *TimeoutError", err)
+	}
+	if timeoutErr.What != "daemonset kube-system/calico-node" || timeoutErr.Reason != "1 of 2 pods ready" {
+		t.Errorf("TimeoutError = %q, %q", timeoutErr.What, timeoutErr.Reason)
+	}
+
+	want := []PodStatus{{
+		Namespace: "kube-system",
+		Name:      "calico-node-crashing",
+		Node:      "node-2",
+		Reason:    "container calico-node: CrashLoopBackOff, 3 restarts",
+	}}
+	if len(timeoutErr.Stuck) != len(want) || timeoutErr.Stuck[0] != want[0] {
+		t.Errorf("Stuck = %+v, want %+v", timeoutErr.Stuck, want)
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/ochestra-tech/kubeforge/pkg/kubernetes"
+	"github.com/ochestra-tech/kubeforge/pkg/network"
+	"github.com/ochestra-tech/kubeforge/pkg/proxy"
+	"github.com/ochestra-tech/kubeforge/pkg/system"
+)
+
+// DefaultPath is where KubeForge looks for a configuration file when none is given
+const DefaultPath = "/etc/kubeforge/config.yaml"
+
+// Config is the KubeForge configuration file. Values act as defaults for the
+// interactive prompts.
+type Config struct {
+	Kubernetes *kubernetes.Config `json:"kubernetes"`
+	Network    *network.Config    `json:"network"`
+	System     SystemConfig       `json:"system"`
+	Proxy      proxy.Config       `json:"proxy"`
+}
+
+// SystemConfig holds host-level settings
+type SystemConfig struct {
+	// KernelModules are loaded in addition to the ones KubeForge requires
+	KernelModules []string `json:"kernelModules"`
+	// Sysctls are applied in addition to, or instead of, the KubeForge defaults
+	Sysctls map[string]string `json:"sysctls"`
+	// SELinux is enforcing or permissive; empty asks on hosts with SELinux enabled
+	SELinux system.SELinuxMode `json:"selinux"`
+}
+
+// Default returns the configuration used when no file is present
+func Default() *Config {
+	return &Config{
+		Kubernetes: kubernetes.DefaultConfig(),
+		Network:    network.DefaultConfig(),
+		System: SystemConfig{
+			Sysctls: make(map[string]string),
+		},
+	}
+}
+
+// Load reads the configuration file at path on top of the defaults.
+// An empty path loads DefaultPath if it exists and the defaults otherwise.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		if _, err := os.Stat(DefaultPath); err != nil {
+			return cfg, nil
+		}
+		path = DefaultPath
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the cluster networks, the kube-proxy mode and the
+// options of the configured plugin. The pod CIDR is taken from the
+// kubernetes section, which is what the network plugin is installed with.
+func (c *Config) Validate() error {
+	networkConfig := *c.Network
+	networkConfig.PodCIDR = c.Kubernetes.PodCIDR
+
+	if err := system.ValidateSELinuxMode(c.System.SELinux); err != nil {
+		return err
+	}
+
+	if err := kubernetes.ValidateProxyMode(c.Kubernetes); err != nil {
+		return err
+	}
+
+	if err := kubernetes.ValidateSwapBehavior(c.Kubernetes); err != nil {
+		return err
+	}
+
+	// Only Cilium's kube-proxy replacement can stand in for kube-proxy
+	replaced := networkConfig.Plugin == network.Cilium && networkConfig.KubeProxyReplacement
+	switch proxyMode := c.Kubernetes.ProxyMode; {
+	case proxyMode == kubernetes.ProxyModeNone && !replaced:
+		return fmt.Errorf("kube-proxy mode %s needs the Cilium kube-proxy replacement", kubernetes.ProxyModeNone)
+	case proxyMode != "" && proxyMode != kubernetes.ProxyModeNone && replaced:
+		return fmt.Errorf("the Cilium kube-proxy replacement needs kube-proxy mode %s, not %s", kubernetes.ProxyModeNone, proxyMode)
+	}
+
+	switch networkConfig.Plugin {
+	case network.Calico:
+		if err := network.ValidateCalico(&networkConfig); err != nil {
+			return err
+		}
+	case network.Cilium:
+		if err := network.ValidateCilium(&networkConfig); err != nil {
+			return err
+		}
+	}
+
+	return network.ValidateClusterCIDRs(&networkConfig, c.Kubernetes.ServiceCIDR)
+}
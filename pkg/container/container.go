@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"strings"
 
+	"github.com/ochestra-tech/kubeforge/internal/download"
 	"github.com/ochestra-tech/kubeforge/internal/logger"
 	"github.com/ochestra-tech/kubeforge/pkg/distro"
 )
@@ -14,60 +14,79 @@ import (
 func InstallContainerd(dist *distro.Distribution, log *logger.Logger) error {
 	log.Info("Installing containerd...")
 
-	// Download and add Docker's official GPG key
-	gpgCmd := exec.Command("sh", "-c",
-		fmt.Sprintf("curl -fsSL https://download.docker.com/linux/%s/gpg | gpg --dearmor -o /usr/share/keyrings/docker-archive-keyring.gpg",
-			strings.ToLower(dist.Name)))
-	err := gpgCmd.Run()
-	if err != nil {
-		return err
-	}
-
-	// Add Docker apt repository
+	var err error
 	switch dist.Type {
 	case distro.Debian:
-		// Get codename for Debian/Ubuntu
-		lsbCmd := exec.Command("lsb_release", "-cs")
-		codename, err := lsbCmd.Output()
+		// Download and add Docker's official GPG key
+		err = download.New(log).InstallKey(
+			fmt.Sprintf("https://download.docker.com/linux/%s/gpg", dist.Base),
+			download.DockerKeyFingerprint,
+			"/usr/share/keyrings/docker-archive-keyring.gpg")
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to install Docker repository key: %v", err)
+		}
+
+		// Add Docker apt repository
+		// Docker publishes per-codename suites for Debian/Ubuntu
+		if dist.Codename == "" {
+			return fmt.Errorf("no release codename found in os-release for %s", dist.Name)
 		}
 
-		repoCmd := exec.Command("sh", "-c",
-			fmt.Sprintf(`echo "deb [arch=amd64 signed-by=/usr/share/keyrings/docker-archive-keyring.gpg] https://download.docker.com/linux/%s %s stable" | tee /etc/apt/sources.list.d/docker.list > /dev/null`,
-				dist.Name, strings.TrimSpace(string(codename))))
-		err = repoCmd.Run()
+		release := fmt.Sprintf("https://download.docker.com/linux/%s/dists/%s/Release", dist.Base, dist.Codename)
+		packages := fmt.Sprintf("https://download.docker.com/linux/%s/dists/%s/stable/binary-%s/Packages",
+			dist.Base, dist.Codename, dist.DebArch())
+		err = download.New(log).VerifyRepository(release, release+".gpg", packages,
+			"/usr/share/keyrings/docker-archive-keyring.gpg")
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to verify Docker repository: %v", err)
 		}
 
-		// Update package lists
-		updateCmd := exec.Command("apt-get", "update")
-		err = updateCmd.Run()
+		repo := fmt.Sprintf("deb [arch=%s signed-by=/usr/share/keyrings/docker-archive-keyring.gpg] https://download.docker.com/linux/%s %s stable\n",
+			dist.DebArch(), dist.Base, dist.Codename)
+		err = os.WriteFile("/etc/apt/sources.list.d/docker.list", []byte(repo), 0644)
 		if err != nil {
 			return err
 		}
 
-		// Install containerd
-		installCmd := exec.Command("apt-get", "install", "-y", "containerd.io")
-		err = installCmd.Run()
-		if err != nil {
+		// Update package lists and install containerd
+		pm := dist.PackageManager()
+		if err := pm.Refresh(); err != nil {
+			return err
+		}
+
+		if err := pm.Install("containerd.io"); err != nil {
 			return err
 		}
 
 	case distro.RedHat:
+		pm := dist.PackageManager()
+
+		// Docker has no repository for Amazon Linux, which packages containerd itself
+		if dist.Base == "amzn" {
+			if err := pm.Install("containerd"); err != nil {
+				return err
+			}
+			break
+		}
+
 		// Add repo for CentOS/RHEL/Fedora
-		repoCmd := exec.Command("yum-config-manager", "--add-repo",
-			fmt.Sprintf("https://download.docker.com/linux/%s/docker-ce.repo", dist.Name))
-		err = repoCmd.Run()
-		if err != nil {
+		if err := pm.AddRepoFile(fmt.Sprintf("https://download.docker.com/linux/%s/docker-ce.repo", dist.Base)); err != nil {
 			return err
 		}
 
 		// Install containerd
-		installCmd := exec.Command("yum", "install", "-y", "containerd.io")
-		err = installCmd.Run()
-		if err != nil {
+		if err := pm.Install("containerd.io"); err != nil {
+			return err
+		}
+
+	case distro.Suse, distro.ArchLinux:
+		// containerd is packaged in the distribution's own repositories
+		pm := dist.PackageManager()
+		if err := pm.Refresh(); err != nil {
+			return err
+		}
+
+		if err := pm.Install("containerd", "runc"); err != nil {
 			return err
 		}
 
@@ -0,0 +1,54 @@
+package distro
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// machineArchs maps `uname -m` output to Go/Debian architecture names
+var machineArchs = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// detectArch returns the kernel machine name and the normalized architecture.
+// The kernel is authoritative; runtime.GOARCH is used when uname is unavailable
+// or reports a machine we don't know about.
+func detectArch() (machine string, arch string) {
+	output, err := exec.Command("uname", "-m").Output()
+	if err == nil {
+		machine = strings.TrimSpace(string(output))
+		if a, ok := machineArchs[machine]; ok {
+			return machine, a
+		}
+	}
+
+	if machine == "" {
+		machine = runtime.GOARCH
+	}
+
+	return machine, runtime.GOARCH
+}
+
+// HostArch returns the normalized architecture of this host, as set in
+// Distribution.Arch
+func HostArch() string {
+	_, arch := detectArch()
+	return arch
+}
+
+// DebArch returns the architecture name used in apt sources (amd64, arm64, ...)
+func (d *Distribution) DebArch() string {
+	if d.Arch == "arm" {
+		return "armhf"
+	}
+	return d.Arch
+}
@@ -1,9 +1,10 @@
 package distro
 
 import (
-	"log"
+	"bytes"
+	"fmt"
 	"os"
-	"regexp"
+	"strings"
 )
 
 // Distribution types
@@ -11,56 +12,130 @@ const (
 	Unknown = iota
 	Debian
 	RedHat
+	Suse
+	ArchLinux
 )
 
 // Distribution represents details about the Linux distribution
 type Distribution struct {
 	Type       int
-	Name       string
-	Version    string
+	Name       string   // os-release ID, e.g. "rocky"
+	Version    string   // os-release VERSION_ID
+	Codename   string   // Release codename of the upstream distribution, e.g. "jammy"
+	PrettyName string   // Human-readable name, e.g. "Rocky Linux 9.3 (Blue Onyx)"
+	IDLike     []string // os-release ID_LIKE
+	Base       string   // Upstream distribution whose repositories we use, e.g. "ubuntu" or "centos"
 	PackageCmd string
+	Arch       string // Normalized architecture (amd64, arm64, ...)
+	Machine    string // Kernel machine name as reported by uname -m
+}
+
+// familyBases maps well-known IDs to their family and the upstream
+// distribution whose package repositories apply to them
+var familyBases = map[string]struct {
+	Type int
+	Base string
+}{
+	"debian":              {Debian, "debian"},
+	"raspbian":            {Debian, "debian"},
+	"ubuntu":              {Debian, "ubuntu"},
+	"linuxmint":           {Debian, "ubuntu"},
+	"pop":                 {Debian, "ubuntu"},
+	"elementary":          {Debian, "ubuntu"},
+	"rhel":                {RedHat, "rhel"},
+	"centos":              {RedHat, "centos"},
+	"rocky":               {RedHat, "centos"},
+	"almalinux":           {RedHat, "centos"},
+	"ol":                  {RedHat, "centos"},
+	"amzn":                {RedHat, "amzn"},
+	"fedora":              {RedHat, "fedora"},
+	"opensuse-leap":       {Suse, "opensuse"},
+	"opensuse-tumbleweed": {Suse, "opensuse"},
+	"opensuse":            {Suse, "opensuse"},
+	"sles":                {Suse, "sles"},
+	"sled":                {Suse, "sles"},
+	"suse":                {Suse, "opensuse"},
+	"arch":                {ArchLinux, "arch"},
+	"manjaro":             {ArchLinux, "arch"},
+	"endeavouros":         {ArchLinux, "arch"},
 }
 
 // Detect identifies the Linux distribution from system files
 func Detect() (*Distribution, error) {
-	dist := &Distribution{}
+	var data []byte
+	var err error
+
+	for _, path := range osReleasePaths {
+		data, err = os.ReadFile(path)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read os-release: %v", err)
+	}
 
-	// Check if /etc/os-release exists
-	data, err := os.ReadFile("/etc/os-release")
+	dist, err := FromOSRelease(data)
 	if err != nil {
 		return nil, err
 	}
 
-	// Parse the os-release file
-	osRelease := string(data)
-	nameRe := regexp.MustCompile(`ID="?([^"\n]+)"?`)
-	versionRe := regexp.MustCompile(`VERSION_ID="?([^"\n]+)"?`)
-
-	log.Printf("Detected OS release file: %s", nameRe)
-
-	if nameMatch := nameRe.FindStringSubmatch(osRelease); len(nameMatch) > 1 {
-		dist.Name = nameMatch[1]
-		log.Printf("Detected OS release file: %s", string(osRelease))
-		// Set distribution type and package command
-		switch dist.Name {
-		case "ubuntu", "debian":
-			dist.Type = Debian
-			dist.PackageCmd = "apt-get"
-		case "centos", "rhel", "fedora":
-			dist.Type = RedHat
-			dist.PackageCmd = "yum"
-		default:
-			dist.Type = Unknown
+	dist.Machine, dist.Arch = detectArch()
+	dist.PackageCmd = detectPackageCmd(dist)
+	return dist, nil
+}
+
+// FromOSRelease builds a Distribution from the contents of an os-release file
+func FromOSRelease(data []byte) (*Distribution, error) {
+	fields, err := ParseOSRelease(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse os-release: %v", err)
+	}
+
+	dist := &Distribution{
+		Name:       strings.ToLower(fields["ID"]),
+		Version:    fields["VERSION_ID"],
+		PrettyName: fields["PRETTY_NAME"],
+		IDLike:     strings.Fields(strings.ToLower(fields["ID_LIKE"])),
+	}
+
+	// Try the ID itself first, then each ID_LIKE entry in order of preference
+	for _, id := range append([]string{dist.Name}, dist.IDLike...) {
+		if family, ok := familyBases[id]; ok {
+			dist.Type = family.Type
+			dist.Base = family.Base
+			break
 		}
 	}
 
-	if versionMatch := versionRe.FindStringSubmatch(osRelease); len(versionMatch) > 1 {
-		dist.Version = versionMatch[1]
+	// Derivatives such as Mint and Pop!_OS carry their own codename in
+	// VERSION_CODENAME; the Ubuntu one is what upstream repositories expect
+	dist.Codename = fields["UBUNTU_CODENAME"]
+	if dist.Codename == "" {
+		dist.Codename = fields["VERSION_CODENAME"]
+	}
+
+	switch dist.Type {
+	case Debian:
+		dist.PackageCmd = "apt-get"
+	case RedHat:
+		dist.PackageCmd = "yum"
+	case Suse:
+		dist.PackageCmd = "zypper"
+	case ArchLinux:
+		dist.PackageCmd = "pacman"
 	}
 
 	return dist, nil
 }
 
+// MajorVersion returns the leading numeric component of VERSION_ID, or 0
+func (d *Distribution) MajorVersion() int {
+	major := 0
+	fmt.Sscanf(d.Version, "%d", &major)
+	return major
+}
+
 // IsDebian returns true if the distribution is Debian-based
 func (d *Distribution) IsDebian() bool {
 	return d.Type == Debian
@@ -70,3 +145,13 @@ func (d *Distribution) IsDebian() bool {
 func (d *Distribution) IsRedHat() bool {
 	return d.Type == RedHat
 }
+
+// IsSuse returns true if the distribution is SUSE-based
+func (d *Distribution) IsSuse() bool {
+	return d.Type == Suse
+}
+
+// IsArchLinux returns true if the distribution is Arch Linux based
+func (d *Distribution) IsArchLinux() bool {
+	return d.Type == ArchLinux
+}
@@ -0,0 +1,67 @@
+package distro
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// osReleasePaths lists the os-release locations in lookup order (see os-release(5))
+var osReleasePaths = []string{"/etc/os-release", "/usr/lib/os-release"}
+
+// ParseOSRelease parses an os-release file into its KEY=value pairs.
+// Values may be unquoted, single-quoted or double-quoted; backslash escapes
+// inside double quotes are honored and comments and blank lines are skipped.
+func ParseOSRelease(r io.Reader) (map[string]string, error) {
+	fields := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		fields[key] = unquote(strings.TrimSpace(value))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// unquote strips shell-style quoting from an os-release value
+func unquote(value string) string {
+	if len(value) < 2 {
+		return value
+	}
+
+	switch {
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1]
+	case value[0] == '"' && value[len(value)-1] == '"':
+		var b strings.Builder
+		inner := value[1 : len(value)-1]
+		for i := 0; i < len(inner); i++ {
+			if inner[i] == '\\' && i+1 < len(inner) {
+				i++
+			}
+			b.WriteByte(inner[i])
+		}
+		return b.String()
+	default:
+		return value
+	}
+}
@@ -0,0 +1,118 @@
+package distro
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFromOSRelease(t *testing.T) {
+	tests := []struct {
+		file       string
+		typ        int
+		name       string
+		version    string
+		codename   string
+		base       string
+		packageCmd string
+	}{
+		{"ubuntu-22.04", Debian, "ubuntu", "22.04", "jammy", "ubuntu", "apt-get"},
+		{"debian-12", Debian, "debian", "12", "bookworm", "debian", "apt-get"},
+		{"linuxmint-21.3", Debian, "linuxmint", "21.3", "jammy", "ubuntu", "apt-get"},
+		{"pop-22.04", Debian, "pop", "22.04", "jammy", "ubuntu", "apt-get"},
+		{"neon-22.04", Debian, "neon", "22.04", "jammy", "ubuntu", "apt-get"}, // ID_LIKE fallback
+		{"rhel-9.3", RedHat, "rhel", "9.3", "", "rhel", "yum"},
+		{"rocky-9.3", RedHat, "rocky", "9.3", "", "centos", "yum"},
+		{"almalinux-9.3", RedHat, "almalinux", "9.3", "", "centos", "yum"},
+		{"eurolinux-9.3", RedHat, "eurolinux", "9.3", "", "rhel", "yum"}, // ID_LIKE fallback
+		{"fedora-39", RedHat, "fedora", "39", "", "fedora", "yum"},
+		{"amzn-2023", RedHat, "amzn", "2023", "", "amzn", "yum"},
+		{"sles-15.5", Suse, "sles", "15.5", "", "sles", "zypper"},
+		{"opensuse-leap-15.5", Suse, "opensuse-leap", "15.5", "", "opensuse", "zypper"},
+		{"arch", ArchLinux, "arch", "", "", "arch", "pacman"},
+		{"garuda", ArchLinux, "garuda", "", "", "arch", "pacman"}, // ID_LIKE fallback
+		{"unknown", Unknown, "plan9", "4", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "os-release", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dist, err := FromOSRelease(data)
+			if err != nil {
+				t.Fatalf("FromOSRelease: %v", err)
+			}
+
+			if dist.Type != tt.typ {
+				t.Errorf("Type = %d, want %d", dist.Type, tt.typ)
+			}
+			if dist.Name != tt.name {
+				t.Errorf("Name = %q, want %q", dist.Name, tt.name)
+			}
+			if dist.Version != tt.version {
+				t.Errorf("Version = %q, want %q", dist.Version, tt.version)
+			}
+			if dist.Codename != tt.codename {
+				t.Errorf("Codename = %q, want %q", dist.Codename, tt.codename)
+			}
+			if dist.Base != tt.base {
+				t.Errorf("Base = %q, want %q", dist.Base, tt.base)
+			}
+			if dist.PackageCmd != tt.packageCmd {
+				t.Errorf("PackageCmd = %q, want %q", dist.PackageCmd, tt.packageCmd)
+			}
+			if dist.PrettyName == "" {
+				t.Errorf("PrettyName is empty")
+			}
+		})
+	}
+}
+
+func TestParseOSRelease(t *testing.T) {
+	input := `# comment
+ID=ubuntu
+VERSION_ID="22.04"
+VARIANT_ID=server
+NAME='Single Quoted'
+ESCAPED="a \"quoted\" \\ value"
+EMPTY=
+
+not a pair
+`
+	fields, err := ParseOSRelease(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"ID":         "ubuntu",
+		"VERSION_ID": "22.04",
+		"VARIANT_ID": "server",
+		"NAME":       "Single Quoted",
+		"ESCAPED":    `a "quoted" \ value`,
+		"EMPTY":      "",
+	}
+	for key, value := range want {
+		if got, ok := fields[key]; !ok || got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if len(fields) != len(want) {
+		t.Errorf("got %d fields, want %d", len(fields), len(want))
+	}
+}
+
+func TestFromOSReleaseIDLike(t *testing.T) {
+	dist, err := FromOSRelease([]byte("ID=rocky\nID_LIKE=\"rhel centos fedora\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dist.IDLike, []string{"rhel", "centos", "fedora"}) {
+		t.Errorf("IDLike = %v", dist.IDLike)
+	}
+}
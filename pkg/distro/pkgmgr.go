@@ -0,0 +1,267 @@
+package distro
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// pacmanConf is the pacman configuration holding IgnorePkg
+const pacmanConf = "/etc/pacman.conf"
+
+// PackageManager wraps the native package manager of a distribution
+type PackageManager struct {
+	Cmd string // apt-get, dnf, yum, zypper or pacman
+}
+
+// PackageManager returns the package manager for the distribution
+func (d *Distribution) PackageManager() *PackageManager {
+	return &PackageManager{Cmd: d.PackageCmd}
+}
+
+// detectPackageCmd picks dnf over yum on RedHat hosts where it is available.
+// Minimal RHEL 9 and Fedora images ship dnf only.
+func detectPackageCmd(dist *Distribution) string {
+	if dist.Type == RedHat {
+		if _, err := exec.LookPath("dnf"); err == nil {
+			return "dnf"
+		}
+	}
+	return dist.PackageCmd
+}
+
+// IsRPM returns true for dnf and yum
+func (p *PackageManager) IsRPM() bool {
+	return p.Cmd == "dnf" || p.Cmd == "yum"
+}
+
+// Refresh updates the package metadata
+func (p *PackageManager) Refresh() error {
+	switch p.Cmd {
+	case "apt-get":
+		return p.run("update")
+	case "dnf", "yum":
+		return p.run("makecache")
+	case "zypper":
+		return p.run("--non-interactive", "--gpg-auto-import-keys", "refresh")
+	case "pacman":
+		// Arch does not support partial upgrades: refreshing the package
+		// databases without upgrading would break the next install
+		return p.run("-Syu", "--noconfirm")
+	default:
+		return p.unsupported()
+	}
+}
+
+// Upgrade upgrades all installed packages
+func (p *PackageManager) Upgrade() error {
+	switch p.Cmd {
+	case "apt-get":
+		if err := p.Refresh(); err != nil {
+			return err
+		}
+		return p.run("upgrade", "-y")
+	case "dnf", "yum":
+		return p.run("update", "-y")
+	case "zypper":
+		return p.run("--non-interactive", "update")
+	case "pacman":
+		return p.run("-Syu", "--noconfirm")
+	default:
+		return p.unsupported()
+	}
+}
+
+// Install installs the given packages
+func (p *PackageManager) Install(packages ...string) error {
+	switch p.Cmd {
+	case "apt-get", "dnf", "yum":
+		return p.run(append([]string{"install", "-y"}, packages...)...)
+	case "zypper":
+		return p.run(append([]string{"--non-interactive", "install"}, packages...)...)
+	case "pacman":
+		return p.run(append([]string{"-S", "--noconfirm", "--needed"}, packages...)...)
+	default:
+		return p.unsupported()
+	}
+}
+
+// Available reports whether a package is installed or can be installed from
+// the configured repositories
+func (p *PackageManager) Available(name string) bool {
+	switch p.Cmd {
+	case "apt-get":
+		return exec.Command("apt-cache", "show", name).Run() == nil
+	case "dnf", "yum":
+		return p.run("-q", "info", name) == nil
+	case "zypper":
+		// search exits with 104 when nothing matches
+		return p.run("--non-interactive", "search", "--match-exact", name) == nil
+	case "pacman":
+		return p.run("-Si", name) == nil || p.run("-Qi", name) == nil
+	default:
+		return false
+	}
+}
+
+// InstallFromRepo installs packages that the named repository excludes by
+// default. Only rpm repositories use excludes; elsewhere this is a plain install.
+func (p *PackageManager) InstallFromRepo(repo string, packages ...string) error {
+	if !p.IsRPM() {
+		return p.Install(packages...)
+	}
+	args := append([]string{"install", "-y", "--disableexcludes=" + repo}, packages...)
+	return p.run(args...)
+}
+
+// AddRepoFile adds an rpm repository from a published .repo file
+func (p *PackageManager) AddRepoFile(url string) error {
+	switch p.Cmd {
+	case "dnf":
+		// config-manager is a plugin on dnf4 and a built-in with a new syntax on dnf5
+		if err := p.run("install", "-y", "dnf-plugins-core"); err != nil {
+			return fmt.Errorf("failed to install dnf-plugins-core: %v", err)
+		}
+		if err := p.run("config-manager", "--add-repo", url); err == nil {
+			return nil
+		}
+		return p.run("config-manager", "addrepo", "--from-repofile="+url)
+	case "yum":
+		return exec.Command("yum-config-manager", "--add-repo", url).Run()
+	case "zypper":
+		return p.run("--non-interactive", "addrepo", "--refresh", url)
+	default:
+		return fmt.Errorf("%s does not support .repo files", p.Cmd)
+	}
+}
+
+// AddRepo adds a zypper repository by base URL, trusting the given signing key
+func (p *PackageManager) AddRepo(alias, name, baseURL, keyURL string) error {
+	if p.Cmd != "zypper" {
+		return fmt.Errorf("%s does not support adding repositories by URL", p.Cmd)
+	}
+
+	if keyURL != "" {
+		if err := exec.Command("rpm", "--import", keyURL).Run(); err != nil {
+			return fmt.Errorf("failed to import key for repository %s: %v", alias, err)
+		}
+	}
+
+	// Replace an existing definition so reruns pick up a changed URL
+	p.run("--non-interactive", "removerepo", alias)
+
+	return p.run("--non-interactive", "addrepo", "--refresh", "--name", name, baseURL, alias)
+}
+
+// Hold prevents the packages from being upgraded implicitly.
+// rpm repositories achieve the same with an exclude= line in the .repo file.
+func (p *PackageManager) Hold(packages ...string) error {
+	switch p.Cmd {
+	case "apt-get":
+		return exec.Command("apt-mark", append([]string{"hold"}, packages...)...).Run()
+	case "dnf", "yum":
+		return nil
+	case "zypper":
+		return p.run(append([]string{"--non-interactive", "addlock"}, packages...)...)
+	case "pacman":
+		return updateIgnorePkg(pacmanConf, packages, true)
+	default:
+		return p.unsupported()
+	}
+}
+
+// Unhold reverses Hold
+func (p *PackageManager) Unhold(packages ...string) error {
+	switch p.Cmd {
+	case "apt-get":
+		return exec.Command("apt-mark", append([]string{"unhold"}, packages...)...).Run()
+	case "dnf", "yum":
+		return nil
+	case "zypper":
+		return p.run(append([]string{"--non-interactive", "removelock"}, packages...)...)
+	case "pacman":
+		return updateIgnorePkg(pacmanConf, packages, false)
+	default:
+		return p.unsupported()
+	}
+}
+
+// VersionedPackage returns the package spec that pins name to version.
+// pacman can only install the version currently in the repositories, so
+// pinning is an error there.
+func (p *PackageManager) VersionedPackage(name, version string) (string, error) {
+	version = strings.TrimPrefix(version, "v")
+	switch p.Cmd {
+	case "dnf", "yum":
+		return fmt.Sprintf("%s-%s-*", name, version), nil
+	case "zypper":
+		return fmt.Sprintf("%s=%s", name, version), nil
+	case "pacman":
+		return "", fmt.Errorf("pacman cannot install %s %s, only the version in the repositories", name, version)
+	default:
+		return fmt.Sprintf("%s=%s-*", name, version), nil
+	}
+}
+
+// run executes the package manager with the given arguments
+func (p *PackageManager) run(args ...string) error {
+	return exec.Command(p.Cmd, args...).Run()
+}
+
+// unsupported returns the error for hosts without a known package manager
+func (p *PackageManager) unsupported() error {
+	return fmt.Errorf("no supported package manager found")
+}
+
+// ignorePkgRe matches the (possibly commented) IgnorePkg line of pacman.conf
+var ignorePkgRe = regexp.MustCompile(`(?m)^#?\s*IgnorePkg\s*=(.*)$`)
+
+// updateIgnorePkg adds packages to, or removes them from, the IgnorePkg list in pacman.conf
+func updateIgnorePkg(path string, packages []string, hold bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	conf := string(data)
+
+	var current []string
+	match := ignorePkgRe.FindStringSubmatch(conf)
+	if match != nil && !strings.HasPrefix(strings.TrimSpace(match[0]), "#") {
+		current = strings.Fields(match[1])
+	}
+
+	set := make(map[string]bool)
+	for _, pkg := range current {
+		set[pkg] = true
+	}
+	for _, pkg := range packages {
+		set[pkg] = hold
+	}
+
+	var ignored []string
+	for _, pkg := range current {
+		if set[pkg] {
+			ignored = append(ignored, pkg)
+			delete(set, pkg)
+		}
+	}
+	for _, pkg := range packages {
+		if set[pkg] {
+			ignored = append(ignored, pkg)
+			delete(set, pkg)
+		}
+	}
+
+	line := "IgnorePkg = " + strings.Join(ignored, " ")
+	if match != nil {
+		conf = strings.Replace(conf, match[0], line, 1)
+	} else if strings.Contains(conf, "[options]\n") {
+		conf = strings.Replace(conf, "[options]\n", "[options]\n"+line+"\n", 1)
+	} else {
+		return fmt.Errorf("no [options] section found in %s", path)
+	}
+
+	return os.WriteFile(path, []byte(conf), 0644)
+}
@@ -0,0 +1,325 @@
+package firewall
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ruleComment tags the nftables and iptables rules KubeForge owns
+const ruleComment = "kubeforge"
+
+// ufwBeforeRules holds the raw iptables rules ufw loads before its own.
+// ufw allow only accepts a few protocols, so whole protocols go here.
+const ufwBeforeRules = "/etc/ufw/before.rules"
+
+// open adds an allow rule to the backend
+func open(backend Backend, rule Rule) error {
+	switch backend {
+	case Firewalld:
+		if rule.Port == 0 {
+			return exec.Command("firewall-cmd", "--permanent", "--add-protocol="+rule.Protocol).Run()
+		}
+		return exec.Command("firewall-cmd", "--permanent", "--add-port="+rule.String()).Run()
+	case UFW:
+		if rule.Port == 0 {
+			return updateUFWBeforeRules(ufwBeforeRules, rule, true)
+		}
+		return exec.Command("ufw", "allow", ufwSpec(rule)).Run()
+	case NFTables:
+		if nftHandle(rule) != "" {
+			return nil
+		}
+		// Insert rather than append, so the rule comes before any drop or reject
+		args := append([]string{"insert", "rule", "inet", "filter", "input"}, nftMatch(rule)...)
+		args = append(args, "accept", "comment", fmt.Sprintf("%q", ruleComment))
+		return exec.Command("nft", args...).Run()
+	case IPTables:
+		for _, command := range iptablesCommands(rule) {
+			if exec.Command(command, iptablesArgs("-C", rule)...).Run() == nil {
+				continue
+			}
+			if err := exec.Command(command, iptablesArgs("-I", rule)...).Run(); err != nil {
+				return fmt.Errorf("%s: %v", command, err)
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// closePort removes the allow rule from the backend
+func closePort(backend Backend, rule Rule) error {
+	switch backend {
+	case Firewalld:
+		if rule.Port == 0 {
+			return exec.Command("firewall-cmd", "--permanent", "--remove-protocol="+rule.Protocol).Run()
+		}
+		return exec.Command("firewall-cmd", "--permanent", "--remove-port="+rule.String()).Run()
+	case UFW:
+		if rule.Port == 0 {
+			return updateUFWBeforeRules(ufwBeforeRules, rule, false)
+		}
+		return exec.Command("ufw", "delete", "allow", ufwSpec(rule)).Run()
+	case NFTables:
+		handle := nftHandle(rule)
+		if handle == "" {
+			return nil
+		}
+		return exec.Command("nft", "delete", "rule", "inet", "filter", "input", "handle", handle).Run()
+	case IPTables:
+		for _, command := range iptablesCommands(rule) {
+			if exec.Command(command, iptablesArgs("-C", rule)...).Run() != nil {
+				continue
+			}
+			if err := exec.Command(command, iptablesArgs("-D", rule)...).Run(); err != nil {
+				return fmt.Errorf("%s: %v", command, err)
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// reload activates permanent firewalld changes and persists the iptables
+// rules, which are otherwise lost on reboot
+func reload(backend Backend) error {
+	switch backend {
+	case Firewalld:
+		return exec.Command("firewall-cmd", "--reload").Run()
+	case IPTables:
+		return persistIPTables()
+	default:
+		return nil
+	}
+}
+
+// iptablesCommands returns the iptables commands a rule is applied with:
+// iptables, and ip6tables where the host has it so IPv6 and dual-stack
+// nodes are reachable too. IP-in-IP only carries IPv4.
+func iptablesCommands(rule Rule) []string {
+	commands := []string{"iptables"}
+	if rule.Protocol == ipipProtocol {
+		return commands
+	}
+	if exec.Command("ip6tables", "-S", "INPUT").Run() == nil {
+		commands = append(commands, "ip6tables")
+	}
+	return commands
+}
+
+// iptablesRestoreScript and iptablesRestoreUnit reapply the KubeForge
+// iptables rules at boot. Only KubeForge's own rules are saved, since a
+// full iptables-save would also restore stale kube-proxy and CNI chains.
+const (
+	iptablesRestoreScript = "/etc/kubeforge/firewall.sh"
+	iptablesRestoreUnit   = "/etc/systemd/system/kubeforge-firewall.service"
+)
+
+// iptablesRestoreUnitContent runs the restore script after the host's own
+// firewall has loaded its rules
+const iptablesRestoreUnitContent = `[Unit]
+Description=KubeForge firewall rules
+After=iptables.service ip6tables.service netfilter-persistent.service
+Before=kubelet.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh ` + iptablesRestoreScript + `
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// persistIPTables writes the KubeForge rules currently in the INPUT chains
+// to the restore script and enables the unit that runs it at boot
+func persistIPTables() error {
+	var rules []string
+	for _, command := range []string{"iptables", "ip6tables"} {
+		output, err := exec.Command(command, "-S", "INPUT").Output()
+		if err != nil {
+			continue
+		}
+		rules = append(rules, restoreCommands(command, string(output))...)
+	}
+
+	script := "#!/bin/sh\n# Firewall rules opened by KubeForge, generated on every change\n" +
+		strings.Join(rules, "\n") + "\n"
+	if err := os.MkdirAll(filepath.Dir(iptablesRestoreScript), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(iptablesRestoreScript, []byte(script), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(iptablesRestoreUnit, []byte(iptablesRestoreUnitContent), 0644); err != nil {
+		return err
+	}
+
+	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
+		return err
+	}
+	return exec.Command("systemctl", "enable", filepath.Base(iptablesRestoreUnit)).Run()
+}
+
+// restoreCommands turns the KubeForge rules in iptables -S output into
+// commands that insert each rule unless it is already there
+func restoreCommands(command, listing string) []string {
+	var commands []string
+	for _, line := range strings.Split(listing, "\n") {
+		spec, ok := strings.CutPrefix(line, "-A ")
+		if !ok || !strings.Contains(line, "--comment "+ruleComment) {
+			continue
+		}
+		commands = append(commands, fmt.Sprintf("%s -C %s 2>/dev/null || %s -I %s", command, spec, command, spec))
+	}
+	return commands
+}
+
+// ufwSpec formats the rule for ufw, which separates ranges with a colon
+func ufwSpec(rule Rule) string {
+	if rule.EndPort > rule.Port {
+		return fmt.Sprintf("%d:%d/%s", rule.Port, rule.EndPort, rule.Protocol)
+	}
+	return fmt.Sprintf("%d/%s", rule.Port, rule.Protocol)
+}
+
+// nftMatch returns the nft match expression for the rule
+func nftMatch(rule Rule) []string {
+	if rule.Port == 0 {
+		return []string{"meta", "l4proto", rule.Protocol}
+	}
+	port := fmt.Sprintf("%d", rule.Port)
+	if rule.EndPort > rule.Port {
+		port = fmt.Sprintf("%d-%d", rule.Port, rule.EndPort)
+	}
+	return []string{rule.Protocol, "dport", port}
+}
+
+// nftHandle returns the handle of the KubeForge rule matching rule, or ""
+func nftHandle(rule Rule) string {
+	output, err := exec.Command("nft", "-a", "list", "chain", "inet", "filter", "input").Output()
+	if err != nil {
+		return ""
+	}
+
+	// nft lists protocols by name where it knows one
+	match := strings.Join(nftMatch(rule), " ") + " accept"
+	if rule.Port == 0 {
+		match = "meta l4proto "
+	}
+	handleRe := regexp.MustCompile(`# handle (\d+)`)
+	for _, line := range strings.Split(string(output), "\n") {
+		if !strings.Contains(line, match) || !strings.Contains(line, `comment "`+ruleComment+`"`) {
+			continue
+		}
+		if rule.Port == 0 && !nftProtocolMatches(line, rule.Protocol) {
+			continue
+		}
+		if m := handleRe.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+	}
+
+	return ""
+}
+
+// iptablesArgs returns the iptables arguments for the given operation (-C, -I or -D)
+func iptablesArgs(op string, rule Rule) []string {
+	if rule.Port == 0 {
+		return []string{op, "INPUT", "-p", rule.Protocol,
+			"-m", "comment", "--comment", ruleComment, "-j", "ACCEPT"}
+	}
+	port := fmt.Sprintf("%d", rule.Port)
+	if rule.EndPort > rule.Port {
+		port = fmt.Sprintf("%d:%d", rule.Port, rule.EndPort)
+	}
+	return []string{op, "INPUT", "-p", rule.Protocol, "--dport", port,
+		"-m", "comment", "--comment", ruleComment, "-j", "ACCEPT"}
+}
+
+// nftProtocolMatches reports whether a listed meta l4proto rule matches the
+// protocol number, which nft may print as its /etc/protocols name
+func nftProtocolMatches(line, protocol string) bool {
+	fields := strings.Fields(line)
+	for i := 0; i+2 < len(fields); i++ {
+		if fields[i] != "meta" || fields[i+1] != "l4proto" {
+			continue
+		}
+		return slices.Contains(nftProtocolNames(protocol), fields[i+2])
+	}
+	return false
+}
+
+// nftProtocolNames returns the ways nft may list an IP protocol number
+func nftProtocolNames(protocol string) []string {
+	if protocol == ipipProtocol {
+		return []string{protocol, "ipencap", "ipv4"}
+	}
+	return []string{protocol}
+}
+
+// ufwBeforeRule returns the before.rules line accepting a whole IP protocol
+func ufwBeforeRule(rule Rule) string {
+	return fmt.Sprintf("-A ufw-before-input -p %s -j ACCEPT -m comment --comment %s", rule.Protocol, ruleComment)
+}
+
+// updateUFWBeforeRules adds or removes the accept rule for a whole IP
+// protocol in ufw's before.rules and reloads ufw
+func updateUFWBeforeRules(path string, rule Rule, add bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	line := ufwBeforeRule(rule)
+	lines := strings.Split(string(data), "\n")
+
+	var updated []string
+	found := false
+	for _, l := range lines {
+		if l == line {
+			found = true
+			if !add {
+				continue
+			}
+		}
+		updated = append(updated, l)
+	}
+	if found == add {
+		return nil
+	}
+
+	if add {
+		// Rules must come before the COMMIT of the filter table
+		commit := -1
+		for i, l := range updated {
+			if strings.TrimSpace(l) == "COMMIT" {
+				commit = i
+				break
+			}
+		}
+		if commit < 0 {
+			return fmt.Errorf("no COMMIT line found in %s", path)
+		}
+		updated = append(updated[:commit], append([]string{"# " + rule.Purpose, line}, updated[commit:]...)...)
+	} else {
+		for i, l := range updated {
+			if l == "# "+rule.Purpose {
+				updated = append(updated[:i], updated[i+1:]...)
+				break
+			}
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Join(updated, "\n")), 0640); err != nil {
+		return err
+	}
+	return exec.Command("ufw", "reload").Run()
+}
@@ -0,0 +1,243 @@
+package firewall
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/pkg/network"
+)
+
+// Backend identifies the firewall implementation active on the host
+type Backend string
+
+// Supported firewall backends
+const (
+	None      Backend = "none"
+	Firewalld Backend = "firewalld"
+	UFW       Backend = "ufw"
+	NFTables  Backend = "nftables"
+	IPTables  Backend = "iptables"
+)
+
+// Rule describes a port or port range to open. A rule without a port
+// opens a whole IP protocol, given by number in Protocol.
+type Rule struct {
+	Port     int
+	EndPort  int // Last port of a range, 0 for a single port
+	Protocol string
+	Purpose  string
+}
+
+// String returns the rule in port[-end]/protocol form, or protocol/number
+// for a whole IP protocol
+func (r Rule) String() string {
+	if r.Port == 0 {
+		return "protocol/" + r.Protocol
+	}
+	if r.EndPort > r.Port {
+		return fmt.Sprintf("%d-%d/%s", r.Port, r.EndPort, r.Protocol)
+	}
+	return fmt.Sprintf("%d/%s", r.Port, r.Protocol)
+}
+
+// ipipProtocol is the IP protocol number of IP-in-IP encapsulation
+const ipipProtocol = "4"
+
+// controlPlanePorts are the ports kubeadm documents for control plane nodes
+var controlPlanePorts = []Rule{
+	{Port: 6443, Protocol: "tcp", Purpose: "Kubernetes API server"},
+	{Port: 2379, EndPort: 2380, Protocol: "tcp", Purpose: "etcd server client API"},
+	{Port: 10250, Protocol: "tcp", Purpose: "Kubelet API"},
+	{Port: 10257, Protocol: "tcp", Purpose: "kube-controller-manager"},
+	{Port: 10259, Protocol: "tcp", Purpose: "kube-scheduler"},
+}
+
+// workerPorts are the ports kubeadm documents for worker nodes
+var workerPorts = []Rule{
+	{Port: 10250, Protocol: "tcp", Purpose: "Kubelet API"},
+	{Port: 30000, EndPort: 32767, Protocol: "tcp", Purpose: "NodePort Services"},
+	{Port: 30000, EndPort: 32767, Protocol: "udp", Purpose: "NodePort Services"},
+}
+
+// Detect returns the firewall backend active on the host
+func Detect() Backend {
+	if exec.Command("systemctl", "is-active", "--quiet", "firewalld").Run() == nil {
+		return Firewalld
+	}
+
+	if output, err := exec.Command("ufw", "status").Output(); err == nil &&
+		strings.Contains(string(output), "Status: active") {
+		return UFW
+	}
+
+	// nftables and iptables are there on nearly every host; they only act
+	// as a firewall when the input chain drops or rejects something
+	if output, err := exec.Command("nft", "list", "chain", "inet", "filter", "input").Output(); err == nil &&
+		nftFiltering(string(output)) {
+		return NFTables
+	}
+
+	if output, err := exec.Command("iptables", "-S", "INPUT").Output(); err == nil &&
+		iptablesFiltering(string(output)) {
+		return IPTables
+	}
+
+	return None
+}
+
+// nftFiltering reports whether an nft chain listing has a drop policy or
+// drops or rejects packets
+func nftFiltering(listing string) bool {
+	for _, line := range strings.Split(listing, "\n") {
+		for _, field := range strings.Fields(line) {
+			switch strings.TrimSuffix(field, ";") {
+			case "drop", "reject":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// iptablesFiltering reports whether iptables -S output for a chain has a
+// non-ACCEPT policy or drops or rejects packets
+func iptablesFiltering(listing string) bool {
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "-P" && fields[2] != "ACCEPT" {
+			return true
+		}
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "-j" && (fields[i+1] == "DROP" || fields[i+1] == "REJECT") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RolePorts returns the ports required by the node role. The slice is a
+// copy the caller may append to.
+func RolePorts(isControlPlane bool) []Rule {
+	if isControlPlane {
+		return slices.Clone(controlPlanePorts)
+	}
+	return slices.Clone(workerPorts)
+}
+
+// PluginPorts returns the ports the network plugin needs between nodes
+func PluginPorts(config *network.Config) []Rule {
+	var rules []Rule
+
+	switch config.Plugin {
+	case network.Calico:
+		rules = append(rules, Rule{Port: 5473, Protocol: "tcp", Purpose: "Calico Typha"})
+		if config.EnableBGP {
+			rules = append(rules, Rule{Port: 179, Protocol: "tcp", Purpose: "Calico BGP"})
+		}
+		if config.UsesIPIP() {
+			rules = append(rules, Rule{Protocol: ipipProtocol, Purpose: "Calico IP-in-IP"})
+		}
+		if config.UsesVXLAN() {
+			rules = append(rules, Rule{Port: 4789, Protocol: "udp", Purpose: "Calico VXLAN"})
+		}
+		if config.EnableEncryption {
+			rules = append(rules, Rule{Port: 51820, EndPort: 51821, Protocol: "udp", Purpose: "Calico WireGuard"})
+		}
+	case network.Flannel, network.Canal:
+		rules = append(rules, Rule{Port: 8472, Protocol: "udp", Purpose: "Flannel VXLAN"})
+		if config.EnableEncryption {
+			rules = append(rules, Rule{Port: 51820, EndPort: 51821, Protocol: "udp", Purpose: "Flannel WireGuard"})
+		}
+	case network.Weave:
+		rules = append(rules,
+			Rule{Port: 6783, Protocol: "tcp", Purpose: "Weave control"},
+			Rule{Port: 6783, EndPort: 6784, Protocol: "udp", Purpose: "Weave data"})
+	case network.Cilium:
+		rules = append(rules, Rule{Port: 4240, Protocol: "tcp", Purpose: "Cilium health checks"})
+		if port := network.CiliumTunnelPort(config); port > 0 {
+			rules = append(rules, Rule{Port: port, Protocol: "udp", Purpose: "Cilium tunnel"})
+		}
+		if config.EnableHubble {
+			rules = append(rules, Rule{Port: 4244, Protocol: "tcp", Purpose: "Hubble server"})
+		}
+		if config.EnableEncryption {
+			rules = append(rules, Rule{Port: 51871, Protocol: "udp", Purpose: "Cilium WireGuard"})
+		}
+	}
+
+	return rules
+}
+
+// Configure opens the ports for the node role and, when config is not nil,
+// the network plugin. It is safe to call again once the plugin is known.
+func Configure(isControlPlane bool, config *network.Config, log *logger.Logger) error {
+	backend := Detect()
+	if backend == None {
+		log.Info("No active firewall detected, skipping port configuration")
+		return nil
+	}
+
+	rules := RolePorts(isControlPlane)
+	if config != nil {
+		rules = append(rules, PluginPorts(config)...)
+	}
+
+	log.Info("Opening %d firewall rules with %s...", len(rules), backend)
+	for _, rule := range rules {
+		if err := open(backend, rule); err != nil {
+			return fmt.Errorf("failed to open %s (%s): %v", rule, rule.Purpose, err)
+		}
+	}
+
+	return reload(backend)
+}
+
+// Teardown closes the ports a removed network plugin may have opened on
+// this node, with any of its options, except those the node role or the
+// replacement plugin still need
+func Teardown(removed network.Plugin, replacement *network.Config, log *logger.Logger) error {
+	backend := Detect()
+	if backend == None {
+		return nil
+	}
+
+	keep := append(RolePorts(true), RolePorts(false)...)
+	keep = append(keep, PluginPorts(replacement)...)
+
+	var rules []Rule
+	for _, encapsulation := range []string{network.EncapsulationIPIP, network.EncapsulationVXLAN} {
+		old := &network.Config{
+			Plugin:           removed,
+			Encapsulation:    encapsulation,
+			EnableBGP:        true,
+			EnableEncryption: true,
+			EnableHubble:     true,
+		}
+		for _, rule := range PluginPorts(old) {
+			if !slices.ContainsFunc(keep, rule.sameAs) && !slices.ContainsFunc(rules, rule.sameAs) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	log.Info("Removing %d %s firewall rules from %s...", len(rules), removed, backend)
+	for _, rule := range rules {
+		if err := closePort(backend, rule); err != nil {
+			log.Warn("Failed to remove firewall rule %s: %v", rule, err)
+		}
+	}
+
+	return reload(backend)
+}
+
+// sameAs reports whether two rules open the same ports
+func (r Rule) sameAs(other Rule) bool {
+	return r.String() == other.String()
+}
@@ -0,0 +1,64 @@
+package firewall
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIPTablesFiltering(t *testing.T) {
+	tests := []struct {
+		name    string
+		listing string
+		want    bool
+	}{
+		{"accept policy", "-P INPUT ACCEPT\n", false},
+		{"kube-proxy jumps only", "-P INPUT ACCEPT\n-A INPUT -j KUBE-FIREWALL\n-A INPUT -m conntrack --ctstate NEW -j KUBE-SERVICES\n", false},
+		{"drop policy", "-P INPUT DROP\n", true},
+		{"reject rule", "-P INPUT ACCEPT\n-A INPUT -j REJECT --reject-with icmp-host-prohibited\n", true},
+		{"drop rule", "-P INPUT ACCEPT\n-A INPUT -p tcp --dport 22 -j ACCEPT\n-A INPUT -j DROP\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := iptablesFiltering(tt.listing); got != tt.want {
+				t.Errorf("iptablesFiltering() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNFTFiltering(t *testing.T) {
+	tests := []struct {
+		name    string
+		listing string
+		want    bool
+	}{
+		{"accept policy", "table inet filter {\n\tchain input {\n\t\ttype filter hook input priority filter; policy accept;\n\t}\n}\n", false},
+		{"drop policy", "table inet filter {\n\tchain input {\n\t\ttype filter hook input priority filter; policy drop;\n\t}\n}\n", true},
+		{"reject rule", "table inet filter {\n\tchain input {\n\t\ttype filter hook input priority filter; policy accept;\n\t\tct state invalid reject\n\t}\n}\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nftFiltering(tt.listing); got != tt.want {
+				t.Errorf("nftFiltering() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreCommands(t *testing.T) {
+	listing := `-P INPUT DROP
+-A INPUT -p tcp -m tcp --dport 22 -j ACCEPT
+-A INPUT -p tcp -m tcp --dport 6443 -m comment --comment kubeforge -j ACCEPT
+-A INPUT -p udp -m udp --dport 8472 -m comment --comment kubeforge -j ACCEPT
+`
+	want := []string{
+		"ip6tables -C INPUT -p tcp -m tcp --dport 6443 -m comment --comment kubeforge -j ACCEPT 2>/dev/null || ip6tables -I INPUT -p tcp -m tcp --dport 6443 -m comment --comment kubeforge -j ACCEPT",
+		"ip6tables -C INPUT -p udp -m udp --dport 8472 -m comment --comment kubeforge -j ACCEPT 2>/dev/null || ip6tables -I INPUT -p udp -m udp --dport 8472 -m comment --comment kubeforge -j ACCEPT",
+	}
+
+	if got := restoreCommands("ip6tables", listing); !slices.Equal(got, want) {
+		t.Errorf("restoreCommands() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OpenScript returns a shell script that opens rules on a node the way
+// Configure does, with whichever backend is active there. It is meant for
+// other nodes, reached through a host pod, where KubeForge isn't running.
+// Detection follows Detect: nftables and iptables only count when their
+// input chain drops or rejects packets.
+func OpenScript(rules []Rule) string {
+	var b strings.Builder
+	b.WriteString("set -e\n")
+
+	b.WriteString("if systemctl is-active --quiet firewalld 2>/dev/null; then\n")
+	for _, rule := range rules {
+		if rule.Port == 0 {
+			fmt.Fprintf(&b, "  firewall-cmd --permanent --add-protocol=%s\n", rule.Protocol)
+		} else {
+			fmt.Fprintf(&b, "  firewall-cmd --permanent --add-port=%s\n", rule)
+		}
+	}
+	b.WriteString("  firewall-cmd --reload\n")
+
+	b.WriteString("elif ufw status 2>/dev/null | grep -q 'Status: active'; then\n")
+	for _, rule := range rules {
+		if rule.Port != 0 {
+			fmt.Fprintf(&b, "  ufw allow %s\n", ufwSpec(rule))
+			continue
+		}
+		// Before the first COMMIT, which ends the filter table
+		line := ufwBeforeRule(rule)
+		fmt.Fprintf(&b, "  if ! grep -qxF -- %s %s; then\n", shellQuote(line), ufwBeforeRules)
+		fmt.Fprintf(&b, "    sed -i %s %s\n", shellQuote(`0,/^COMMIT$/s//# `+rule.Purpose+`\n`+line+`\nCOMMIT/`), ufwBeforeRules)
+		b.WriteString("    ufw reload\n")
+		b.WriteString("  fi\n")
+	}
+
+	b.WriteString("elif nft list chain inet filter input 2>/dev/null | grep -qwE 'drop|reject'; then\n")
+	for _, rule := range rules {
+		match := strings.Join(nftMatch(rule), " ")
+		listed := shellQuote(match + ` accept comment "` + ruleComment + `"`)
+		grep := "grep -qF"
+		if rule.Port == 0 {
+			// nft lists protocols by name where it knows one
+			listed = shellQuote(`meta l4proto (` + strings.Join(nftProtocolNames(rule.Protocol), "|") + `) accept comment "` + ruleComment + `"`)
+			grep = "grep -qE"
+		}
+		fmt.Fprintf(&b, "  nft list chain inet filter input | %s -- %s ||\n", grep, listed)
+		fmt.Fprintf(&b, "    nft insert rule inet filter input %s accept comment %s\n", match, shellQuote(`"`+ruleComment+`"`))
+	}
+
+	b.WriteString("elif iptables -S INPUT 2>/dev/null | grep -qE '^-P INPUT DROP|-j (DROP|REJECT)'; then\n")
+	for _, rule := range rules {
+		spec := strings.Join(iptablesArgs("", rule)[1:], " ")
+		fmt.Fprintf(&b, "  iptables -C %s 2>/dev/null || iptables -I %s\n", spec, spec)
+		if rule.Protocol != ipipProtocol {
+			fmt.Fprintf(&b, "  if ip6tables -S INPUT >/dev/null 2>&1; then\n")
+			fmt.Fprintf(&b, "    ip6tables -C %s 2>/dev/null || ip6tables -I %s\n", spec, spec)
+			fmt.Fprintf(&b, "  fi\n")
+		}
+	}
+	// Refresh the boot-time restore script on nodes KubeForge set up
+	fmt.Fprintf(&b, "  if [ -f %s ]; then\n", iptablesRestoreScript)
+	fmt.Fprintf(&b, "    {\n")
+	fmt.Fprintf(&b, "      printf '#!/bin/sh\\n# Firewall rules opened by KubeForge, generated on every change\\n'\n")
+	fmt.Fprintf(&b, "      for t in iptables ip6tables; do\n")
+	fmt.Fprintf(&b, "        $t -S INPUT 2>/dev/null | grep -- '--comment %s' | sed \"s|^-A \\(.*\\)|$t -C \\1 2>/dev/null \\|\\| $t -I \\1|\"\n", ruleComment)
+	fmt.Fprintf(&b, "      done\n")
+	fmt.Fprintf(&b, "    } > %s.new\n", iptablesRestoreScript)
+	fmt.Fprintf(&b, "    mv %s.new %s\n", iptablesRestoreScript, iptablesRestoreScript)
+	fmt.Fprintf(&b, "  fi\n")
+
+	b.WriteString("fi\n")
+	return b.String()
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
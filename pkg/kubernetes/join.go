@@ -0,0 +1,228 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+	"github.com/ochestra-tech/kubeforge/pkg/network"
+	"github.com/ochestra-tech/kubeforge/pkg/proxy"
+)
+
+// joinReadTimeout bounds reading the cluster settings before a join
+const joinReadTimeout = 30 * time.Second
+
+// JoinSettings are the cluster-wide settings a joining node has to match
+type JoinSettings struct {
+	PodCIDR       string
+	ServiceCIDR   string
+	ProxyMode     string // kube-proxy mode, ProxyModeNone without kube-proxy
+	IPVSScheduler string
+	Network       *network.ClusterSettings // The network plugin and the options that decide its ports
+}
+
+// ReadJoinSettings reads the cluster's settings with the bootstrap token
+// of a join command, so nodes joined without the control plane's config
+// file still match the cluster
+func ReadJoinSettings(joinCommand string) (*JoinSettings, error) {
+	join, err := ParseJoinCommand(joinCommand)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), joinReadTimeout)
+	defer cancel()
+
+	client, err := join.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &JoinSettings{}
+	settings.PodCIDR, settings.ServiceCIDR, err = network.ClusterNetworks(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	settings.ProxyMode, settings.IPVSScheduler, err = ClusterProxyMode(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	settings.Network, err = network.ReadClusterSettings(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// JoinCommand holds the connection details of a kubeadm join command
+type JoinCommand struct {
+	Endpoint     string   // API server host:port
+	Token        string   // Bootstrap token
+	CACertHashes []string // sha256:<hex> pins of the cluster CA public key
+	SkipCAVerify bool     // --discovery-token-unsafe-skip-ca-verification
+}
+
+// ParseJoinCommand extracts the endpoint, token and CA pins from a kubeadm
+// join command
+func ParseJoinCommand(joinCommand string) (*JoinCommand, error) {
+	join := &JoinCommand{}
+	fields := strings.Fields(joinCommand)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+
+		// Flags come as --flag value or --flag=value
+		name, value, hasValue := strings.Cut(field, "=")
+		next := func() string {
+			if hasValue {
+				return value
+			}
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+
+		switch {
+		case name == "--token" || name == "--discovery-token":
+			join.Token = next()
+		case name == "--discovery-token-ca-cert-hash":
+			join.CACertHashes = append(join.CACertHashes, next())
+		case name == "--discovery-token-unsafe-skip-ca-verification":
+			join.SkipCAVerify = !hasValue || value == "true"
+		case field == "join" && join.Endpoint == "" && i+1 < len(fields) && !strings.HasPrefix(fields[i+1], "-"):
+			join.Endpoint = fields[i+1]
+			i++
+		}
+	}
+
+	if join.Endpoint == "" {
+		return nil, fmt.Errorf("no API server endpoint in the join command")
+	}
+	if _, _, err := net.SplitHostPort(join.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid API server endpoint %q in the join command: %v", join.Endpoint, err)
+	}
+	if join.Token == "" {
+		return nil, fmt.Errorf("no bootstrap token in the join command")
+	}
+	if len(join.CACertHashes) == 0 && !join.SkipCAVerify {
+		return nil, fmt.Errorf("no --discovery-token-ca-cert-hash in the join command")
+	}
+
+	return join, nil
+}
+
+// Client returns a client authenticated with the bootstrap token. The
+// cluster CA comes from the public cluster-info ConfigMap and is checked
+// against the pinned hashes, as kubeadm join does. kubeadm lets bootstrap
+// tokens read the kubeadm-config and kube-proxy ConfigMaps, and KubeForge
+// lets them read its network settings.
+func (j *JoinCommand) Client(ctx context.Context) (*kube.Client, error) {
+	host := "https://" + j.Endpoint
+
+	discovery, err := kube.NewForConfig(&rest.Config{
+		Host:            host,
+		TLSClientConfig: rest.TLSClientConfig{Insecure: true},
+		Proxy:           proxy.FromEnvironment,
+	})
+	if err != nil {
+		return nil, err
+	}
+	clusterInfo, err := discovery.Clientset.CoreV1().ConfigMaps(metav1.NamespacePublic).Get(ctx, "cluster-info", metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster-info from %s: %v", j.Endpoint, err)
+	}
+
+	kubeconfig, err := clientcmd.Load([]byte(clusterInfo.Data["kubeconfig"]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cluster-info: %v", err)
+	}
+	var caData []byte
+	for _, cluster := range kubeconfig.Clusters {
+		caData = cluster.CertificateAuthorityData
+		break
+	}
+	if len(caData) == 0 {
+		return nil, fmt.Errorf("cluster-info has no certificate authority")
+	}
+
+	if !j.SkipCAVerify {
+		if err := verifyCAHash(caData, j.CACertHashes); err != nil {
+			return nil, err
+		}
+	}
+
+	return kube.NewForConfig(&rest.Config{
+		Host:            host,
+		BearerToken:     j.Token,
+		TLSClientConfig: rest.TLSClientConfig{CAData: caData},
+		Proxy:           proxy.FromEnvironment,
+	})
+}
+
+// verifyCAHash checks that a CA certificate's public key matches one of
+// the sha256:<hex> pins
+func verifyCAHash(caData []byte, hashes []string) error {
+	for remaining := caData; ; {
+		var block *pem.Block
+		block, remaining = pem.Decode(remaining)
+		if block == nil {
+			break
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse the cluster CA: %v", err)
+		}
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, hash := range hashes {
+			if strings.EqualFold(strings.TrimPrefix(hash, "sha256:"), hex.EncodeToString(sum[:])) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("the cluster CA does not match --discovery-token-ca-cert-hash")
+}
+
+// ClusterProxyMode returns the mode and IPVS scheduler kube-proxy runs with
+// in the cluster, from its ConfigMap. A cluster without kube-proxy reports
+// ProxyModeNone.
+func ClusterProxyMode(ctx context.Context, client *kube.Client) (mode, scheduler string, err error) {
+	cm, err := client.Clientset.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(ctx, "kube-proxy", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ProxyModeNone, "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read the kube-proxy ConfigMap: %v", err)
+	}
+
+	var proxyConfig kubeProxyConfiguration
+	if err := yaml.Unmarshal([]byte(cm.Data["config.conf"]), &proxyConfig); err != nil {
+		return "", "", fmt.Errorf("failed to parse the kube-proxy configuration: %v", err)
+	}
+
+	// An empty mode is kube-proxy's default on Linux
+	mode = proxyConfig.Mode
+	if mode == "" {
+		mode = ProxyModeIPTables
+	}
+	if proxyConfig.IPVS != nil {
+		scheduler = proxyConfig.IPVS.Scheduler
+	}
+	return mode, scheduler, nil
+}
@@ -0,0 +1,146 @@
+package kubernetes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJoinCommand(t *testing.T) {
+	const hash = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name    string
+		command string
+		want    *JoinCommand
+		wantErr string // Substring of the error, empty for none
+	}{
+		{
+			"flag value",
+			"kubeadm join 10.0.0.10:6443 --token abcdef.0123456789abcdef --discovery-token-ca-cert-hash " + hash,
+			&JoinCommand{Endpoint: "10.0.0.10:6443", Token: "abcdef.0123456789abcdef", CACertHashes: []string{hash}}, "",
+		},
+		{
+			"flag=value",
+			"kubeadm join cp.example.com:6443 --token=abcdef.0123456789abcdef --discovery-token-ca-cert-hash=" + hash,
+			&JoinCommand{Endpoint: "cp.example.com:6443", Token: "abcdef.0123456789abcdef", CACertHashes: []string{hash}}, "",
+		},
+		{
+			"line continuations and several pins",
+			"sudo kubeadm join [fd00::10]:6443 \\\n    --discovery-token abcdef.0123456789abcdef \\\n    --discovery-token-ca-cert-hash " + hash + " \\\n    --discovery-token-ca-cert-hash=sha256:ff",
+			&JoinCommand{Endpoint: "[fd00::10]:6443", Token: "abcdef.0123456789abcdef", CACertHashes: []string{hash, "sha256:ff"}}, "",
+		},
+		{
+			"control plane join",
+			"kubeadm join 10.0.0.10:6443 --token abcdef.0123456789abcdef --discovery-token-ca-cert-hash " + hash + " --control-plane --certificate-key 0123",
+			&JoinCommand{Endpoint: "10.0.0.10:6443", Token: "abcdef.0123456789abcdef", CACertHashes: []string{hash}}, "",
+		},
+		{
+			"unsafe skip ca verification",
+			"kubeadm join 10.0.0.10:6443 --token abcdef.0123456789abcdef --discovery-token-unsafe-skip-ca-verification",
+			&JoinCommand{Endpoint: "10.0.0.10:6443", Token: "abcdef.0123456789abcdef", SkipCAVerify: true}, "",
+		},
+		{
+			"unsafe skip ca verification=true",
+			"kubeadm join 10.0.0.10:6443 --token abcdef.0123456789abcdef --discovery-token-unsafe-skip-ca-verification=true",
+			&JoinCommand{Endpoint: "10.0.0.10:6443", Token: "abcdef.0123456789abcdef", SkipCAVerify: true}, "",
+		},
+		{
+			"unsafe skip ca verification=false needs a hash",
+			"kubeadm join 10.0.0.10:6443 --token abcdef.0123456789abcdef --discovery-token-unsafe-skip-ca-verification=false",
+			nil, "no --discovery-token-ca-cert-hash",
+		},
+		{"missing token", "kubeadm join 10.0.0.10:6443 --discovery-token-ca-cert-hash " + hash, nil, "no bootstrap token"},
+		{"token without value", "kubeadm join 10.0.0.10:6443 --discovery-token-ca-cert-hash " + hash + " --token", nil, "no bootstrap token"},
+		{"missing hash", "kubeadm join 10.0.0.10:6443 --token abcdef.0123456789abcdef", nil, "no --discovery-token-ca-cert-hash"},
+		{"missing endpoint", "kubeadm join --token abcdef.0123456789abcdef --discovery-token-ca-cert-hash " + hash, nil, "no API server endpoint"},
+		{"endpoint without port", "kubeadm join 10.0.0.10 --token abcdef.0123456789abcdef --discovery-token-ca-cert-hash " + hash, nil, "invalid API server endpoint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseJoinCommand(tt.command)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("ParseJoinCommand() = %v, want no error", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("ParseJoinCommand() = %+v, want an error containing %q", got, tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("ParseJoinCommand() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseJoinCommand() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testCA returns a self-signed CA certificate in PEM form and the
+// sha256:<hex> pin kubeadm prints for it
+func testCA(t *testing.T) ([]byte, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestVerifyCAHash(t *testing.T) {
+	ca, pin := testCA(t)
+	other, otherPin := testCA(t)
+
+	tests := []struct {
+		name    string
+		caData  []byte
+		hashes  []string
+		wantErr bool
+	}{
+		{"match", ca, []string{pin}, false},
+		{"match in upper case", ca, []string{"sha256:" + strings.ToUpper(strings.TrimPrefix(pin, "sha256:"))}, false},
+		{"one of several pins", ca, []string{otherPin, pin}, false},
+		{"rotated CA bundle", append(append([]byte{}, other...), ca...), []string{pin}, false},
+		{"mismatch", ca, []string{otherPin}, true},
+		{"no pins", ca, nil, true},
+		{"no certificate", []byte("not a certificate"), []string{pin}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyCAHash(tt.caData, tt.hashes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyCAHash() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
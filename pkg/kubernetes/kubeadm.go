@@ -0,0 +1,180 @@
+package kubernetes
+
+import (
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// kubeadm and component config API versions
+const (
+	kubeadmAPIVersion   = "kubeadm.k8s.io/v1beta3"
+	kubeletAPIVersion   = "kubelet.config.k8s.io/v1beta1"
+	kubeProxyAPIVersion = "kubeproxy.config.k8s.io/v1alpha1"
+)
+
+// initConfiguration mirrors the parts of kubeadm's InitConfiguration we set
+type initConfiguration struct {
+	APIVersion       string           `json:"apiVersion"`
+	Kind             string           `json:"kind"`
+	NodeRegistration nodeRegistration `json:"nodeRegistration"`
+	LocalAPIEndpoint apiEndpoint      `json:"localAPIEndpoint"`
+	SkipPhases       []string         `json:"skipPhases,omitempty"`
+}
+
+// nodeRegistration mirrors kubeadm's NodeRegistrationOptions
+type nodeRegistration struct {
+	Name                  string            `json:"name"`
+	Taints                []taint           `json:"taints"`
+	KubeletExtraArgs      map[string]string `json:"kubeletExtraArgs,omitempty"`
+	IgnorePreflightErrors []string          `json:"ignorePreflightErrors,omitempty"`
+}
+
+// taint mirrors corev1.Taint
+type taint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+// apiEndpoint mirrors kubeadm's APIEndpoint
+type apiEndpoint struct {
+	AdvertiseAddress string `json:"advertiseAddress"`
+	BindPort         int    `json:"bindPort"`
+}
+
+// clusterConfiguration mirrors the parts of kubeadm's ClusterConfiguration we set
+type clusterConfiguration struct {
+	APIVersion           string     `json:"apiVersion"`
+	Kind                 string     `json:"kind"`
+	ClusterName          string     `json:"clusterName"`
+	KubernetesVersion    string     `json:"kubernetesVersion,omitempty"`
+	ControlPlaneEndpoint string     `json:"controlPlaneEndpoint,omitempty"`
+	Networking           networking `json:"networking"`
+}
+
+// networking mirrors kubeadm's Networking
+type networking struct {
+	PodSubnet     string `json:"podSubnet"`
+	ServiceSubnet string `json:"serviceSubnet"`
+}
+
+// kubeletConfiguration mirrors the parts of KubeletConfiguration we set
+type kubeletConfiguration struct {
+	APIVersion   string          `json:"apiVersion"`
+	Kind         string          `json:"kind"`
+	CgroupDriver string          `json:"cgroupDriver"`
+	FailSwapOn   *bool           `json:"failSwapOn,omitempty"`
+	MemorySwap   *memorySwap     `json:"memorySwap,omitempty"`
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+// memorySwap mirrors the kubelet MemorySwapConfiguration
+type memorySwap struct {
+	SwapBehavior string `json:"swapBehavior"`
+}
+
+// kubeProxyConfiguration mirrors the parts of KubeProxyConfiguration we set
+type kubeProxyConfiguration struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Mode       string         `json:"mode"`
+	IPVS       *kubeProxyIPVS `json:"ipvs,omitempty"`
+}
+
+// kubeProxyIPVS mirrors KubeProxyIPVSConfiguration
+type kubeProxyIPVS struct {
+	Scheduler string `json:"scheduler,omitempty"`
+}
+
+// renderKubeadmConfig builds the multi-document kubeadm configuration for kubeadm init
+func renderKubeadmConfig(config *Config) (string, error) {
+	initConfig := initConfiguration{
+		APIVersion: kubeadmAPIVersion,
+		Kind:       "InitConfiguration",
+		NodeRegistration: nodeRegistration{
+			Name:   config.NodeName,
+			Taints: []taint{},
+		},
+		LocalAPIEndpoint: apiEndpoint{
+			AdvertiseAddress: config.APIServerAddr,
+			BindPort:         6443,
+		},
+	}
+
+	clusterConfig := clusterConfiguration{
+		APIVersion:        kubeadmAPIVersion,
+		Kind:              "ClusterConfiguration",
+		ClusterName:       config.ClusterName,
+		KubernetesVersion: config.KubernetesVersion,
+		Networking: networking{
+			PodSubnet:     config.PodCIDR,
+			ServiceSubnet: config.ServiceCIDR,
+		},
+	}
+
+	// Add HA configuration if enabled
+	if config.HighAvailability && config.ControlPlaneEndpoint != "" {
+		clusterConfig.ControlPlaneEndpoint = config.ControlPlaneEndpoint
+	}
+
+	// The network plugin handles services itself
+	if config.ProxyMode == ProxyModeNone {
+		initConfig.SkipPhases = append(initConfig.SkipPhases, "addon/kube-proxy")
+	}
+
+	// Dual-stack nodes must report an address of each family
+	if config.NodeIP != "" {
+		initConfig.NodeRegistration.KubeletExtraArgs = map[string]string{"node-ip": config.NodeIP}
+	}
+
+	kubeletConfig := kubeletConfiguration{
+		APIVersion:   kubeletAPIVersion,
+		Kind:         "KubeletConfiguration",
+		CgroupDriver: "systemd",
+	}
+
+	// NodeSwap: let the kubelet start with swap on and decide how pods may use it
+	if config.SwapBehavior != "" {
+		gates, err := SwapBehaviorGates(config)
+		if err != nil {
+			return "", err
+		}
+		kubeletConfig.FeatureGates = gates
+		failSwapOn := false
+		kubeletConfig.FailSwapOn = &failSwapOn
+		kubeletConfig.MemorySwap = &memorySwap{SwapBehavior: config.SwapBehavior}
+		initConfig.NodeRegistration.IgnorePreflightErrors = append(
+			initConfig.NodeRegistration.IgnorePreflightErrors, "Swap")
+	}
+
+	documents := []interface{}{initConfig, clusterConfig, kubeletConfig}
+
+	// Without a mode kube-proxy keeps kubeadm's default, iptables
+	if config.ProxyMode != "" && config.ProxyMode != ProxyModeNone {
+		proxyConfig := kubeProxyConfiguration{
+			APIVersion: kubeProxyAPIVersion,
+			Kind:       "KubeProxyConfiguration",
+			Mode:       config.ProxyMode,
+		}
+		if config.ProxyMode == ProxyModeIPVS && config.IPVSScheduler != "" {
+			proxyConfig.IPVS = &kubeProxyIPVS{Scheduler: config.IPVSScheduler}
+		}
+		documents = append(documents, proxyConfig)
+	}
+
+	return marshalDocuments(documents...)
+}
+
+// marshalDocuments renders each object as YAML and joins them into one stream
+func marshalDocuments(objects ...interface{}) (string, error) {
+	docs := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(data))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
@@ -0,0 +1,70 @@
+package kubernetes
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/kubeadm")
+
+func TestRenderKubeadmConfigGolden(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *Config)
+	}{
+		{"iptables", func(c *Config) {
+			c.ProxyMode = ProxyModeIPTables
+		}},
+		{"ipvs-scheduler", func(c *Config) {
+			c.ProxyMode = ProxyModeIPVS
+			c.IPVSScheduler = "wrr"
+		}},
+		{"nftables", func(c *Config) {
+			c.KubernetesVersion = "v1.31.2"
+			c.ProxyMode = ProxyModeNFTables
+		}},
+		{"none", func(c *Config) {
+			c.ProxyMode = ProxyModeNone
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.NodeName = "cp1"
+			config.APIServerAddr = "10.0.0.10"
+			tt.setup(config)
+
+			got, err := renderKubeadmConfig(config)
+			if err != nil {
+				t.Fatalf("renderKubeadmConfig() = %v", err)
+			}
+			checkGolden(t, filepath.Join("testdata", "kubeadm", tt.name+".yaml"), []byte(got))
+		})
+	}
+}
+
+// checkGolden compares got with the golden file, or rewrites it with -update
+func checkGolden(t *testing.T, golden string, got []byte) {
+	t.Helper()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s differs from the golden file:\n--- got\n%s\n--- want\n%s", golden, got, want)
+	}
+}
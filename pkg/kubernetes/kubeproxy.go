@@ -0,0 +1,115 @@
+package kubernetes
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// kube-proxy modes
+const (
+	ProxyModeIPTables = "iptables"
+	ProxyModeIPVS     = "ipvs"
+	ProxyModeNFTables = "nftables"
+	ProxyModeNone     = "none" // No kube-proxy; the network plugin handles services
+)
+
+// ProxyModes lists the valid kube-proxy modes
+var ProxyModes = []string{ProxyModeIPTables, ProxyModeIPVS, ProxyModeNFTables, ProxyModeNone}
+
+// IPVSSchedulers lists the IPVS schedulers kube-proxy accepts
+var IPVSSchedulers = []string{"rr", "wrr", "lc", "wlc", "lblc", "lblcr", "sh", "dh", "sed", "nq"}
+
+// nftablesMinMinor is the first Kubernetes 1.x release with the nftables
+// mode enabled by default
+const nftablesMinMinor = 31
+
+// ValidateProxyMode checks the kube-proxy mode and IPVS scheduler
+func ValidateProxyMode(config *Config) error {
+	if config.ProxyMode != "" && !slices.Contains(ProxyModes, config.ProxyMode) {
+		return fmt.Errorf("invalid kube-proxy mode %q: must be one of %s", config.ProxyMode, strings.Join(ProxyModes, ", "))
+	}
+
+	if config.IPVSScheduler != "" {
+		if config.ProxyMode != ProxyModeIPVS {
+			return fmt.Errorf("an IPVS scheduler needs kube-proxy mode %s", ProxyModeIPVS)
+		}
+		if !slices.Contains(IPVSSchedulers, config.IPVSScheduler) {
+			return fmt.Errorf("invalid IPVS scheduler %q: must be one of %s", config.IPVSScheduler, strings.Join(IPVSSchedulers, ", "))
+		}
+	}
+
+	return nil
+}
+
+// ProxyModeSupported checks that the Kubernetes version being installed
+// supports the kube-proxy mode. It needs kubeadm unless the configuration
+// pins a version.
+func ProxyModeSupported(config *Config) error {
+	if config.ProxyMode != ProxyModeNFTables {
+		return nil
+	}
+
+	version, err := kubernetesVersion(config)
+	if err != nil {
+		return err
+	}
+
+	major, minor, err := parseMinorVersion(version)
+	if err != nil {
+		return err
+	}
+	if major == 1 && minor < nftablesMinMinor {
+		return fmt.Errorf("kube-proxy mode %s needs Kubernetes 1.%d or newer, not %s", ProxyModeNFTables, nftablesMinMinor, version)
+	}
+
+	return nil
+}
+
+// SupportedProxyModes returns the kube-proxy modes, other than none, the
+// Kubernetes version being installed supports
+func SupportedProxyModes(config *Config) []string {
+	modes := []string{ProxyModeIPTables, ProxyModeIPVS}
+	nftables := *config
+	nftables.ProxyMode = ProxyModeNFTables
+	if ProxyModeSupported(&nftables) == nil {
+		modes = append(modes, ProxyModeNFTables)
+	}
+	return modes
+}
+
+// kubernetesVersion returns the version pinned in the configuration, or
+// the one of the installed kubeadm
+func kubernetesVersion(config *Config) (string, error) {
+	if config.KubernetesVersion != "" {
+		return config.KubernetesVersion, nil
+	}
+
+	out, err := exec.Command("kubeadm", "version", "-o", "short").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the kubeadm version: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// parseMinorVersion returns the major and minor numbers of a version such
+// as v1.29.3
+func parseMinorVersion(version string) (int, int, error) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("unrecognized Kubernetes version %q", version)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unrecognized Kubernetes version %q", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unrecognized Kubernetes version %q", version)
+	}
+
+	return major, minor, nil
+}
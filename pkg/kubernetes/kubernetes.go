@@ -1,23 +1,37 @@
 package kubernetes
 
 import (
+	"context"
+	_ "embed"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strings"
-	"time"
+	"text/tabwriter"
 
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/ochestra-tech/kubeforge/internal/download"
+	"github.com/ochestra-tech/kubeforge/internal/kube"
 	"github.com/ochestra-tech/kubeforge/internal/logger"
 	"github.com/ochestra-tech/kubeforge/pkg/distro"
+	"github.com/ochestra-tech/kubeforge/pkg/network"
 )
 
 // Config represents Kubernetes configuration parameters
 type Config struct {
-	PodCIDR              string
-	ServiceCIDR          string
+	PodCIDR              string // Comma-separated IPv4 and IPv6 CIDRs for dual-stack
+	ServiceCIDR          string // Comma-separated IPv4 and IPv6 CIDRs for dual-stack
 	APIServerAddr        string
+	NodeIP               string // kubelet --node-ip, one address per family for dual-stack
 	IsControlPlane       bool
 	InstallDashboard     bool
 	ClusterName          string
@@ -27,6 +41,10 @@ type Config struct {
 	NodeName             string
 	Labels               map[string]string
 	Taints               []string
+	SwapBehavior         string            // NodeSwap behavior, see SwapBehaviors; empty disables swap
+	ProxyMode            string            // kube-proxy mode, see ProxyModes; empty keeps kubeadm's default (iptables)
+	IPVSScheduler        string            // IPVS scheduler in ipvs mode, see IPVSSchedulers; empty uses rr
+	Hosts                map[string]string // Cluster node names to IPs, added to /etc/hosts when DNS can't resolve them
 }
 
 // DefaultConfig returns a default configuration
@@ -43,68 +61,122 @@ func DefaultConfig() *Config {
 		NodeName:          "", // Will be set to hostname by default
 		Labels:            make(map[string]string),
 		Taints:            []string{},
+		SwapBehavior:      "", // Swap is turned off by default
+		Hosts:             make(map[string]string),
 	}
 }
 
-// Install installs Kubernetes components
-func Install(dist *distro.Distribution, log *logger.Logger) error {
-	log.Info("Installing Kubernetes components...")
+// kubePackages are the Kubernetes node packages installed and held by KubeForge
+var kubePackages = []string{"kubelet", "kubeadm", "kubectl"}
+
+// defaultPackageMinor is the pkgs.k8s.io release installed when the
+// configuration doesn't pin a Kubernetes version
+const defaultPackageMinor = "v1.29"
+
+// packageRepo returns the pkgs.k8s.io repository of the minor release of
+// version, such as https://pkgs.k8s.io/core:/stable:/v1.31 for v1.31.2.
+// pkgs.k8s.io has one repository per minor release.
+func packageRepo(version string) (string, error) {
+	minor := defaultPackageMinor
+	if version != "" {
+		major, n, err := parseMinorVersion(version)
+		if err != nil {
+			return "", err
+		}
+		minor = fmt.Sprintf("v%d.%d", major, n)
+	}
+	return "https://pkgs.k8s.io/core:/stable:/" + minor, nil
+}
+
+// configurePackageRepo points the package manager at the pkgs.k8s.io
+// repository of the minor release of version, the latest packaged one if it
+// is empty. Arch Linux packages Kubernetes itself.
+func configurePackageRepo(dist *distro.Distribution, version string, log *logger.Logger) error {
+	repo, err := packageRepo(version)
+	if err != nil {
+		return err
+	}
 
 	switch dist.Type {
 	case distro.Debian:
 		// Add Kubernetes apt repository
-		keyCmd := exec.Command("sh", "-c",
-			"curl -fsSL https://pkgs.k8s.io/core:/stable:/v1.29/deb/Release.key | gpg --dearmor -o /etc/apt/keyrings/kubernetes-apt-keyring.gpg")
-		err := keyCmd.Run()
+		downloader := download.New(log)
+		err := downloader.InstallKey(
+			repo+"/deb/Release.key",
+			download.KubernetesKeyFingerprint,
+			"/etc/apt/keyrings/kubernetes-apt-keyring.gpg")
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to install Kubernetes repository key: %v", err)
 		}
 
-		repoCmd := exec.Command("sh", "-c",
-			`echo "deb [signed-by=/etc/apt/keyrings/kubernetes-apt-keyring.gpg] https://pkgs.k8s.io/core:/stable:/v1.29/deb/ /" | tee /etc/apt/sources.list.d/kubernetes.list > /dev/null`)
-		err = repoCmd.Run()
+		err = downloader.VerifyRepository(repo+"/deb/Release", repo+"/deb/Release.gpg", repo+"/deb/Packages",
+			"/etc/apt/keyrings/kubernetes-apt-keyring.gpg")
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to verify Kubernetes repository: %v", err)
 		}
 
-		// Update package lists
-		updateCmd := exec.Command("apt-get", "update")
-		err = updateCmd.Run()
+		source := fmt.Sprintf("deb [arch=%s signed-by=/etc/apt/keyrings/kubernetes-apt-keyring.gpg] %s/deb/ /\n",
+			dist.DebArch(), repo)
+		return os.WriteFile("/etc/apt/sources.list.d/kubernetes.list", []byte(source), 0644)
+
+	case distro.RedHat:
+		// Add Kubernetes yum repository. The exclude line keeps routine
+		// updates from touching the Kubernetes packages, like apt-mark hold.
+		repoContent := fmt.Sprintf(`[kubernetes]
+name=Kubernetes
+baseurl=%[1]s/rpm/
+enabled=1
+gpgcheck=1
+gpgkey=%[1]s/rpm/repodata/repomd.xml.key
+exclude=kubelet kubeadm kubectl cri-tools kubernetes-cni
+`, repo)
+		return os.WriteFile("/etc/yum.repos.d/kubernetes.repo", []byte(repoContent), 0644)
+
+	case distro.Suse:
+		// Add the pkgs.k8s.io rpm repository through zypper
+		err := dist.PackageManager().AddRepo("kubernetes", "Kubernetes", repo+"/rpm/", repo+"/rpm/repodata/repomd.xml.key")
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to add Kubernetes repository: %v", err)
 		}
+		return nil
 
-		// Install Kubernetes components
-		installCmd := exec.Command("apt-get", "install", "-y", "kubelet", "kubeadm", "kubectl")
-		err = installCmd.Run()
-		if err != nil {
+	case distro.ArchLinux:
+		// Kubernetes is packaged in the Arch [extra] repository
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported distribution for Kubernetes installation")
+	}
+}
+
+// Install installs the Kubernetes components of the minor release of
+// version, the latest packaged one if it is empty
+func Install(dist *distro.Distribution, version string, log *logger.Logger) error {
+	log.Info("Installing Kubernetes components...")
+
+	if err := configurePackageRepo(dist, version, log); err != nil {
+		return err
+	}
+
+	switch dist.Type {
+	case distro.Debian:
+		// Install Kubernetes components and hold them to prevent automatic updates
+		pm := dist.PackageManager()
+		if err := pm.Refresh(); err != nil {
 			return err
 		}
 
-		// Hold packages to prevent automatic updates
-		holdCmd := exec.Command("apt-mark", "hold", "kubelet", "kubeadm", "kubectl")
-		err = holdCmd.Run()
-		if err != nil {
+		if err := pm.Install(kubePackages...); err != nil {
 			return err
 		}
 
-	case distro.RedHat:
-		// Add Kubernetes yum repository
-		repoContent := `[kubernetes]
-		name=Kubernetes
-		baseurl=https://pkgs.k8s.io/core:/stable:/v1.29/rpm/
-		enabled=1
-		gpgcheck=1
-		gpgkey=https://pkgs.k8s.io/core:/stable:/v1.29/rpm/repodata/repomd.xml.key
-`
-		err := os.WriteFile("/etc/yum.repos.d/kubernetes.repo", []byte(repoContent), 0644)
-		if err != nil {
+		if err := pm.Hold(kubePackages...); err != nil {
 			return err
 		}
 
+	case distro.RedHat:
 		// Install Kubernetes components
-		installCmd := exec.Command("yum", "install", "-y", "kubelet", "kubeadm", "kubectl")
-		err = installCmd.Run()
+		err := dist.PackageManager().InstallFromRepo("kubernetes", kubePackages...)
 		if err != nil {
 			return err
 		}
@@ -116,19 +188,8 @@ func Install(dist *distro.Distribution, log *logger.Logger) error {
 			return err
 		}
 
-		// SELinux settings recommended for Kubernetes on RHEL/CentOS
-		selinuxCmd := exec.Command("setenforce", "0")
-		selinuxCmd.Run() // Ignore errors as it might already be disabled
-
-		// Update SELinux config file to make the change permanent
-		selinuxConfig := "/etc/selinux/config"
-		if _, err := os.Stat(selinuxConfig); err == nil {
-			sedCmd := exec.Command("sed", "-i", "s/^SELINUX=enforcing$/SELINUX=permissive/", selinuxConfig)
-			sedCmd.Run() // Ignore errors
-		}
-
 		// RHEL-specific: Enable required services for network bridge
-		if dist.Name == "rhel" || dist.Name == "centos" {
+		if dist.Base == "rhel" || dist.Base == "centos" {
 			bridgeCmd := exec.Command("modprobe", "br_netfilter")
 			bridgeCmd.Run()
 
@@ -138,12 +199,7 @@ func Install(dist *distro.Distribution, log *logger.Logger) error {
 		}
 
 		// RHEL 8+ and CentOS 8+ specific: Ensure legacy iptables
-		majorVersion := 0
-		if len(dist.Version) > 0 {
-			fmt.Sscanf(dist.Version, "%d", &majorVersion)
-		}
-
-		if (dist.Name == "rhel" || dist.Name == "centos") && majorVersion >= 8 {
+		if (dist.Base == "rhel" || dist.Base == "centos") && dist.MajorVersion() >= 8 {
 			// Ensure legacy iptables
 			alternativesCmd := exec.Command("alternatives", "--set", "iptables", "/usr/sbin/iptables-legacy")
 			alternativesCmd.Run() // Ignore errors
@@ -153,6 +209,36 @@ func Install(dist *distro.Distribution, log *logger.Logger) error {
 			ip6tablesCmd.Run() // Ignore errors
 		}
 
+	case distro.Suse:
+		pm := dist.PackageManager()
+		if err := pm.Refresh(); err != nil {
+			return err
+		}
+
+		// Install Kubernetes components and lock them against updates
+		if err := pm.Install(kubePackages...); err != nil {
+			return err
+		}
+
+		if err := pm.Hold(kubePackages...); err != nil {
+			return err
+		}
+
+	case distro.ArchLinux:
+		pm := dist.PackageManager()
+		if err := pm.Refresh(); err != nil {
+			return err
+		}
+
+		if err := pm.Install(append(kubePackages, "cri-tools")...); err != nil {
+			return err
+		}
+
+		// Add the packages to IgnorePkg so pacman -Syu leaves them alone
+		if err := pm.Hold(kubePackages...); err != nil {
+			return err
+		}
+
 	default:
 		return fmt.Errorf("unsupported distribution for Kubernetes installation")
 	}
@@ -183,36 +269,14 @@ func InitControlPlane(config *Config, log *logger.Logger) error {
 	}
 
 	// Build kubeadm configuration
-	kubeadmConfig := fmt.Sprintf(`apiVersion: kubeadm.k8s.io/v1beta3
-	kind: InitConfiguration
-	nodeRegistration:
-	name: %s
-	taints: []
-	localAPIEndpoint:
-	advertiseAddress: %s
-	bindPort: 6443
-	---
-	apiVersion: kubeadm.k8s.io/v1beta3
-	kind: ClusterConfiguration
-	clusterName: %s
-	networking:
-	podSubnet: %s
-	serviceSubnet: %s
-	`, config.NodeName, config.APIServerAddr, config.ClusterName, config.PodCIDR, config.ServiceCIDR)
-
-	// Add HA configuration if enabled
-	if config.HighAvailability && config.ControlPlaneEndpoint != "" {
-		kubeadmConfig += fmt.Sprintf("controlPlaneEndpoint: %s\n", config.ControlPlaneEndpoint)
-	}
-
-	// Add specific Kubernetes version if specified
-	if config.KubernetesVersion != "" {
-		kubeadmConfig += fmt.Sprintf("kubernetesVersion: %s\n", config.KubernetesVersion)
+	kubeadmConfig, err := renderKubeadmConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to render kubeadm config: %v", err)
 	}
 
 	// Write config to file
 	kubeadmConfigPath := "/tmp/kubeadm-config.yaml"
-	err := os.WriteFile(kubeadmConfigPath, []byte(kubeadmConfig), 0644)
+	err = os.WriteFile(kubeadmConfigPath, []byte(kubeadmConfig), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write kubeadm config: %v", err)
 	}
@@ -309,149 +373,149 @@ func SetupKubectlForUser(username string, log *logger.Logger) error {
 	return nil
 }
 
-// InstallCalico installs Calico network plugin
-func InstallCalico(config *Config, log *logger.Logger) error {
-	log.Info("Installing Calico network plugin...")
-
-	// Deploy Calico operator
-	tigeraCmd := exec.Command("kubectl", "create", "-f",
-		"https://raw.githubusercontent.com/projectcalico/calico/v3.27.0/manifests/tigera-operator.yaml")
-	tigeraCmd.Stdout = os.Stdout
-	tigeraCmd.Stderr = os.Stderr
+// kubeletDefaultsPaths are where the kubelet packages read
+// KUBELET_EXTRA_ARGS from, per distribution family
+var kubeletDefaultsPaths = map[int]string{
+	distro.Debian:    "/etc/default/kubelet",
+	distro.RedHat:    "/etc/sysconfig/kubelet",
+	distro.Suse:      "/etc/sysconfig/kubelet",
+	distro.ArchLinux: "/etc/default/kubelet",
+}
 
-	err := tigeraCmd.Run()
-	if err != nil {
-		return fmt.Errorf("failed to install Tigera operator: %v", err)
-	}
-
-	// Create custom resources file for Calico
-	calicoResources := fmt.Sprintf(`apiVersion: operator.tigera.io/v1
-	kind: Installation
-	metadata:
-	name: default
-	spec:
-	calicoNetwork:
-		ipPools:
-		- blockSize: 26
-		cidr: %s
-		encapsulation: VXLANCrossSubnet
-		natOutgoing: Enabled
-		nodeSelector: all()
-	`, config.PodCIDR)
-
-	calicoResourcesPath := "/tmp/calico-custom-resources.yaml"
-	err = os.WriteFile(calicoResourcesPath, []byte(calicoResources), 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write Calico resources file: %v", err)
+// SetKubeletNodeIP passes --node-ip to the kubelet outside of kubeadm, for
+// nodes joined with a plain join command. Dual-stack nodes need both
+// addresses. Other KUBELET_EXTRA_ARGS and settings in the file are kept.
+func SetKubeletNodeIP(dist *distro.Distribution, nodeIP string, log *logger.Logger) error {
+	path, ok := kubeletDefaultsPaths[dist.Type]
+	if !ok {
+		return fmt.Errorf("unsupported distribution: %s", dist.Name)
 	}
 
-	// Apply custom resources
-	resourceCmd := exec.Command("kubectl", "create", "-f", calicoResourcesPath)
-	resourceCmd.Stdout = os.Stdout
-	resourceCmd.Stderr = os.Stderr
-
-	if err := resourceCmd.Run(); err != nil {
-		return fmt.Errorf("failed to apply Calico resources: %v", err)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %v", path, err)
 	}
 
-	// Wait for Calico pods to be ready
-	log.Info("Waiting for Calico pods to be ready...")
-
-	// Give some time for the operator to start creating resources
-	time.Sleep(10 * time.Second)
-
-	// Poll until calico-node pods are running
-	maxRetries := 30
-	for i := 0; i < maxRetries; i++ {
-		cmd := exec.Command("kubectl", "get", "pods", "-l", "k8s-app=calico-node", "-A", "-o", "jsonpath={.items[*].status.phase}")
-		output, err := cmd.Output()
+	log.Info("Setting kubelet node IP to %s in %s", nodeIP, path)
+	content := setKubeletExtraArg(string(data), "--node-ip", nodeIP)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
 
-		if err == nil {
-			podsStatus := string(output)
-			allRunning := true
+	return nil
+}
 
-			// Check if any pods are not Running
-			for _, status := range strings.Fields(podsStatus) {
-				if status != "Running" {
-					allRunning = false
-					break
-				}
-			}
+// setKubeletExtraArg sets flag to value in the KUBELET_EXTRA_ARGS line of
+// a kubelet defaults file, replacing an earlier value of the flag
+func setKubeletExtraArg(content, flag, value string) string {
+	var lines []string
+	if content != "" {
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
 
-			if podsStatus != "" && allRunning {
-				log.Info("Calico network plugin successfully installed!")
-				return nil
+	index := -1
+	var args []string
+	for i, line := range lines {
+		current, ok := strings.CutPrefix(strings.TrimSpace(line), "KUBELET_EXTRA_ARGS=")
+		if !ok {
+			continue
+		}
+		index = i
+
+		fields := strings.Fields(strings.Trim(current, `"'`))
+		for j := 0; j < len(fields); j++ {
+			switch {
+			case strings.HasPrefix(fields[j], flag+"="):
+			case fields[j] == flag:
+				j++ // The value follows the flag
+			default:
+				args = append(args, fields[j])
 			}
 		}
+	}
 
-		log.Info("Waiting for Calico pods to be ready... (%d/%d)", i+1, maxRetries)
-		time.Sleep(10 * time.Second)
+	args = append(args, flag+"="+value)
+	line := "KUBELET_EXTRA_ARGS=" + strings.Join(args, " ")
+	if len(args) > 1 {
+		line = fmt.Sprintf("KUBELET_EXTRA_ARGS=%q", strings.Join(args, " "))
 	}
 
-	log.Warn("Timed out waiting for Calico pods. Installation may still be in progress.")
-	return nil
+	if index < 0 {
+		lines = append(lines, line)
+	} else {
+		lines[index] = line
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// InstallCalico installs Calico network plugin with the embedded manifests,
+// using VXLAN across subnets for the pod network
+func InstallCalico(config *Config, log *logger.Logger) error {
+	networkConfig := network.DefaultConfig()
+	networkConfig.Plugin = network.Calico
+	networkConfig.PodCIDR = config.PodCIDR
+	networkConfig.Encapsulation = network.EncapsulationVXLANCrossSubnet
+
+	return network.InstallPlugin(networkConfig, log)
 }
 
+// dashboardAdminUser grants the Dashboard login account cluster-admin
+const dashboardAdminUser = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: admin-user
+  namespace: kubernetes-dashboard
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: admin-user
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cluster-admin
+subjects:
+- kind: ServiceAccount
+  name: admin-user
+  namespace: kubernetes-dashboard
+`
+
+// dashboardManifest is the Dashboard release KubeForge installs. It is
+// applied with cluster-admin rights, so it ships with the binary like the
+// network plugin manifests instead of being fetched at install time.
+//
+//go:embed manifests/dashboard/v2.7.0/recommended.yaml
+var dashboardManifest []byte
+
 // InstallDashboard installs the Kubernetes Dashboard
 func InstallDashboard(log *logger.Logger) error {
 	log.Info("Installing Kubernetes Dashboard...")
 
-	// Deploy dashboard
-	dashboardCmd := exec.Command("kubectl", "apply", "-f",
-		"https://raw.githubusercontent.com/kubernetes/dashboard/v2.7.0/aio/deploy/recommended.yaml")
-	dashboardCmd.Stdout = os.Stdout
-	dashboardCmd.Stderr = os.Stderr
-
-	err := dashboardCmd.Run()
+	client, err := kube.New()
 	if err != nil {
-		return fmt.Errorf("failed to install Dashboard: %v", err)
+		return err
 	}
+	ctx := context.Background()
 
-	// Create admin user for Dashboard
-	adminUserYaml := `apiVersion: v1
-	kind: ServiceAccount
-	metadata:
-	name: admin-user
-	namespace: kubernetes-dashboard
-	---
-	apiVersion: rbac.authorization.k8s.io/v1
-	kind: ClusterRoleBinding
-	metadata:
-	name: admin-user
-	roleRef:
-	apiGroup: rbac.authorization.k8s.io
-	kind: ClusterRole
-	name: cluster-admin
-	subjects:
-	- kind: ServiceAccount
-	name: admin-user
-	namespace: kubernetes-dashboard
-	`
-
-	adminUserPath := "/tmp/dashboard-admin-user.yaml"
-	err = os.WriteFile(adminUserPath, []byte(adminUserYaml), 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write Dashboard admin user file: %v", err)
+	// Deploy dashboard
+	if err := client.Apply(ctx, dashboardManifest); err != nil {
+		return fmt.Errorf("failed to install Dashboard: %v", err)
 	}
 
-	// Apply admin user config
-	userCmd := exec.Command("kubectl", "apply", "-f", adminUserPath)
-	userCmd.Stdout = os.Stdout
-	userCmd.Stderr = os.Stderr
-
-	if err := userCmd.Run(); err != nil {
+	// Create admin user for Dashboard
+	if err := client.Apply(ctx, []byte(dashboardAdminUser)); err != nil {
 		return fmt.Errorf("failed to create Dashboard admin user: %v", err)
 	}
 
 	// Create token for Dashboard login
 	log.Info("Creating token for Dashboard login...")
-	tokenCmd := exec.Command("kubectl", "-n", "kubernetes-dashboard", "create", "token", "admin-user")
-	tokenOutput, err := tokenCmd.Output()
+	token, err := client.Clientset.CoreV1().ServiceAccounts("kubernetes-dashboard").
+		CreateToken(ctx, "admin-user", &authenticationv1.TokenRequest{}, metav1.CreateOptions{})
 
 	if err != nil {
 		log.Warn("Failed to create dashboard token: %v", err)
 	} else {
-		log.Info("Dashboard token:\n%s", string(tokenOutput))
+		log.Info("Dashboard token:\n%s", token.Status.Token)
 	}
 
 	log.Info("To access Dashboard, run: kubectl proxy")
@@ -490,6 +554,24 @@ func JoinCluster(joinCommand string, log *logger.Logger) error {
 	return nil
 }
 
+// JoinEndpointHost returns the host of the API server endpoint a kubeadm
+// join command connects to
+func JoinEndpointHost(joinCommand string) (string, error) {
+	fields := strings.Fields(joinCommand)
+	for i, field := range fields {
+		if field != "join" || i+1 == len(fields) || strings.HasPrefix(fields[i+1], "-") {
+			continue
+		}
+		host, _, err := net.SplitHostPort(fields[i+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid API server endpoint %q in the join command: %v", fields[i+1], err)
+		}
+		return host, nil
+	}
+
+	return "", fmt.Errorf("no API server endpoint in the join command")
+}
+
 // JoinControlPlane joins a node as an additional control plane node
 func JoinControlPlane(joinCommand, certificateKey string, log *logger.Logger) error {
 	log.Info("Joining the Kubernetes cluster as a control plane node...")
@@ -512,43 +594,139 @@ func JoinControlPlane(joinCommand, certificateKey string, log *logger.Logger) er
 
 // LabelNode adds labels to a node
 func LabelNode(nodeName string, labels map[string]string, log *logger.Logger) error {
+	client, err := kube.New()
+	if err != nil {
+		return err
+	}
+
 	for key, value := range labels {
 		log.Info("Adding label %s=%s to node %s", key, value, nodeName)
+	}
 
-		labelCmd := exec.Command("kubectl", "label", "nodes", nodeName, fmt.Sprintf("%s=%s", key, value))
-		if err := labelCmd.Run(); err != nil {
-			return fmt.Errorf("failed to add label %s=%s: %v", key, value, err)
-		}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Clientset.CoreV1().Nodes().Patch(context.Background(), nodeName,
+		types.MergePatchType, patch, metav1.PatchOptions{FieldManager: kube.FieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to label node %s: %v", nodeName, err)
 	}
 
 	return nil
 }
 
-// TaintNode adds taints to a node
+// TaintNode adds taints to a node. Taints use kubectl's syntax,
+// key[=value]:Effect, and a trailing "-" removes the taint instead.
 func TaintNode(nodeName string, taints []string, log *logger.Logger) error {
-	for _, taint := range taints {
-		log.Info("Adding taint %s to node %s", taint, nodeName)
+	client, err := kube.New()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	nodes := client.Clientset.CoreV1().Nodes()
+
+	for _, spec := range taints {
+		taint, remove, err := parseTaint(spec)
+		if err != nil {
+			return err
+		}
 
-		taintCmd := exec.Command("kubectl", "taint", "nodes", nodeName, taint)
-		if err := taintCmd.Run(); err != nil {
-			return fmt.Errorf("failed to add taint %s: %v", taint, err)
+		if remove {
+			log.Info("Removing taint %s from node %s", spec, nodeName)
+		} else {
+			log.Info("Adding taint %s to node %s", spec, nodeName)
+		}
+
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			node, err := nodes.Get(ctx, nodeName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			// Drop any taint with the same key and effect, then add the new one
+			kept := node.Spec.Taints[:0]
+			for _, t := range node.Spec.Taints {
+				if t.Key != taint.Key || (taint.Effect != "" && t.Effect != taint.Effect) {
+					kept = append(kept, t)
+				}
+			}
+			if !remove {
+				kept = append(kept, taint)
+			}
+			node.Spec.Taints = kept
+
+			_, err = nodes.Update(ctx, node, metav1.UpdateOptions{FieldManager: kube.FieldManager})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update taint %s: %v", spec, err)
 		}
 	}
 
 	return nil
 }
 
+// parseTaint parses key[=value]:Effect, with an optional trailing "-"
+// meaning removal, in which case the effect may be omitted
+func parseTaint(spec string) (corev1.Taint, bool, error) {
+	remove := strings.HasSuffix(spec, "-")
+	spec = strings.TrimSuffix(spec, "-")
+
+	var taint corev1.Taint
+	keyValue, effect, hasEffect := strings.Cut(spec, ":")
+	taint.Key, taint.Value, _ = strings.Cut(keyValue, "=")
+	taint.Effect = corev1.TaintEffect(effect)
+
+	if taint.Key == "" {
+		return taint, false, fmt.Errorf("invalid taint %q: missing key", spec)
+	}
+
+	switch taint.Effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	case "":
+		if !remove || hasEffect {
+			return taint, false, fmt.Errorf("invalid taint %q: missing effect", spec)
+		}
+	default:
+		return taint, false, fmt.Errorf("invalid taint %q: unknown effect %s", spec, taint.Effect)
+	}
+
+	return taint, remove, nil
+}
+
 // UpgradeCluster upgrades a Kubernetes cluster to a newer version
-func UpgradeCluster(version string, log *logger.Logger) error {
+func UpgradeCluster(dist *distro.Distribution, version string, log *logger.Logger) error {
 	log.Info("Upgrading Kubernetes cluster to version %s", version)
 
+	pm := dist.PackageManager()
+	var packages []string
+	for _, name := range []string{"kubeadm", "kubelet", "kubectl"} {
+		pkg, err := pm.VersionedPackage(name, version)
+		if err != nil {
+			return err
+		}
+		packages = append(packages, pkg)
+	}
+
+	if err := pm.Unhold(kubePackages...); err != nil {
+		log.Warn("Failed to unhold Kubernetes packages: %v", err)
+	}
+	defer pm.Hold(kubePackages...)
+
+	// pkgs.k8s.io has one repository per minor release
+	if err := configurePackageRepo(dist, version, log); err != nil {
+		return err
+	}
+
 	// Upgrade kubeadm
 	log.Info("Upgrading kubeadm...")
-	upgradeKubeadmCmd := exec.Command("apt-get", "update")
-	upgradeKubeadmCmd.Run()
+	pm.Refresh()
 
-	upgradeKubeadmCmd = exec.Command("apt-get", "install", "-y", fmt.Sprintf("kubeadm=%s-*", version))
-	if err := upgradeKubeadmCmd.Run(); err != nil {
+	if err := pm.InstallFromRepo("kubernetes", packages[0]); err != nil {
 		return fmt.Errorf("failed to upgrade kubeadm: %v", err)
 	}
 
@@ -570,11 +748,9 @@ func UpgradeCluster(version string, log *logger.Logger) error {
 
 	// Upgrade kubelet and kubectl
 	log.Info("Upgrading kubelet and kubectl...")
-	upgradeKubeletCmd := exec.Command("apt-get", "install", "-y",
-		fmt.Sprintf("kubelet=%s-*", version),
-		fmt.Sprintf("kubectl=%s-*", version))
+	err := pm.InstallFromRepo("kubernetes", packages[1:]...)
 
-	if err := upgradeKubeletCmd.Run(); err != nil {
+	if err != nil {
 		return fmt.Errorf("failed to upgrade kubelet and kubectl: %v", err)
 	}
 
@@ -597,31 +773,68 @@ func UpgradeCluster(version string, log *logger.Logger) error {
 func CheckClusterStatus(log *logger.Logger) error {
 	log.Info("Checking Kubernetes cluster status...")
 
-	// Check node status
-	nodeCmd := exec.Command("kubectl", "get", "nodes")
-	nodeCmd.Stdout = os.Stdout
-	nodeCmd.Stderr = os.Stderr
+	client, err := kube.New()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
 
-	if err := nodeCmd.Run(); err != nil {
+	// Check node status
+	nodes, err := client.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
 		return fmt.Errorf("failed to get nodes: %v", err)
 	}
 
-	// Check pod status across all namespaces
-	podCmd := exec.Command("kubectl", "get", "pods", "--all-namespaces")
-	podCmd.Stdout = os.Stdout
-	podCmd.Stderr = os.Stderr
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tVERSION\tINTERNAL-IP")
+	for _, node := range nodes.Items {
+		status := "NotReady"
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				status = "Ready"
+			}
+		}
 
-	if err := podCmd.Run(); err != nil {
+		internalIP := ""
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP {
+				internalIP = address.Address
+				break
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", node.Name, status, node.Status.NodeInfo.KubeletVersion, internalIP)
+	}
+	w.Flush()
+	fmt.Println()
+
+	// Check pod status across all namespaces
+	pods, err := client.Clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
 		return fmt.Errorf("failed to get pods: %v", err)
 	}
 
-	// Check component status
-	csCmd := exec.Command("kubectl", "get", "componentstatuses")
-	csCmd.Stdout = os.Stdout
-	csCmd.Stderr = os.Stderr
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tREADY\tSTATUS\tNODE")
+	for _, pod := range pods.Items {
+		ready := 0
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Ready {
+				ready++
+			}
+		}
 
-	if err := csCmd.Run(); err != nil {
-		log.Warn("Failed to get component status: %v", err)
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\n", pod.Namespace, pod.Name,
+			ready, len(pod.Spec.Containers), pod.Status.Phase, pod.Spec.NodeName)
+	}
+	w.Flush()
+	fmt.Println()
+
+	// Check API server health; componentstatuses is deprecated
+	if _, err := client.Clientset.Discovery().RESTClient().Get().AbsPath("/readyz").DoRaw(ctx); err != nil {
+		log.Warn("API server is not ready: %v", err)
+	} else {
+		log.Info("API server is ready")
 	}
 
 	return nil
@@ -0,0 +1,36 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+)
+
+func TestDashboardManifest(t *testing.T) {
+	objects, err := kube.DecodeManifest(dashboardManifest)
+	if err != nil {
+		t.Fatalf("DecodeManifest() = %v", err)
+	}
+
+	var images []string
+	for _, obj := range objects {
+		if obj.GetKind() != "Namespace" && obj.GetKind() != "ClusterRole" && obj.GetKind() != "ClusterRoleBinding" &&
+			obj.GetNamespace() != "kubernetes-dashboard" {
+			t.Errorf("%s %s is outside the kubernetes-dashboard namespace", obj.GetKind(), obj.GetName())
+		}
+		if obj.GetKind() == "Deployment" {
+			containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+			for _, container := range containers {
+				images = append(images, container.(map[string]interface{})["image"].(string))
+			}
+		}
+	}
+
+	want := "kubernetesui/dashboard:v2.7.0,kubernetesui/metrics-scraper:v1.0.8"
+	if got := strings.Join(images, ","); got != want {
+		t.Errorf("Dashboard images = %s, want %s", got, want)
+	}
+}
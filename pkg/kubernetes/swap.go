@@ -0,0 +1,70 @@
+package kubernetes
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// kubelet NodeSwap behaviors
+const (
+	SwapNoSwap        = "NoSwap"        // Swap stays on the node but pods don't use it (1.30+)
+	SwapLimitedSwap   = "LimitedSwap"   // Burstable pods may swap in proportion to their memory request
+	SwapUnlimitedSwap = "UnlimitedSwap" // Pods may swap up to their limit (1.28 and 1.29 only)
+)
+
+// SwapBehaviors lists the swap behaviors the kubelet accepts in any release
+var SwapBehaviors = []string{SwapNoSwap, SwapLimitedSwap, SwapUnlimitedSwap}
+
+// NodeSwap release boundaries: beta from 1.28, UnlimitedSwap replaced by
+// NoSwap in 1.30, and the feature gate locked on in 1.34
+const (
+	nodeSwapMinMinor = 28
+	noSwapMinMinor   = 30
+	nodeSwapGAMinor  = 34
+)
+
+// ValidateSwapBehavior checks the swap behavior against the values any
+// release accepts. SwapBehaviorGates checks it against the release.
+func ValidateSwapBehavior(config *Config) error {
+	if config.SwapBehavior != "" && !slices.Contains(SwapBehaviors, config.SwapBehavior) {
+		return fmt.Errorf("invalid swap behavior %q: must be one of %s", config.SwapBehavior, strings.Join(SwapBehaviors, ", "))
+	}
+	return nil
+}
+
+// SwapBehaviorGates checks that the Kubernetes version being installed
+// supports the swap behavior and returns the kubelet feature gates it
+// needs. It needs kubeadm unless the configuration pins a version.
+func SwapBehaviorGates(config *Config) (map[string]bool, error) {
+	if config.SwapBehavior == "" {
+		return nil, nil
+	}
+
+	version, err := kubernetesVersion(config)
+	if err != nil {
+		return nil, err
+	}
+	major, minor, err := parseMinorVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if major != 1 {
+		return nil, nil
+	}
+
+	switch {
+	case minor < nodeSwapMinMinor:
+		return nil, fmt.Errorf("swap behavior %s needs Kubernetes 1.%d or newer, not %s", config.SwapBehavior, nodeSwapMinMinor, version)
+	case minor < noSwapMinMinor && config.SwapBehavior == SwapNoSwap:
+		return nil, fmt.Errorf("swap behavior %s needs Kubernetes 1.%d or newer, use %s or %s with %s",
+			SwapNoSwap, noSwapMinMinor, SwapLimitedSwap, SwapUnlimitedSwap, version)
+	case minor >= noSwapMinMinor && config.SwapBehavior == SwapUnlimitedSwap:
+		return nil, fmt.Errorf("swap behavior %s was removed in Kubernetes 1.%d, use %s or %s with %s",
+			SwapUnlimitedSwap, noSwapMinMinor, SwapNoSwap, SwapLimitedSwap, version)
+	case minor >= nodeSwapGAMinor:
+		return nil, nil
+	}
+
+	return map[string]bool{"NodeSwap": true}, nil
+}
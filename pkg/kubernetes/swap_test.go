@@ -0,0 +1,62 @@
+package kubernetes
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestValidateSwapBehavior(t *testing.T) {
+	for _, behavior := range []string{"", SwapNoSwap, SwapLimitedSwap, SwapUnlimitedSwap} {
+		if err := ValidateSwapBehavior(&Config{SwapBehavior: behavior}); err != nil {
+			t.Errorf("ValidateSwapBehavior(%q) = %v, want no error", behavior, err)
+		}
+	}
+	if err := ValidateSwapBehavior(&Config{SwapBehavior: "limitedswap"}); err == nil {
+		t.Error("ValidateSwapBehavior() accepted limitedswap")
+	}
+}
+
+func TestSwapBehaviorGates(t *testing.T) {
+	nodeSwap := map[string]bool{"NodeSwap": true}
+
+	tests := []struct {
+		name     string
+		version  string
+		behavior string
+		want     map[string]bool
+		wantErr  string // Substring of the error, empty for none
+	}{
+		{"no swap behavior", "v1.27.0", "", nil, ""},
+		{"before NodeSwap beta", "v1.27.4", SwapLimitedSwap, nil, "needs Kubernetes 1.28 or newer"},
+		{"1.28 limited", "v1.28.0", SwapLimitedSwap, nodeSwap, ""},
+		{"1.28 unlimited", "v1.28.0", SwapUnlimitedSwap, nodeSwap, ""},
+		{"1.28 no swap", "v1.28.0", SwapNoSwap, nil, "NoSwap needs Kubernetes 1.30 or newer"},
+		{"1.29 unlimited", "1.29.5", SwapUnlimitedSwap, nodeSwap, ""},
+		{"1.30 no swap", "v1.30.0", SwapNoSwap, nodeSwap, ""},
+		{"1.30 limited", "v1.30.2", SwapLimitedSwap, nodeSwap, ""},
+		{"1.30 unlimited", "v1.30.0", SwapUnlimitedSwap, nil, "UnlimitedSwap was removed in Kubernetes 1.30"},
+		{"1.33 limited", "v1.33.1", SwapLimitedSwap, nodeSwap, ""},
+		{"1.34 limited needs no gate", "v1.34.0", SwapLimitedSwap, nil, ""},
+		{"1.34 no swap needs no gate", "v1.34.2", SwapNoSwap, nil, ""},
+		{"1.34 unlimited", "v1.34.0", SwapUnlimitedSwap, nil, "UnlimitedSwap was removed"},
+		{"unparsable version", "latest", SwapLimitedSwap, nil, `unrecognized Kubernetes version "latest"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SwapBehaviorGates(&Config{KubernetesVersion: tt.version, SwapBehavior: tt.behavior})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("SwapBehaviorGates() = %v, want no error", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("SwapBehaviorGates() accepted %s on %s", tt.behavior, tt.version)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("SwapBehaviorGates() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("SwapBehaviorGates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// NodeAddress is the address a node is reached at in each IP family
+type NodeAddress struct {
+	Interface string
+	IPv4      netip.Addr
+	IPv6      netip.Addr
+}
+
+// Primary returns the address to advertise the API server on: IPv6 in
+// IPv6-only clusters, IPv4 otherwise
+func (a *NodeAddress) Primary(mode StackMode) (netip.Addr, error) {
+	if mode == IPv6Only {
+		if !a.IPv6.IsValid() {
+			return netip.Addr{}, fmt.Errorf("%s has no IPv6 address", a.Interface)
+		}
+		return a.IPv6, nil
+	}
+
+	if !a.IPv4.IsValid() {
+		return netip.Addr{}, fmt.Errorf("%s has no IPv4 address", a.Interface)
+	}
+	return a.IPv4, nil
+}
+
+// NodeIP returns the kubelet --node-ip value for the stack mode, with an
+// address of each family for dual-stack
+func (a *NodeAddress) NodeIP(mode StackMode) (string, error) {
+	primary, err := a.Primary(mode)
+	if err != nil {
+		return "", err
+	}
+	if mode != DualStack {
+		return primary.String(), nil
+	}
+
+	if !a.IPv6.IsValid() {
+		return "", fmt.Errorf("%s has no IPv6 address for a dual-stack node", a.Interface)
+	}
+	return primary.String() + "," + a.IPv6.String(), nil
+}
+
+// SelectNodeAddress picks the node's addresses from config.NodeInterface,
+// from the interface holding an address in config.NodeAddressCIDR, or
+// from the default-route interface when neither is set. Loopback,
+// link-local and multicast addresses are never chosen.
+func SelectNodeAddress(config *Config) (*NodeAddress, error) {
+	switch {
+	case config.NodeInterface != "":
+		iface, err := net.InterfaceByName(config.NodeInterface)
+		if err != nil {
+			return nil, fmt.Errorf("node interface %s: %v", config.NodeInterface, err)
+		}
+
+		addr := &NodeAddress{Interface: iface.Name}
+		addr.IPv4, addr.IPv6 = interfaceAddrs(iface, nil)
+		if !addr.IPv4.IsValid() && !addr.IPv6.IsValid() {
+			return nil, fmt.Errorf("node interface %s has no usable address", iface.Name)
+		}
+		return addr, nil
+
+	case config.NodeAddressCIDR != "":
+		prefixes, err := ParseCIDRs(config.NodeAddressCIDR)
+		if err != nil {
+			return nil, err
+		}
+
+		interfaces, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+
+		addr := &NodeAddress{}
+		for i := range interfaces {
+			if interfaces[i].Flags&net.FlagUp == 0 || isCNIInterface(interfaces[i].Name) {
+				continue
+			}
+
+			ipv4, ipv6 := interfaceAddrs(&interfaces[i], prefixes)
+			if !addr.IPv4.IsValid() && ipv4.IsValid() {
+				addr.IPv4 = ipv4
+			}
+			if !addr.IPv6.IsValid() && ipv6.IsValid() {
+				addr.IPv6 = ipv6
+			}
+			if addr.Interface == "" && (ipv4.IsValid() || ipv6.IsValid()) {
+				addr.Interface = interfaces[i].Name
+			}
+		}
+
+		if addr.Interface == "" {
+			return nil, fmt.Errorf("no interface has an address in %s", config.NodeAddressCIDR)
+		}
+		return addr, nil
+
+	default:
+		addr := &NodeAddress{}
+
+		if name, err := defaultRouteInterface4(); err == nil && name != "" {
+			if iface, err := net.InterfaceByName(name); err == nil {
+				addr.Interface = name
+				addr.IPv4, _ = interfaceAddrs(iface, nil)
+			}
+		}
+
+		if name, err := defaultRouteInterface6(); err == nil && name != "" {
+			if iface, err := net.InterfaceByName(name); err == nil {
+				if addr.Interface == "" {
+					addr.Interface = name
+				}
+				_, addr.IPv6 = interfaceAddrs(iface, nil)
+			}
+		}
+
+		if !addr.IPv4.IsValid() && !addr.IPv6.IsValid() {
+			return nil, fmt.Errorf("no usable address on a default-route interface")
+		}
+		return addr, nil
+	}
+}
+
+// interfaceAddrs returns the first usable IPv4 and IPv6 address of an
+// interface, restricted to prefixes when any are given
+func interfaceAddrs(iface *net.Interface, prefixes []netip.Prefix) (ipv4, ipv6 netip.Addr) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return ipv4, ipv6
+	}
+
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil || !usableAddr(prefix.Addr()) {
+			continue
+		}
+		addr := prefix.Addr()
+
+		if len(prefixes) > 0 {
+			inRange := false
+			for _, p := range prefixes {
+				if p.Contains(addr) {
+					inRange = true
+				}
+			}
+			if !inRange {
+				continue
+			}
+		}
+
+		if addr.Is4() && !ipv4.IsValid() {
+			ipv4 = addr
+		}
+		if addr.Is6() && !ipv6.IsValid() {
+			ipv6 = addr
+		}
+	}
+
+	return ipv4, ipv6
+}
+
+// usableAddr reports whether other nodes could reach the node at addr
+func usableAddr(addr netip.Addr) bool {
+	return !addr.IsLoopback() && !addr.IsLinkLocalUnicast() && !addr.IsMulticast() && !addr.IsUnspecified()
+}
+
+// addressRegex returns an anchored regular expression matching the IPv4
+// addresses inside prefixes, for Flannel's --iface-regex, or "" when there
+// are none. IPv6 addresses have too many spellings to match reliably.
+func addressRegex(prefixes []netip.Prefix) string {
+	var patterns []string
+	for _, prefix := range prefixes {
+		if !prefix.Addr().Is4() {
+			continue
+		}
+		prefix = prefix.Masked()
+		octets := prefix.Addr().As4()
+		bits := prefix.Bits()
+
+		var parts []string
+		for i := 0; i < 4; i++ {
+			switch fixed := bits - 8*i; {
+			case fixed >= 8:
+				parts = append(parts, strconv.Itoa(int(octets[i])))
+			case fixed <= 0:
+				parts = append(parts, `\d+`)
+			default:
+				var values []string
+				for v := 0; v < 1<<(8-fixed); v++ {
+					values = append(values, strconv.Itoa(int(octets[i])+v))
+				}
+				parts = append(parts, "("+strings.Join(values, "|")+")")
+			}
+		}
+		patterns = append(patterns, strings.Join(parts, `\.`))
+	}
+
+	switch len(patterns) {
+	case 0:
+		return ""
+	case 1:
+		return "^" + patterns[0] + "$"
+	default:
+		return "^(" + strings.Join(patterns, "|") + ")$"
+	}
+}
@@ -0,0 +1,94 @@
+package network
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestAddressRegex(t *testing.T) {
+	tests := []struct {
+		cidrs   string
+		want    string
+		match   []string // First and last address in range
+		noMatch []string // Just outside the range
+	}{
+		{
+			cidrs:   "10.0.0.0/8",
+			want:    `^10\.\d+\.\d+\.\d+$`,
+			match:   []string{"10.0.0.0", "10.255.255.255"},
+			noMatch: []string{"9.255.255.255", "11.0.0.0"},
+		},
+		{
+			cidrs:   "172.16.0.0/16",
+			want:    `^172\.16\.\d+\.\d+$`,
+			match:   []string{"172.16.0.0", "172.16.255.255"},
+			noMatch: []string{"172.15.255.255", "172.17.0.0"},
+		},
+		{
+			cidrs:   "192.168.1.0/24",
+			want:    `^192\.168\.1\.\d+$`,
+			match:   []string{"192.168.1.0", "192.168.1.255"},
+			noMatch: []string{"192.168.0.255", "192.168.2.0"},
+		},
+		{
+			cidrs:   "10.20.16.0/20",
+			want:    `^10\.20\.(16|17|18|19|20|21|22|23|24|25|26|27|28|29|30|31)\.\d+$`,
+			match:   []string{"10.20.16.0", "10.20.31.255"},
+			noMatch: []string{"10.20.15.255", "10.20.32.0"},
+		},
+		{
+			cidrs:   "10.20.0.0/20",
+			want:    `^10\.20\.(0|1|2|3|4|5|6|7|8|9|10|11|12|13|14|15)\.\d+$`,
+			match:   []string{"10.20.0.0", "10.20.15.255"},
+			noMatch: []string{"10.19.255.255", "10.20.16.0", "10.20.150.0"},
+		},
+		{
+			cidrs:   "10.20.16.0/22",
+			want:    `^10\.20\.(16|17|18|19)\.\d+$`,
+			match:   []string{"10.20.16.0", "10.20.19.255"},
+			noMatch: []string{"10.20.15.255", "10.20.20.0"},
+		},
+		{
+			cidrs:   "10.1.2.3/32,fd00::/64",
+			want:    `^10\.1\.2\.3$`,
+			match:   []string{"10.1.2.3"},
+			noMatch: []string{"10.1.2.2", "10.1.2.4", "10.1.2.30"},
+		},
+		{
+			cidrs: "fd00::/64",
+			want:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cidrs, func(t *testing.T) {
+			prefixes, err := ParseCIDRs(tt.cidrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := addressRegex(prefixes)
+			if got != tt.want {
+				t.Errorf("addressRegex(%s) = %s, want %s", tt.cidrs, got, tt.want)
+			}
+			if got == "" {
+				return
+			}
+
+			re, err := regexp.Compile(got)
+			if err != nil {
+				t.Fatalf("addressRegex(%s) = %s does not compile: %v", tt.cidrs, got, err)
+			}
+			for _, addr := range tt.match {
+				if !re.MatchString(addr) {
+					t.Errorf("%s does not match %s", got, addr)
+				}
+			}
+			for _, addr := range tt.noMatch {
+				if re.MatchString(addr) {
+					t.Errorf("%s matches %s, outside %s", got, addr, tt.cidrs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/internal/wait"
+)
+
+// Calico IP pool encapsulation modes
+const (
+	EncapsulationIPIP             = "IPIP"
+	EncapsulationIPIPCrossSubnet  = "IPIPCrossSubnet"
+	EncapsulationVXLAN            = "VXLAN"
+	EncapsulationVXLANCrossSubnet = "VXLANCrossSubnet"
+	EncapsulationNone             = "None"
+)
+
+// CalicoEncapsulations lists the valid Calico encapsulation modes
+var CalicoEncapsulations = []string{
+	EncapsulationIPIP,
+	EncapsulationIPIPCrossSubnet,
+	EncapsulationVXLAN,
+	EncapsulationVXLANCrossSubnet,
+	EncapsulationNone,
+}
+
+// BGPPeer is an external router Calico nodes peer with
+type BGPPeer struct {
+	PeerIP       string `json:"peerIP"`
+	ASNumber     uint32 `json:"asNumber"`
+	NodeSelector string `json:"nodeSelector,omitempty"` // Nodes that peer, all if empty
+}
+
+// UsesIPIP reports whether the configured Calico pool encapsulates with IP-in-IP
+func (c *Config) UsesIPIP() bool {
+	return strings.HasPrefix(c.Encapsulation, EncapsulationIPIP)
+}
+
+// UsesVXLAN reports whether the configured Calico pool encapsulates with VXLAN
+func (c *Config) UsesVXLAN() bool {
+	return strings.HasPrefix(c.Encapsulation, EncapsulationVXLAN)
+}
+
+// calicoPoolEncapsulation returns the encapsulation of the Calico IP pool of
+// one address family. Calico has no IP-in-IP for IPv6, so IPv6 pools are
+// routed natively over BGP instead.
+func calicoPoolEncapsulation(config *Config, ipv6 bool) string {
+	if ipv6 && config.UsesIPIP() {
+		return EncapsulationNone
+	}
+	return config.Encapsulation
+}
+
+// ValidateCalico checks the Calico routing options for invalid or
+// incompatible combinations
+func ValidateCalico(config *Config) error {
+	valid := false
+	for _, e := range CalicoEncapsulations {
+		if config.Encapsulation == e {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid Calico encapsulation %q, must be one of %s",
+			config.Encapsulation, strings.Join(CalicoEncapsulations, ", "))
+	}
+
+	if !config.EnableBGP {
+		// Without BGP nothing distributes the routes IPIP and unencapsulated pools rely on
+		if config.UsesIPIP() {
+			return fmt.Errorf("calico %s encapsulation requires BGP", config.Encapsulation)
+		}
+		if config.Encapsulation == EncapsulationNone {
+			return fmt.Errorf("calico without encapsulation requires BGP")
+		}
+		if config.ASNumber != 0 || len(config.BGPPeers) > 0 {
+			return fmt.Errorf("calico AS number and BGP peers require BGP to be enabled")
+		}
+	}
+
+	for _, peer := range config.BGPPeers {
+		if _, err := netip.ParseAddr(peer.PeerIP); err != nil {
+			return fmt.Errorf("invalid BGP peer IP %q: %v", peer.PeerIP, err)
+		}
+		if peer.ASNumber == 0 {
+			return fmt.Errorf("BGP peer %s has no AS number", peer.PeerIP)
+		}
+	}
+
+	return nil
+}
+
+// configureCalico applies the settings that live outside the Installation
+// resource: the BGP AS number and peers, and WireGuard in FelixConfiguration.
+// These are projectcalico.org/v3 resources, served by the Calico API server.
+func configureCalico(config *Config, log *logger.Logger) error {
+	needsBGP := config.EnableBGP && (config.ASNumber != 0 || len(config.BGPPeers) > 0)
+	if !needsBGP && !config.EnableEncryption {
+		return nil
+	}
+
+	client, err := kube.New()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+
+	log.Info("Waiting for the Calico API server...")
+	if err := wait.Deployment(ctx, client, "calico-apiserver", "calico-apiserver", log); err != nil {
+		return err
+	}
+
+	if needsBGP {
+		log.Info("Configuring Calico BGP...")
+		bgp, err := RenderManifest(config, "bgp.yaml")
+		if err != nil {
+			return err
+		}
+		if err := client.ForceApply(ctx, bgp); err != nil {
+			return fmt.Errorf("failed to configure Calico BGP: %v", err)
+		}
+	}
+
+	if config.EnableEncryption {
+		log.Info("Enabling Calico WireGuard encryption...")
+		patch := wireguardPatch(config)
+
+		// The operator creates the default FelixConfiguration shortly after calico-node starts
+		err := wait.Retry(ctx, 5*time.Second, func() error {
+			return client.MergePatch(ctx, "projectcalico.org/v3", "FelixConfiguration", "", "default", patch)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to enable WireGuard: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// wireguardPatch returns the FelixConfiguration patch enabling WireGuard
+// for each address family of the pod network
+func wireguardPatch(config *Config) []byte {
+	var fields []string
+	if config.PodCIDRv4() != "" {
+		fields = append(fields, `"wireguardEnabled":true`)
+	}
+	if config.PodCIDRv6() != "" {
+		fields = append(fields, `"wireguardEnabledV6":true`)
+	}
+	return []byte(`{"spec":{` + strings.Join(fields, ",") + `}}`)
+}
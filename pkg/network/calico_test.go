@@ -0,0 +1,27 @@
+package network
+
+import "testing"
+
+func TestWireguardPatch(t *testing.T) {
+	tests := []struct {
+		podCIDR string
+		want    string
+	}{
+		{"10.244.0.0/16", `{"spec":{"wireguardEnabled":true}}`},
+		{"fd00:10:244::/56", `{"spec":{"wireguardEnabledV6":true}}`},
+		{"10.244.0.0/16,fd00:10:244::/56", `{"spec":{"wireguardEnabled":true,"wireguardEnabledV6":true}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.podCIDR, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Plugin = Calico
+			config.PodCIDR = tt.podCIDR
+			config.EnableEncryption = true
+
+			if got := string(wireguardPatch(config)); got != tt.want {
+				t.Errorf("wireguardPatch() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,427 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+)
+
+// Default kube-controller-manager --node-cidr-mask-size values: each node
+// gets a subnet of this size carved out of the pod CIDR
+const (
+	NodeCIDRMaskSizeIPv4 = 24
+	NodeCIDRMaskSizeIPv6 = 64
+)
+
+// The largest service CIDRs kube-apiserver accepts
+const (
+	MinServicePrefixIPv4 = 12
+	MinServicePrefixIPv6 = 108
+)
+
+// cniInterfacePrefixes are interfaces created by network plugins, whose
+// routes cover the pod CIDR itself and must not count as conflicts
+var cniInterfacePrefixes = []string{
+	"cali", "tunl0", "vxlan.calico", "wireguard.cali",
+	"flannel", "cni0",
+	"weave", "datapath", "vethwe", "vxlan-6784",
+	"cilium_",
+	"kube-ipvs0",
+}
+
+// ciliumEndpointRe matches the host side of Cilium's endpoint veths, lxc
+// followed by a hex hash, and its health endpoint. A bare "lxc" prefix
+// would also take in LXC bridges such as lxcbr0, which are host networks.
+var ciliumEndpointRe = regexp.MustCompile(`^lxc([0-9a-f]+|_health)$`)
+
+// HostNetwork is a subnet the host has an address in or a route to
+type HostNetwork struct {
+	Prefix    netip.Prefix
+	Interface string
+}
+
+// ParseCIDR parses a CIDR, rejecting ones with host bits set
+func ParseCIDR(cidr string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %v", cidr, err)
+	}
+
+	if prefix != prefix.Masked() {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: host bits are set, did you mean %s?", cidr, prefix.Masked())
+	}
+
+	return prefix, nil
+}
+
+// ValidateCIDR checks if the provided CIDR is valid
+func ValidateCIDR(cidr string) error {
+	_, err := ParseCIDR(cidr)
+	return err
+}
+
+// ValidatePodCIDR checks each pod CIDR is large enough to give every
+// node a subnet and, for Calico, at least one IPAM block, and that the
+// plugin supports the resulting stack mode
+func ValidatePodCIDR(config *Config) error {
+	prefixes, err := ParseCIDRs(config.PodCIDR)
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range prefixes {
+		nodeMask := NodeCIDRMaskSizeIPv4
+		if prefix.Addr().Is6() {
+			nodeMask = NodeCIDRMaskSizeIPv6
+		}
+		if prefix.Bits() > nodeMask {
+			return fmt.Errorf("pod CIDR %s is smaller than the /%d each node is allocated", prefix, nodeMask)
+		}
+
+		if config.Plugin == Calico {
+			blockSize, minBlock, maxBlock := config.BlockSize, 20, 32
+			if prefix.Addr().Is6() {
+				blockSize, minBlock, maxBlock = config.BlockSizeV6, 116, 128
+			}
+			if blockSize < minBlock || blockSize > maxBlock {
+				return fmt.Errorf("calico block size /%d is outside /%d-/%d", blockSize, minBlock, maxBlock)
+			}
+			if prefix.Bits() > blockSize {
+				return fmt.Errorf("pod CIDR %s is smaller than the Calico block size /%d", prefix, blockSize)
+			}
+		}
+	}
+
+	return ValidateStackMode(config)
+}
+
+// ValidateServiceCIDR checks each service CIDR is within the size
+// kube-apiserver accepts
+func ValidateServiceCIDR(cidrs string) error {
+	prefixes, err := ParseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range prefixes {
+		minPrefix := MinServicePrefixIPv4
+		if prefix.Addr().Is6() {
+			minPrefix = MinServicePrefixIPv6
+		}
+		if prefix.Bits() < minPrefix {
+			return fmt.Errorf("service CIDR %s is larger than the /%d kube-apiserver allows", prefix, minPrefix)
+		}
+	}
+
+	return nil
+}
+
+// ValidateClusterCIDRs validates the pod and service CIDRs, checks they use
+// the same IP families and that they don't overlap each other
+func ValidateClusterCIDRs(config *Config, serviceCIDR string) error {
+	if err := ValidatePodCIDR(config); err != nil {
+		return err
+	}
+	if err := ValidateServiceCIDR(serviceCIDR); err != nil {
+		return err
+	}
+
+	pods, _ := ParseCIDRs(config.PodCIDR)
+	services, _ := ParseCIDRs(serviceCIDR)
+	if StackModeOf(pods) != StackModeOf(services) {
+		return fmt.Errorf("pod CIDRs %s are %s but service CIDRs %s are %s",
+			config.PodCIDR, StackModeOf(pods), serviceCIDR, StackModeOf(services))
+	}
+
+	for _, pod := range pods {
+		for _, service := range services {
+			if pod.Overlaps(service) {
+				return fmt.Errorf("pod CIDR %s overlaps service CIDR %s", pod, service)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckHostNetworks checks that the pod and service CIDRs overlap no
+// network the host is attached to. It only holds before the cluster
+// exists: afterwards the plugin's own routes and, with BGP or native
+// routing, the pod CIDRs of other nodes are in the routing table.
+func CheckHostNetworks(config *Config, serviceCIDR string) error {
+	hostNetworks, err := HostNetworks()
+	if err != nil {
+		return fmt.Errorf("failed to read host networks: %v", err)
+	}
+
+	return checkHostNetworks(config, serviceCIDR, hostNetworks)
+}
+
+// checkHostNetworks checks the pod and service CIDRs against hostNetworks
+func checkHostNetworks(config *Config, serviceCIDR string, hostNetworks []HostNetwork) error {
+	type clusterNetwork struct {
+		name   string
+		prefix netip.Prefix
+	}
+	var clusterNetworks []clusterNetwork
+	pods, err := ParseCIDRs(config.PodCIDR)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		clusterNetworks = append(clusterNetworks, clusterNetwork{"pod", pod})
+	}
+	services, err := ParseCIDRs(serviceCIDR)
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		clusterNetworks = append(clusterNetworks, clusterNetwork{"service", service})
+	}
+
+	var conflicts []string
+	for _, hn := range hostNetworks {
+		for _, cluster := range clusterNetworks {
+			if cluster.prefix.Overlaps(hn.Prefix) {
+				conflicts = append(conflicts,
+					fmt.Sprintf("%s CIDR %s overlaps %s on %s", cluster.name, cluster.prefix, hn.Prefix, hn.Interface))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("cluster networks conflict with the host: %s", strings.Join(conflicts, "; "))
+	}
+
+	return nil
+}
+
+// HostNetworks returns the subnets of the host's interface addresses and
+// routing table, skipping loopback, link-local, multicast, default routes
+// and network plugin interfaces
+func HostNetworks() ([]HostNetwork, error) {
+	seen := make(map[HostNetwork]bool)
+	var networks []HostNetwork
+	add := func(prefix netip.Prefix, iface string) {
+		hn := HostNetwork{Prefix: prefix.Masked(), Interface: iface}
+		if prefix.Bits() == 0 || prefix.Addr().IsLoopback() || prefix.Addr().IsLinkLocalUnicast() || prefix.Addr().IsMulticast() ||
+			isCNIInterface(iface) || seen[hn] {
+			return
+		}
+		seen[hn] = true
+		networks = append(networks, hn)
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if prefix, err := netip.ParsePrefix(addr.String()); err == nil {
+				add(prefix, iface.Name)
+			}
+		}
+	}
+
+	routes, err := hostRoutes()
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		add(route.Prefix, route.Interface)
+	}
+
+	return networks, nil
+}
+
+// hostRoutes reads the IPv4 and IPv6 routing tables from /proc
+func hostRoutes() ([]HostNetwork, error) {
+	var routes []HostNetwork
+
+	v4, err := readRouteTable("/proc/net/route", parseIPv4Route)
+	if err != nil {
+		return nil, err
+	}
+	routes = append(routes, v4...)
+
+	// IPv6 may be disabled, in which case the table is missing
+	v6, err := readRouteTable("/proc/net/ipv6_route", parseIPv6Route)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	routes = append(routes, v6...)
+
+	return routes, nil
+}
+
+// readRouteTable parses each line of a /proc routing table
+func readRouteTable(path string, parse func([]string) (HostNetwork, bool)) ([]HostNetwork, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var routes []HostNetwork
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if route, ok := parse(strings.Fields(scanner.Text())); ok {
+			routes = append(routes, route)
+		}
+	}
+
+	return routes, scanner.Err()
+}
+
+// parseIPv4Route parses a /proc/net/route line: Iface Destination Gateway
+// Flags RefCnt Use Metric Mask, with addresses as little-endian hex
+func parseIPv4Route(fields []string) (HostNetwork, bool) {
+	if len(fields) < 8 || fields[0] == "Iface" {
+		return HostNetwork{}, false
+	}
+
+	dest, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return HostNetwork{}, false
+	}
+	mask, err := strconv.ParseUint(fields[7], 16, 32)
+	if err != nil {
+		return HostNetwork{}, false
+	}
+
+	var addr, maskBytes [4]byte
+	binary.LittleEndian.PutUint32(addr[:], uint32(dest))
+	binary.LittleEndian.PutUint32(maskBytes[:], uint32(mask))
+	bits, _ := net.IPMask(maskBytes[:]).Size()
+
+	return HostNetwork{Prefix: netip.PrefixFrom(netip.AddrFrom4(addr), bits), Interface: fields[0]}, true
+}
+
+// parseIPv6Route parses a /proc/net/ipv6_route line: destination, prefix
+// length, source, source prefix length, next hop, metric, refcount, use,
+// flags and interface
+func parseIPv6Route(fields []string) (HostNetwork, bool) {
+	if len(fields) < 10 {
+		return HostNetwork{}, false
+	}
+
+	dest, err := hex.DecodeString(fields[0])
+	if err != nil || len(dest) != 16 {
+		return HostNetwork{}, false
+	}
+	bits, err := strconv.ParseUint(fields[1], 16, 8)
+	if err != nil {
+		return HostNetwork{}, false
+	}
+
+	return HostNetwork{Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte(dest)), int(bits)), Interface: fields[9]}, true
+}
+
+// isCNIInterface reports whether an interface belongs to a network plugin
+func isCNIInterface(name string) bool {
+	if ciliumEndpointRe.MatchString(name) {
+		return true
+	}
+	for _, prefix := range cniInterfacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// kubeadmNetworking mirrors the networking section of kubeadm's
+// ClusterConfiguration
+type kubeadmNetworking struct {
+	Networking struct {
+		PodSubnet     string `json:"podSubnet"`
+		ServiceSubnet string `json:"serviceSubnet"`
+	} `json:"networking"`
+}
+
+// ClusterNetworks returns the pod and service CIDRs the cluster was
+// initialized with, from kubeadm's ClusterConfiguration
+func ClusterNetworks(ctx context.Context, client *kube.Client) (podCIDR, serviceCIDR string, err error) {
+	cm, err := client.Clientset.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(ctx, "kubeadm-config", metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read the kubeadm-config ConfigMap: %v", err)
+	}
+
+	var clusterConfig kubeadmNetworking
+	if err := yaml.Unmarshal([]byte(cm.Data["ClusterConfiguration"]), &clusterConfig); err != nil {
+		return "", "", fmt.Errorf("failed to parse the kubeadm ClusterConfiguration: %v", err)
+	}
+	if clusterConfig.Networking.PodSubnet == "" {
+		return "", "", fmt.Errorf("the kubeadm ClusterConfiguration has no pod subnet")
+	}
+
+	return clusterConfig.Networking.PodSubnet, clusterConfig.Networking.ServiceSubnet, nil
+}
+
+// CheckClusterPodCIDR checks that the pod CIDRs match the ones the cluster
+// was initialized with and contain the subnet of every node, for plugins
+// that take over the existing pod network
+func CheckClusterPodCIDR(ctx context.Context, client *kube.Client, podCIDR string) error {
+	prefixes, err := ParseCIDRs(podCIDR)
+	if err != nil {
+		return err
+	}
+
+	// Clusters not set up by kubeadm have no kubeadm-config, the node
+	// subnets below still have to fit
+	if clusterCIDR, _, err := ClusterNetworks(ctx, client); err == nil {
+		clusterPrefixes, err := ParseCIDRs(clusterCIDR)
+		if err != nil {
+			return fmt.Errorf("invalid pod subnet in the kubeadm ClusterConfiguration: %v", err)
+		}
+		if len(clusterPrefixes) != len(prefixes) {
+			return fmt.Errorf("pod CIDR %s does not match the cluster's %s", podCIDR, clusterCIDR)
+		}
+		for _, prefix := range clusterPrefixes {
+			if !slices.Contains(prefixes, prefix) {
+				return fmt.Errorf("pod CIDR %s does not match the cluster's %s", podCIDR, clusterCIDR)
+			}
+		}
+	}
+
+	nodes, err := client.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+	for _, node := range nodes.Items {
+		if len(node.Spec.PodCIDRs) == 0 {
+			return fmt.Errorf("node %s has no pod CIDR", node.Name)
+		}
+		for _, cidr := range node.Spec.PodCIDRs {
+			nodePrefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return fmt.Errorf("node %s has invalid pod CIDR %q: %v", node.Name, cidr, err)
+			}
+			if !slices.ContainsFunc(prefixes, func(prefix netip.Prefix) bool {
+				return prefix.Bits() <= nodePrefix.Bits() && prefix.Contains(nodePrefix.Addr())
+			}) {
+				return fmt.Errorf("pod CIDR %s of node %s is outside %s", cidr, node.Name, podCIDR)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package network
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestValidateClusterCIDRs(t *testing.T) {
+	tests := []struct {
+		name        string
+		plugin      Plugin
+		podCIDR     string
+		serviceCIDR string
+		wantErr     string // Substring of the error, empty for none
+	}{
+		{"ipv4", Calico, "10.244.0.0/16", "10.96.0.0/12", ""},
+		{"ipv6", Calico, "fd00:10:244::/56", "fd00:10:96::/112", ""},
+		{"dual-stack", Calico, "10.244.0.0/16,fd00:10:244::/56", "10.96.0.0/12,fd00:10:96::/112", ""},
+		{"dual-stack ipv6 first", Cilium, "fd00:10:244::/56,10.244.0.0/16", "fd00:10:96::/112,10.96.0.0/12", ""},
+
+		{"pod overlaps service", Calico, "10.96.0.0/16", "10.96.0.0/12", "pod CIDR 10.96.0.0/16 overlaps service CIDR 10.96.0.0/12"},
+		{"service inside pod", Calico, "10.0.0.0/8", "10.96.0.0/12", "pod CIDR 10.0.0.0/8 overlaps service CIDR 10.96.0.0/12"},
+		{"ipv6 overlap in dual-stack", Calico, "10.244.0.0/16,fd00::/56", "10.96.0.0/12,fd00::/112", "pod CIDR fd00::/56 overlaps service CIDR fd00::/112"},
+
+		{"dual-stack pods, ipv4 services", Calico, "10.244.0.0/16,fd00:10:244::/56", "10.96.0.0/12", "are dualstack but service CIDRs 10.96.0.0/12 are ipv4"},
+		{"ipv4 pods, dual-stack services", Calico, "10.244.0.0/16", "10.96.0.0/12,fd00:10:96::/112", "are ipv4 but service CIDRs"},
+		{"trailing comma", Calico, "10.244.0.0/16,", "10.96.0.0/12", `invalid CIDR ""`},
+
+		{"ipv4 pods, ipv6 services", Calico, "10.244.0.0/16", "fd00:10:96::/112", "are ipv4 but service CIDRs fd00:10:96::/112 are ipv6"},
+		{"two ipv4 pod CIDRs", Calico, "10.244.0.0/16,10.245.0.0/16", "10.96.0.0/12", "dual-stack needs one IPv4 and one IPv6 CIDR"},
+		{"two ipv6 service CIDRs", Calico, "fd00:10:244::/56", "fd00:10:96::/112,fd00:10:97::/112", "dual-stack needs one IPv4 and one IPv6 CIDR"},
+		{"three pod CIDRs", Calico, "10.244.0.0/16,fd00:10:244::/56,10.245.0.0/16", "10.96.0.0/12", "at most one IPv4 and one IPv6 CIDR"},
+
+		{"host bits", Calico, "10.244.0.1/16", "10.96.0.0/12", "host bits are set, did you mean 10.244.0.0/16?"},
+		{"service CIDR too large", Calico, "10.244.0.0/16", "10.0.0.0/8", "service CIDR 10.0.0.0/8 is larger than the /12"},
+		{"ipv6 service CIDR too large", Calico, "fd00:10:244::/56", "fd00:10:96::/64", "service CIDR fd00:10:96::/64 is larger than the /108"},
+		{"pod CIDR smaller than a node subnet", Flannel, "10.244.0.0/25", "10.96.0.0/12", "smaller than the /24 each node is allocated"},
+		{"unsupported stack mode", Weave, "10.32.0.0/12,fd00:10:32::/56", "10.96.0.0/12,fd00:10:96::/112", "weave does not support dualstack clusters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Plugin = tt.plugin
+			config.PodCIDR = tt.podCIDR
+
+			err := ValidateClusterCIDRs(config, tt.serviceCIDR)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("ValidateClusterCIDRs() = %v, want no error", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("ValidateClusterCIDRs() accepted pods %s and services %s", tt.podCIDR, tt.serviceCIDR)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("ValidateClusterCIDRs() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckHostNetworks(t *testing.T) {
+	hostNetworks := []HostNetwork{
+		{Prefix: netip.MustParsePrefix("192.168.1.0/24"), Interface: "eth0"},
+		{Prefix: netip.MustParsePrefix("10.20.0.0/16"), Interface: "eth1"},
+		{Prefix: netip.MustParsePrefix("fd00:1::/64"), Interface: "eth0"},
+		{Prefix: netip.MustParsePrefix("10.0.3.0/24"), Interface: "lxcbr0"},
+	}
+
+	tests := []struct {
+		name        string
+		podCIDR     string
+		serviceCIDR string
+		wantErr     string
+	}{
+		{"no conflict", "10.244.0.0/16", "10.96.0.0/12", ""},
+		{"no conflict dual-stack", "10.244.0.0/16,fd00:10:244::/56", "10.96.0.0/12,fd00:10:96::/112", ""},
+		{"pod CIDR covers a host network", "10.0.0.0/11", "10.96.0.0/12", "pod CIDR 10.0.0.0/11 overlaps 10.20.0.0/16 on eth1"},
+		{"pod CIDR covers an LXC bridge", "10.0.0.0/16", "10.96.0.0/12", "pod CIDR 10.0.0.0/16 overlaps 10.0.3.0/24 on lxcbr0"},
+		{"service CIDR inside a host network", "10.244.0.0/16", "192.168.1.0/28", "service CIDR 192.168.1.0/28 overlaps 192.168.1.0/24 on eth0"},
+		{"ipv6 service CIDR inside a host network", "10.244.0.0/16,fd00:10:244::/56", "10.96.0.0/12,fd00:1::/112", "service CIDR fd00:1::/112 overlaps fd00:1::/64 on eth0"},
+		{"every conflict reported", "10.20.0.0/16", "192.168.1.0/28", "service CIDR 192.168.1.0/28 overlaps 192.168.1.0/24 on eth0; pod CIDR 10.20.0.0/16 overlaps 10.20.0.0/16 on eth1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.PodCIDR = tt.podCIDR
+
+			err := checkHostNetworks(config, tt.serviceCIDR, hostNetworks)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("checkHostNetworks() = %v, want no error", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("checkHostNetworks() found no conflict for pods %s and services %s", tt.podCIDR, tt.serviceCIDR)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("checkHostNetworks() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	v4, ok := parseIPv4Route(strings.Fields("eth1\t0000140A\t00000000\t0001\t0\t0\t100\t0000FFFF\t0\t0\t0"))
+	if !ok || v4.Prefix != netip.MustParsePrefix("10.20.0.0/16") || v4.Interface != "eth1" {
+		t.Errorf("parseIPv4Route() = %v, %v, want 10.20.0.0/16 on eth1", v4, ok)
+	}
+	if _, ok := parseIPv4Route(strings.Fields("Iface\tDestination\tGateway\tFlags\tRefCnt\tUse\tMetric\tMask\tMTU\tWindow\tIRTT")); ok {
+		t.Error("parseIPv4Route() accepted the header line")
+	}
+
+	v6, ok := parseIPv6Route(strings.Fields("fd000001000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001 eth0"))
+	if !ok || v6.Prefix != netip.MustParsePrefix("fd00:1::/64") || v6.Interface != "eth0" {
+		t.Errorf("parseIPv6Route() = %v, %v, want fd00:1::/64 on eth0", v6, ok)
+	}
+}
+
+func TestIsCNIInterface(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"cali1234567890a", true},
+		{"tunl0", true},
+		{"flannel.1", true},
+		{"cni0", true},
+		{"cilium_host", true},
+		{"lxc1a2b3c4d5e6f", true},
+		{"lxc_health", true},
+		{"lxcbr0", false}, // LXC's host bridge
+		{"lxcbr1", false},
+		{"lxc", false},
+		{"eth0", false},
+		{"virbr0", false},
+	}
+
+	for _, tt := range tests {
+		if got := isCNIInterface(tt.name); got != tt.want {
+			t.Errorf("isCNIInterface(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
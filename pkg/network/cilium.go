@@ -0,0 +1,301 @@
+package network
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	helmkube "helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"helm.sh/helm/v3/pkg/strvals"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+)
+
+// ciliumChartFS holds the Cilium Helm chart, copied unchanged from
+// install/kubernetes/cilium in the Cilium release
+//
+//go:embed all:charts/cilium
+var ciliumChartFS embed.FS
+
+// CiliumVersion is the version of the embedded Cilium chart
+const CiliumVersion = "1.17.3"
+
+// Cilium Helm release
+const (
+	ciliumRelease   = "cilium"
+	ciliumNamespace = "kube-system"
+	ciliumChartDir  = "charts/cilium"
+)
+
+// Cilium routing modes
+const (
+	RoutingTunnel = "tunnel" // Encapsulate pod traffic, see TunnelProtocol
+	RoutingNative = "native" // Route pod CIDRs directly between nodes on one L2 network
+)
+
+// Cilium IPAM modes
+const (
+	IPAMClusterPool = "cluster-pool" // Cilium's operator hands out node CIDRs from PodCIDR
+	IPAMKubernetes  = "kubernetes"   // Use the node CIDRs allocated by the controller manager
+)
+
+// ValidateCilium checks the Cilium routing, IPAM and datapath options
+func ValidateCilium(config *Config) error {
+	switch config.RoutingMode {
+	case RoutingTunnel:
+		switch config.TunnelProtocol {
+		case "", TunnelVXLAN, TunnelGeneve:
+		default:
+			return fmt.Errorf("invalid Cilium tunnel protocol %q: must be %s or %s", config.TunnelProtocol, TunnelVXLAN, TunnelGeneve)
+		}
+	case RoutingNative:
+	default:
+		return fmt.Errorf("invalid Cilium routing mode %q: must be %s or %s", config.RoutingMode, RoutingTunnel, RoutingNative)
+	}
+
+	switch config.IPAMMode {
+	case IPAMClusterPool, IPAMKubernetes:
+	default:
+		return fmt.Errorf("invalid Cilium IPAM mode %q: must be %s or %s", config.IPAMMode, IPAMClusterPool, IPAMKubernetes)
+	}
+
+	// eBPF masquerading is implemented on top of the kube-proxy replacement
+	if config.EnableeBPF && !config.KubeProxyReplacement {
+		return fmt.Errorf("eBPF masquerading requires the Cilium kube-proxy replacement")
+	}
+
+	if config.EnableHubbleUI && !config.EnableHubble {
+		return fmt.Errorf("the Hubble UI requires Hubble")
+	}
+
+	return nil
+}
+
+// installCilium installs Cilium from the embedded chart, or upgrades the
+// release if it is already there
+func installCilium(config *Config, log *logger.Logger) error {
+	log.Info("Installing Cilium network plugin...")
+
+	if err := ValidatePodCIDR(config); err != nil {
+		return err
+	}
+
+	if err := ValidateCilium(config); err != nil {
+		return err
+	}
+
+	ciliumChart, err := loadCiliumChart()
+	if err != nil {
+		return err
+	}
+
+	values, err := ciliumValues(config)
+	if err != nil {
+		return err
+	}
+
+	actionConfig := new(action.Configuration)
+	getter := helmkube.GetConfig(kube.KubeconfigPath(), "", ciliumNamespace)
+	if err := actionConfig.Init(getter, ciliumNamespace, "secret", func(string, ...interface{}) {}); err != nil {
+		return fmt.Errorf("failed to initialize Helm: %v", err)
+	}
+
+	// helm upgrade --install: install only if there is no release yet
+	history := action.NewHistory(actionConfig)
+	history.Max = 1
+	_, err = history.Run(ciliumRelease)
+	switch {
+	case errors.Is(err, driver.ErrReleaseNotFound):
+		log.Info("Installing Cilium %s chart...", CiliumVersion)
+		install := action.NewInstall(actionConfig)
+		install.ReleaseName = ciliumRelease
+		install.Namespace = ciliumNamespace
+		if _, err := install.Run(ciliumChart, values); err != nil {
+			return fmt.Errorf("failed to install Cilium: %v", err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to read Cilium release history: %v", err)
+	default:
+		log.Info("Upgrading Cilium release to chart %s...", CiliumVersion)
+		upgrade := action.NewUpgrade(actionConfig)
+		upgrade.Namespace = ciliumNamespace
+		upgrade.ResetValues = true
+		if _, err := upgrade.Run(ciliumRelease, ciliumChart, values); err != nil {
+			return fmt.Errorf("failed to upgrade Cilium: %v", err)
+		}
+	}
+
+	// Wait for Cilium pods to be ready
+	log.Info("Waiting for Cilium pods to be ready...")
+	if err := waitForPlugin(config, log); err != nil {
+		return fmt.Errorf("cilium is not ready: %v", err)
+	}
+
+	log.Info("Cilium network plugin successfully installed!")
+	return nil
+}
+
+// loadCiliumChart loads the embedded chart and checks its version
+func loadCiliumChart() (*chart.Chart, error) {
+	var files []*loader.BufferedFile
+	err := fs.WalkDir(ciliumChartFS, ciliumChartDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := ciliumChartFS.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, &loader.BufferedFile{
+			Name: strings.TrimPrefix(path, ciliumChartDir+"/"),
+			Data: data,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Cilium chart: %v", err)
+	}
+
+	ciliumChart, err := loader.LoadFiles(files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the Cilium chart: %v", err)
+	}
+	if ciliumChart.Metadata.Version != CiliumVersion {
+		return nil, fmt.Errorf("embedded Cilium chart is version %s, expected %s", ciliumChart.Metadata.Version, CiliumVersion)
+	}
+
+	return ciliumChart, nil
+}
+
+// ciliumValues builds the chart values for the configuration. CustomValues
+// and the migration settings use helm --set syntax and are applied last.
+func ciliumValues(config *Config) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	set := func(key string, value interface{}) {
+		setValue(values, key, value)
+	}
+
+	set("ipam.mode", config.IPAMMode)
+	if cidr := config.PodCIDRv4(); cidr != "" {
+		if config.IPAMMode == IPAMClusterPool {
+			set("ipam.operator.clusterPoolIPv4PodCIDRList", []interface{}{cidr})
+		}
+	} else {
+		set("ipv4.enabled", false)
+	}
+	if cidr := config.PodCIDRv6(); cidr != "" {
+		set("ipv6.enabled", true)
+		if config.IPAMMode == IPAMClusterPool {
+			set("ipam.operator.clusterPoolIPv6PodCIDRList", []interface{}{cidr})
+		}
+	}
+
+	set("routingMode", config.RoutingMode)
+	if config.RoutingMode == RoutingNative {
+		// Nodes share an L2 network and install routes to each other's pod CIDRs
+		set("autoDirectNodeRoutes", true)
+		if cidr := config.PodCIDRv4(); cidr != "" {
+			set("ipv4NativeRoutingCIDR", cidr)
+		}
+		if cidr := config.PodCIDRv6(); cidr != "" {
+			set("ipv6NativeRoutingCIDR", cidr)
+		}
+	} else {
+		if config.TunnelProtocol != "" {
+			set("tunnelProtocol", config.TunnelProtocol)
+		}
+		if config.TunnelPort > 0 {
+			set("tunnelPort", config.TunnelPort)
+		}
+	}
+
+	if config.MTU > 0 {
+		set("mtu", config.MTU)
+	}
+
+	// Without kube-proxy the agent can't reach the API server through its
+	// service address and needs the real one
+	set("kubeProxyReplacement", config.KubeProxyReplacement)
+	if config.KubeProxyReplacement {
+		host, port, err := apiServerAddress()
+		if err != nil {
+			return nil, err
+		}
+		set("k8sServiceHost", host)
+		set("k8sServicePort", port)
+	}
+
+	if config.EnableeBPF {
+		set("bpf.masquerade", true)
+	}
+
+	set("hubble.enabled", config.EnableHubble)
+	if config.EnableHubble {
+		set("hubble.relay.enabled", true)
+		set("hubble.ui.enabled", config.EnableHubbleUI)
+	}
+
+	if config.EnableEncryption {
+		set("encryption.enabled", true)
+		set("encryption.type", "wireguard")
+	}
+
+	// Run next to the old plugin without taking over pod networking
+	if config.migrating {
+		for _, value := range ciliumMigrationValues {
+			if err := strvals.ParseInto(value, values); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for key, value := range config.CustomValues {
+		if err := strvals.ParseInto(key+"="+value, values); err != nil {
+			return nil, fmt.Errorf("invalid Cilium value %s=%s: %v", key, value, err)
+		}
+	}
+
+	return values, nil
+}
+
+// setValue sets a dotted key in nested chart values
+func setValue(values map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := values[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			values[part] = next
+		}
+		values = next
+	}
+	values[parts[len(parts)-1]] = value
+}
+
+// apiServerAddress returns the host and port of the API server in the
+// admin kubeconfig, which is the control plane endpoint in HA clusters
+func apiServerAddress() (string, string, error) {
+	client, err := kube.New()
+	if err != nil {
+		return "", "", err
+	}
+
+	server, err := url.Parse(client.Host())
+	if err != nil || server.Host == "" {
+		return "", "", fmt.Errorf("invalid API server address %q", client.Host())
+	}
+
+	port := server.Port()
+	if port == "" {
+		port = "443"
+	}
+	return server.Hostname(), port, nil
+}
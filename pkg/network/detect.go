@@ -0,0 +1,317 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/internal/wait"
+)
+
+// None means no network plugin is installed
+const None Plugin = "none"
+
+// CNIConfigDir is where the kubelet's container runtime looks for CNI
+// network configuration
+const CNIConfigDir = "/etc/cni/net.d"
+
+// PluginStatus describes an installed network plugin
+type PluginStatus struct {
+	Plugin     Plugin
+	Version    string   // Image tag of the plugin's DaemonSet, empty if unknown
+	Healthy    bool     // The DaemonSet is fully rolled out and ready
+	Reason     string   // Why the plugin is not healthy
+	DaemonSet  string   // namespace/name of the plugin's DaemonSet, empty if missing
+	CRDs       []string // The plugin's CustomResourceDefinitions found in the cluster
+	CNIConfigs []string // The plugin's files in CNIConfigDir on this node
+}
+
+func (s *PluginStatus) String() string {
+	if s.Plugin == None {
+		return string(None)
+	}
+
+	health := "healthy"
+	if !s.Healthy {
+		health = "unhealthy: " + s.Reason
+	}
+	if s.Version == "" {
+		return fmt.Sprintf("%s (%s)", s.Plugin, health)
+	}
+	return fmt.Sprintf("%s %s (%s)", s.Plugin, s.Version, health)
+}
+
+// pluginSignature lists what a plugin leaves in the cluster and on disk
+type pluginSignature struct {
+	plugin      Plugin
+	daemonSets  []workload // Current location first, then older manifests
+	podSelector string     // Labels of the DaemonSet's pods
+	crds        []string
+	cniConfig   string   // Substring of its file names in CNIConfigDir
+	links       []string // Network interfaces it creates on each node
+	dirs        []string // State directories on each node
+	chains      string   // Regexp matching its iptables chains and rules
+}
+
+// pluginSignatures is checked in order; a plugin counts as installed if any
+// of its DaemonSets, CRDs or CNI config files is present. Calico and Canal
+// share CRDs, see DetectPlugins.
+var pluginSignatures = []pluginSignature{
+	{
+		plugin: Calico,
+		daemonSets: []workload{
+			{true, "calico-system", "calico-node"},
+			{true, "kube-system", "calico-node"},
+		},
+		podSelector: "k8s-app=calico-node",
+		crds:        []string{"installations.operator.tigera.io", "ippools.crd.projectcalico.org"},
+		cniConfig:   "calico",
+		links:       []string{"tunl0", "vxlan.calico", "vxlan-v6.calico", "wireguard.cali", "wg-v6.cali"},
+		dirs:        []string{"/var/lib/calico", "/var/run/calico"},
+		chains:      "cali[-:]",
+	},
+	{
+		plugin: Flannel,
+		daemonSets: []workload{
+			{true, "kube-flannel", "kube-flannel-ds"},
+			{true, "kube-system", "kube-flannel-ds"},
+		},
+		podSelector: "app=flannel",
+		cniConfig:   "flannel",
+		links:       []string{"flannel.1", "flannel-v6.1", "flannel-wg", "flannel-wg-v6", "cni0"},
+		dirs:        []string{"/run/flannel"},
+		chains:      "FLANNEL",
+	},
+	{
+		plugin:      Weave,
+		daemonSets:  []workload{{true, "kube-system", "weave-net"}},
+		podSelector: "name=weave-net",
+		cniConfig:   "weave",
+		links:       []string{"weave", "datapath", "vethwe-datapath", "vethwe-bridge", "vxlan-6784"},
+		dirs:        []string{"/var/lib/weave"},
+		chains:      "WEAVE",
+	},
+	{
+		plugin:      Cilium,
+		daemonSets:  []workload{{true, "kube-system", "cilium"}},
+		podSelector: "k8s-app=cilium",
+		crds:        []string{"ciliumnodes.cilium.io", "ciliumendpoints.cilium.io"},
+		cniConfig:   "cilium",
+		links:       []string{"cilium_host", "cilium_net", "cilium_vxlan", "cilium_geneve", "cilium_wg0"},
+		dirs:        []string{"/var/run/cilium"},
+		chains:      "CILIUM",
+	},
+	{
+		plugin:      Canal,
+		daemonSets:  []workload{{true, "kube-system", "canal"}},
+		podSelector: "k8s-app=canal",
+		crds:        []string{"ippools.crd.projectcalico.org"},
+		cniConfig:   "canal",
+		links:       []string{"flannel.1"},
+		dirs:        []string{"/var/lib/calico", "/var/run/calico", "/run/flannel"},
+		chains:      "cali[-:]|FLANNEL",
+	},
+}
+
+var crdResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// DetectPlugins returns every network plugin with a DaemonSet, CRD or CNI
+// config file present, in pluginSignatures order. It returns an empty
+// slice when none is installed.
+func DetectPlugins(ctx context.Context, client *kube.Client) ([]*PluginStatus, error) {
+	return detectPlugins(ctx, client, CNIConfigDir)
+}
+
+// detectPlugins is DetectPlugins with the CNI config files read from cniDir
+func detectPlugins(ctx context.Context, client *kube.Client, cniDir string) ([]*PluginStatus, error) {
+	cniFiles, err := cniConfigFiles(cniDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*PluginStatus
+	for _, sig := range pluginSignatures {
+		status := &PluginStatus{Plugin: sig.plugin}
+
+		ds, err := findDaemonSet(ctx, client, sig.daemonSets)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range sig.crds {
+			_, err := client.Dynamic.Resource(crdResource).Get(ctx, name, metav1.GetOptions{})
+			if err == nil {
+				status.CRDs = append(status.CRDs, name)
+			} else if !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to look up CRD %s: %v", name, err)
+			}
+		}
+
+		for _, file := range cniFiles {
+			if strings.Contains(file, sig.cniConfig) {
+				status.CNIConfigs = append(status.CNIConfigs, filepath.Join(cniDir, file))
+			}
+		}
+
+		if ds == nil && len(status.CRDs) == 0 && len(status.CNIConfigs) == 0 {
+			continue
+		}
+
+		switch {
+		case ds == nil:
+			status.Reason = "no DaemonSet, only leftover CRDs or CNI config"
+		case !wait.DaemonSetReady(ds):
+			status.Reason = fmt.Sprintf("%d/%d pods ready", ds.Status.NumberReady, ds.Status.DesiredNumberScheduled)
+		case len(status.CNIConfigs) == 0:
+			status.Reason = "no CNI config in " + cniDir + " on this node"
+		default:
+			status.Healthy = true
+		}
+		if ds != nil {
+			status.DaemonSet = ds.Namespace + "/" + ds.Name
+			if len(ds.Spec.Template.Spec.Containers) > 0 {
+				status.Version = imageTag(ds.Spec.Template.Spec.Containers[0].Image)
+			}
+		}
+
+		found = append(found, status)
+	}
+
+	// Drop plugins seen only through CRDs that belong to another plugin
+	// that is running, such as Calico's when Canal is installed
+	running := make(map[string]bool)
+	for _, status := range found {
+		if status.DaemonSet != "" {
+			for _, crd := range status.CRDs {
+				running[crd] = true
+			}
+		}
+	}
+	plugins := found[:0]
+	for _, status := range found {
+		if status.DaemonSet == "" && len(status.CNIConfigs) == 0 && allIn(status.CRDs, running) {
+			continue
+		}
+		plugins = append(plugins, status)
+	}
+
+	return plugins, nil
+}
+
+// allIn reports whether every name is in set
+func allIn(names []string, set map[string]bool) bool {
+	for _, name := range names {
+		if !set[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetCurrentPlugin detects the installed network plugin. If several are
+// present, the first one with a DaemonSet wins. A cluster without a
+// plugin is reported with Plugin set to None.
+func GetCurrentPlugin(log *logger.Logger) (*PluginStatus, error) {
+	log.Info("Detecting current network plugin...")
+
+	client, err := kube.New()
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := DetectPlugins(context.Background(), client)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return &PluginStatus{Plugin: None}, nil
+	}
+
+	for _, status := range found {
+		if status.DaemonSet != "" {
+			return status, nil
+		}
+	}
+	return found[0], nil
+}
+
+// GetCalicoVersion returns the installed Calico version
+func GetCalicoVersion(log *logger.Logger) (string, error) {
+	status, err := GetCurrentPlugin(log)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Calico version: %v", err)
+	}
+	if status.Plugin != Calico || status.Version == "" {
+		return "", fmt.Errorf("failed to get Calico version: no calico-node DaemonSet found")
+	}
+
+	return status.Version, nil
+}
+
+// signatureOf returns the signature of a plugin
+func signatureOf(plugin Plugin) pluginSignature {
+	for _, sig := range pluginSignatures {
+		if sig.plugin == plugin {
+			return sig
+		}
+	}
+	return pluginSignature{plugin: plugin}
+}
+
+// findDaemonSet returns the first of the DaemonSets that exists, or nil
+func findDaemonSet(ctx context.Context, client *kube.Client, candidates []workload) (*appsv1.DaemonSet, error) {
+	for _, w := range candidates {
+		ds, err := client.Clientset.AppsV1().DaemonSets(w.namespace).Get(ctx, w.name, metav1.GetOptions{})
+		if err == nil {
+			return ds, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get DaemonSet %s/%s: %v", w.namespace, w.name, err)
+		}
+	}
+	return nil, nil
+}
+
+// cniConfigFiles lists the network configs in dir
+func cniConfigFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", dir, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".conf", ".conflist", ".json":
+			files = append(files, entry.Name())
+		}
+	}
+	return files, nil
+}
+
+// imageTag returns the tag of a container image reference, without any
+// digest
+func imageTag(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	name := image[strings.LastIndex(image, "/")+1:]
+	if _, tag, ok := strings.Cut(name, ":"); ok {
+		return tag
+	}
+	return ""
+}
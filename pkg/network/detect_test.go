@@ -0,0 +1,190 @@
+package network
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+)
+
+// testDaemonSet returns a plugin DaemonSet with ready of its 3 pods ready
+func testDaemonSet(namespace, name, image string, ready int32) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Generation: 1},
+		Spec: appsv1.DaemonSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: name, Image: image}}},
+			},
+		},
+		Status: appsv1.DaemonSetStatus{
+			ObservedGeneration:     1,
+			DesiredNumberScheduled: 3,
+			UpdatedNumberScheduled: 3,
+			NumberReady:            ready,
+		},
+	}
+}
+
+// testCRD returns a CustomResourceDefinition for the fake dynamic client
+func testCRD(name string) runtime.Object {
+	crd := &unstructured.Unstructured{}
+	crd.SetAPIVersion("apiextensions.k8s.io/v1")
+	crd.SetKind("CustomResourceDefinition")
+	crd.SetName(name)
+	return crd
+}
+
+func TestDetectPlugins(t *testing.T) {
+	tests := []struct {
+		name       string
+		daemonSets []runtime.Object
+		crds       []string
+		cniFiles   []string
+		want       []PluginStatus
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:       "calico",
+			daemonSets: []runtime.Object{testDaemonSet("calico-system", "calico-node", "docker.io/calico/node:v3.29.1", 3)},
+			crds:       []string{"installations.operator.tigera.io", "ippools.crd.projectcalico.org"},
+			cniFiles:   []string{"10-calico.conflist", "calico-kubeconfig"},
+			want: []PluginStatus{{
+				Plugin:     Calico,
+				Version:    "v3.29.1",
+				Healthy:    true,
+				DaemonSet:  "calico-system/calico-node",
+				CRDs:       []string{"installations.operator.tigera.io", "ippools.crd.projectcalico.org"},
+				CNIConfigs: []string{"10-calico.conflist"},
+			}},
+		},
+		{
+			name:       "canal owns the shared Calico CRD",
+			daemonSets: []runtime.Object{testDaemonSet("kube-system", "canal", "docker.io/calico/node:v3.29.1", 3)},
+			crds:       []string{"ippools.crd.projectcalico.org"},
+			cniFiles:   []string{"10-canal.conflist"},
+			want: []PluginStatus{{
+				Plugin:     Canal,
+				Version:    "v3.29.1",
+				Healthy:    true,
+				DaemonSet:  "kube-system/canal",
+				CRDs:       []string{"ippools.crd.projectcalico.org"},
+				CNIConfigs: []string{"10-canal.conflist"},
+			}},
+		},
+		{
+			name:       "unready calico in kube-system",
+			daemonSets: []runtime.Object{testDaemonSet("kube-system", "calico-node", "quay.io/calico/node:v3.26.4", 1)},
+			crds:       []string{"ippools.crd.projectcalico.org"},
+			cniFiles:   []string{"10-calico.conflist"},
+			want: []PluginStatus{{
+				Plugin:     Calico,
+				Version:    "v3.26.4",
+				Reason:     "1/3 pods ready",
+				DaemonSet:  "kube-system/calico-node",
+				CRDs:       []string{"ippools.crd.projectcalico.org"},
+				CNIConfigs: []string{"10-calico.conflist"},
+			}},
+		},
+		{
+			name: "leftover cilium CRDs without a DaemonSet",
+			crds: []string{"ciliumnodes.cilium.io", "ciliumendpoints.cilium.io"},
+			want: []PluginStatus{{
+				Plugin: Cilium,
+				Reason: "no DaemonSet, only leftover CRDs or CNI config",
+				CRDs:   []string{"ciliumnodes.cilium.io", "ciliumendpoints.cilium.io"},
+			}},
+		},
+		{
+			name:       "flannel next to leftover cilium CRDs",
+			daemonSets: []runtime.Object{testDaemonSet("kube-flannel", "kube-flannel-ds", "ghcr.io/flannel-io/flannel:v0.26.1", 3)},
+			crds:       []string{"ciliumnodes.cilium.io"},
+			cniFiles:   []string{"10-flannel.conflist"},
+			want: []PluginStatus{
+				{
+					Plugin:     Flannel,
+					Version:    "v0.26.1",
+					Healthy:    true,
+					DaemonSet:  "kube-flannel/kube-flannel-ds",
+					CNIConfigs: []string{"10-flannel.conflist"},
+				},
+				{
+					Plugin: Cilium,
+					Reason: "no DaemonSet, only leftover CRDs or CNI config",
+					CRDs:   []string{"ciliumnodes.cilium.io"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cniDir := t.TempDir()
+			for _, file := range tt.cniFiles {
+				if err := os.WriteFile(filepath.Join(cniDir, file), []byte("{}"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var crds []runtime.Object
+			for _, name := range tt.crds {
+				crds = append(crds, testCRD(name))
+			}
+			dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{crdResource: "CustomResourceDefinitionList"}, crds...)
+			client := kube.NewForClients(fake.NewSimpleClientset(tt.daemonSets...), dynamicClient, nil)
+
+			found, err := detectPlugins(context.Background(), client, cniDir)
+			if err != nil {
+				t.Fatalf("detectPlugins() error = %v", err)
+			}
+
+			var got []PluginStatus
+			for _, status := range found {
+				for i, file := range status.CNIConfigs {
+					status.CNIConfigs[i] = filepath.Base(file)
+				}
+				got = append(got, *status)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("detectPlugins() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageTag(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"calico/node:v3.29.1", "v3.29.1"},
+		{"docker.io/calico/node:v3.29.1", "v3.29.1"},
+		{"registry.example.com:5000/calico/node:v3.29.1", "v3.29.1"},
+		{"registry.example.com:5000/calico/node", ""},
+		{"quay.io/cilium/cilium:v1.16.5@sha256:758ca0793f5995bb938a2fa219dcce63dc0b3fa7345f3ee8bc1b9ef1ac1a8dcc", "v1.16.5"},
+		{"quay.io/cilium/cilium@sha256:758ca0793f5995bb938a2fa219dcce63dc0b3fa7345f3ee8bc1b9ef1ac1a8dcc", ""},
+		{"localhost:5000/flannel@sha256:758ca0793f5995bb938a2fa219dcce63dc0b3fa7345f3ee8bc1b9ef1ac1a8dcc", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := imageTag(tt.image); got != tt.want {
+				t.Errorf("imageTag(%q) = %q, want %q", tt.image, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/internal/wait"
+)
+
+// Diagnostic checks
+const (
+	CheckPodToPod     = "pod-to-pod"     // Each test pod to every other test pod
+	CheckPodToService = "pod-to-service" // Each test pod to the test Service's ClusterIP
+	CheckDNS          = "dns"            // Each test pod resolves the test Service through CoreDNS
+	CheckEgress       = "egress"         // Each test pod to DiagnoseOptions.EgressURL
+	CheckNodePort     = "nodeport"       // Each node's host network to the NodePort on every node
+	CheckHostToPod    = "host-to-pod"    // Each node's host network to the test pod on every node
+	CheckTestPod      = "test-pod"       // A test pod is ready on the node, in the pod and host network
+)
+
+// DefaultEgressURL answers plain HTTP requests without redirecting
+const DefaultEgressURL = "http://connectivity-check.ubuntu.com/"
+
+const (
+	diagnoseApp     = "kubeforge-netcheck"
+	diagnosePort    = 8080
+	diagnoseTimeout = 3 * time.Minute  // Setting up the test pods
+	testPodsTimeout = 2 * time.Minute  // Waiting for a test pod on every node
+	probeTimeout    = 5                // seconds, per request
+	probeAllowance  = 15 * time.Second // Per probe, with the exec round trip
+	probeWorkers    = 16
+)
+
+// DiagnoseOptions selects what Diagnose checks
+type DiagnoseOptions struct {
+	EgressURL string // Empty skips the egress check
+}
+
+// CheckResult is the outcome of one check between a source and a target
+type CheckResult struct {
+	Check   string `json:"check"`
+	From    string `json:"from"`    // Source node
+	To      string `json:"to"`      // Target node, or the Service or URL
+	Address string `json:"address"` // Address that was tried
+	Passed  bool   `json:"passed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Diagnosis holds the results of every check Diagnose ran
+type Diagnosis struct {
+	Results []CheckResult `json:"results"`
+	Passed  int           `json:"passed"`
+	Failed  int           `json:"failed"`
+}
+
+// WriteTable writes the results as a table with one row per check
+func (d *Diagnosis) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tFROM\tTO\tADDRESS\tRESULT\tERROR")
+	for _, r := range d.Results {
+		result := "pass"
+		if !r.Passed {
+			result = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Check, r.From, r.To, r.Address, result, r.Error)
+	}
+	fmt.Fprintf(tw, "\n%d passed, %d failed\n", d.Passed, d.Failed)
+	return tw.Flush()
+}
+
+// probe is one command run in a test pod
+type probe struct {
+	check   string
+	pod     *corev1.Pod
+	to      string
+	address string
+	command []string
+	expect  string // Output must contain this, if set
+}
+
+// RunDiagnostics runs Diagnose against the cluster in the admin kubeconfig
+func RunDiagnostics(options DiagnoseOptions, log *logger.Logger) (*Diagnosis, error) {
+	client, err := kube.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return Diagnose(context.Background(), client, options, log)
+}
+
+// Diagnose runs a test pod on every node, in the pod network and in the
+// host network, and checks the paths between them, to a Service and out
+// of the cluster. Nodes whose test pods don't become ready in time are
+// reported as failed rows and left out of the other checks. Setting up
+// and probing have separate time limits, the latter growing with the
+// number of probes. Everything it creates lives in a temporary namespace
+// that is deleted afterwards.
+func Diagnose(ctx context.Context, client *kube.Client, options DiagnoseOptions, log *logger.Logger) (*Diagnosis, error) {
+	setupCtx, cancel := context.WithTimeout(ctx, diagnoseTimeout)
+	defer cancel()
+
+	namespace := fmt.Sprintf("kubeforge-netcheck-%d", time.Now().Unix())
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace,
+			// The host network pods are not allowed by the baseline policy
+			Labels: map[string]string{"pod-security.kubernetes.io/enforce": "privileged"},
+		},
+	}
+	if _, err := client.Clientset.CoreV1().Namespaces().Create(setupCtx, ns, metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to create diagnostics namespace: %v", err)
+	}
+	defer client.Clientset.CoreV1().Namespaces().Delete(context.WithoutCancel(ctx), namespace, metav1.DeleteOptions{})
+
+	log.Info("Starting network test pods on every node...")
+	// busybox httpd serves the file, so a successful request proves the
+	// connection reached the pod
+	server := diagnoseDaemonSet(diagnoseApp, false,
+		fmt.Sprintf("echo %s > /tmp/index.html && exec httpd -f -p %d -h /tmp", diagnoseApp, diagnosePort))
+	host := diagnoseDaemonSet(diagnoseApp+"-host", true, "exec sleep 3600")
+	for _, ds := range []*appsv1.DaemonSet{server, host} {
+		if _, err := client.Clientset.AppsV1().DaemonSets(namespace).Create(setupCtx, ds, metav1.CreateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to create DaemonSet %s: %v", ds.Name, err)
+		}
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: diagnoseApp},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeNodePort,
+			Selector: map[string]string{"app": diagnoseApp},
+			Ports: []corev1.ServicePort{{
+				Port:       80,
+				TargetPort: intstr.FromInt32(diagnosePort),
+			}},
+		},
+	}
+	service, err := client.Clientset.CoreV1().Services(namespace).Create(setupCtx, service, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Service %s: %v", diagnoseApp, err)
+	}
+
+	// A node that can't start its test pods is a finding, not a reason to
+	// skip the checks between the others
+	for _, ds := range []*appsv1.DaemonSet{server, host} {
+		waitCtx, cancel := context.WithTimeout(setupCtx, testPodsTimeout)
+		err := wait.DaemonSet(waitCtx, client, namespace, ds.Name, log)
+		cancel()
+
+		var timeoutErr *wait.TimeoutError
+		if errors.As(err, &timeoutErr) {
+			log.Warn("Some network test pods are not ready, checking the nodes that have one: %v", err)
+		} else if err != nil {
+			return nil, fmt.Errorf("network test pods are not ready: %v", err)
+		}
+	}
+
+	nodes, err := client.Clientset.CoreV1().Nodes().List(setupCtx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	serverPods, serverMissing, err := diagnosePods(setupCtx, client, namespace, server.Name, nodes.Items)
+	if err != nil {
+		return nil, err
+	}
+	hostPods, hostMissing, err := diagnosePods(setupCtx, client, namespace, host.Name, nodes.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	wget := func(address string) []string {
+		return []string{"wget", "-q", "-T", strconv.Itoa(probeTimeout), "-O", "-", address}
+	}
+	url := func(ip string, port int) string {
+		return "http://" + net.JoinHostPort(ip, strconv.Itoa(port)) + "/"
+	}
+
+	var probes []probe
+	for _, from := range serverPods {
+		for _, to := range serverPods {
+			if from.Name == to.Name {
+				continue
+			}
+			address := url(to.Status.PodIP, diagnosePort)
+			probes = append(probes, probe{CheckPodToPod, from, to.Spec.NodeName, address, wget(address), diagnoseApp})
+		}
+	}
+
+	serviceAddress := url(service.Spec.ClusterIP, 80)
+	serviceName := fmt.Sprintf("%s.%s.svc.cluster.local", service.Name, namespace)
+	for _, from := range serverPods {
+		probes = append(probes,
+			probe{CheckPodToService, from, "service/" + service.Name, serviceAddress, wget(serviceAddress), diagnoseApp},
+			probe{CheckDNS, from, "coredns", serviceName, []string{"nslookup", serviceName}, service.Spec.ClusterIP})
+		if options.EgressURL != "" {
+			probes = append(probes, probe{CheckEgress, from, "external", options.EgressURL, wget(options.EgressURL), ""})
+		}
+	}
+
+	nodePort := int(service.Spec.Ports[0].NodePort)
+	for _, from := range hostPods {
+		// A host network pod has its node's address
+		for _, to := range hostPods {
+			address := url(to.Status.PodIP, nodePort)
+			probes = append(probes, probe{CheckNodePort, from, to.Spec.NodeName, address, wget(address), diagnoseApp})
+		}
+		for _, to := range serverPods {
+			address := url(to.Status.PodIP, diagnosePort)
+			probes = append(probes, probe{CheckHostToPod, from, to.Spec.NodeName, address, wget(address), diagnoseApp})
+		}
+	}
+
+	log.Info("Running %d network checks across %d nodes...", len(probes), len(serverPods))
+	probeCtx, cancel := context.WithTimeout(ctx, time.Duration(len(probes)/probeWorkers+1)*probeAllowance)
+	defer cancel()
+
+	diagnosis := &Diagnosis{Results: append(serverMissing, hostMissing...)}
+	diagnosis.Results = append(diagnosis.Results, runProbes(probeCtx, client, namespace, probes)...)
+	for _, r := range diagnosis.Results {
+		if r.Passed {
+			diagnosis.Passed++
+		} else {
+			diagnosis.Failed++
+		}
+	}
+
+	return diagnosis, nil
+}
+
+// diagnoseDaemonSet returns a DaemonSet that runs a busybox command on
+// every node, control plane nodes included
+func diagnoseDaemonSet(name string, hostNetwork bool, command string) *appsv1.DaemonSet {
+	labels := map[string]string{"app": name}
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					HostNetwork:                   hostNetwork,
+					Tolerations:                   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+					TerminationGracePeriodSeconds: new(int64),
+					Containers: []corev1.Container{{
+						Name:    "netcheck",
+						Image:   "busybox:stable",
+						Command: []string{"sh", "-c", command},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// diagnosePods returns the ready pods of a test DaemonSet, sorted by
+// node, and a failed result for each node without one
+func diagnosePods(ctx context.Context, client *kube.Client, namespace, app string, nodes []corev1.Node) ([]*corev1.Pod, []CheckResult, error) {
+	list, err := client.Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + app})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list network test pods: %v", err)
+	}
+
+	byNode := make(map[string]*corev1.Pod)
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if pod.DeletionTimestamp == nil {
+			byNode[pod.Spec.NodeName] = pod
+		}
+	}
+
+	var pods []*corev1.Pod
+	var missing []CheckResult
+	for _, node := range nodes {
+		pod := byNode[node.Name]
+		switch {
+		case pod == nil:
+			missing = append(missing, CheckResult{Check: CheckTestPod, From: node.Name, To: app, Error: "no test pod on the node"})
+		case !wait.PodReady(pod) || pod.Status.PodIP == "":
+			problem := strings.Join(strings.Fields(wait.PodProblem(pod)), " ")
+			missing = append(missing, CheckResult{Check: CheckTestPod, From: node.Name, To: app, Error: problem})
+		default:
+			pods = append(pods, pod)
+		}
+	}
+
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Spec.NodeName < pods[j].Spec.NodeName })
+	return pods, missing, nil
+}
+
+// runProbes runs the probes a few at a time and returns their results in
+// the same order
+func runProbes(ctx context.Context, client *kube.Client, namespace string, probes []probe) []CheckResult {
+	results := make([]CheckResult, len(probes))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for range probeWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = runProbe(ctx, client, namespace, probes[i])
+			}
+		}()
+	}
+
+	for i := range probes {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// runProbe runs one probe in its pod
+func runProbe(ctx context.Context, client *kube.Client, namespace string, p probe) CheckResult {
+	result := CheckResult{
+		Check:   p.check,
+		From:    p.pod.Spec.NodeName,
+		To:      p.to,
+		Address: p.address,
+	}
+
+	stdout, stderr, err := client.Exec(ctx, namespace, p.pod.Name, p.command...)
+	switch {
+	case err != nil:
+		result.Error = strings.TrimSpace(stderr)
+		if result.Error == "" {
+			result.Error = err.Error()
+		}
+	case p.expect != "" && !strings.Contains(stdout, p.expect):
+		result.Error = fmt.Sprintf("unexpected response: %s", strings.TrimSpace(stdout))
+	default:
+		result.Passed = true
+	}
+
+	// Keep the table on one line per result
+	result.Error = strings.Join(strings.Fields(result.Error), " ")
+	return result
+}
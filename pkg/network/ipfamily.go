@@ -0,0 +1,102 @@
+package network
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// StackMode is the set of IP families a cluster uses
+type StackMode string
+
+// Supported stack modes
+const (
+	IPv4Only  StackMode = "ipv4"
+	IPv6Only  StackMode = "ipv6"
+	DualStack StackMode = "dualstack"
+)
+
+// pluginStackModes lists the stack modes each plugin supports; Weave Net
+// only ever allocated IPv4 addresses, and Canal runs Calico with IPv6
+// support turned off
+var pluginStackModes = map[Plugin][]StackMode{
+	Calico:  {IPv4Only, IPv6Only, DualStack},
+	Flannel: {IPv4Only, IPv6Only, DualStack},
+	Weave:   {IPv4Only},
+	Cilium:  {IPv4Only, IPv6Only, DualStack},
+	Canal:   {IPv4Only},
+}
+
+// ParseCIDRs parses a comma-separated list of one CIDR, or two of
+// different families for dual-stack, in the order given
+func ParseCIDRs(cidrs string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range strings.Split(cidrs, ",") {
+		prefix, err := ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	switch {
+	case len(prefixes) > 2:
+		return nil, fmt.Errorf("invalid CIDRs %q: at most one IPv4 and one IPv6 CIDR are allowed", cidrs)
+	case len(prefixes) == 2 && prefixes[0].Addr().Is4() == prefixes[1].Addr().Is4():
+		return nil, fmt.Errorf("invalid CIDRs %q: dual-stack needs one IPv4 and one IPv6 CIDR", cidrs)
+	}
+
+	return prefixes, nil
+}
+
+// StackModeOf returns the stack mode of a list of CIDRs
+func StackModeOf(prefixes []netip.Prefix) StackMode {
+	switch {
+	case len(prefixes) == 2:
+		return DualStack
+	case len(prefixes) == 1 && prefixes[0].Addr().Is6():
+		return IPv6Only
+	default:
+		return IPv4Only
+	}
+}
+
+// StackMode returns the stack mode of the pod CIDRs, IPv4Only if they
+// don't parse
+func (c *Config) StackMode() StackMode {
+	prefixes, _ := ParseCIDRs(c.PodCIDR)
+	return StackModeOf(prefixes)
+}
+
+// PodCIDRv4 returns the IPv4 pod CIDR, empty in IPv6-only clusters
+func (c *Config) PodCIDRv4() string {
+	return c.podCIDRFamily(false)
+}
+
+// PodCIDRv6 returns the IPv6 pod CIDR, empty in IPv4-only clusters
+func (c *Config) PodCIDRv6() string {
+	return c.podCIDRFamily(true)
+}
+
+// podCIDRFamily returns the pod CIDR of one family
+func (c *Config) podCIDRFamily(ipv6 bool) string {
+	prefixes, _ := ParseCIDRs(c.PodCIDR)
+	for _, prefix := range prefixes {
+		if prefix.Addr().Is6() == ipv6 {
+			return prefix.String()
+		}
+	}
+	return ""
+}
+
+// ValidateStackMode checks the plugin supports the stack mode of the pod CIDRs
+func ValidateStackMode(config *Config) error {
+	mode := config.StackMode()
+	for _, supported := range pluginStackModes[config.Plugin] {
+		if mode == supported {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("network plugin %s does not support %s clusters", config.Plugin, mode)
+}
@@ -0,0 +1,181 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+)
+
+// manifestFS holds the pinned plugin manifests, laid out as
+// manifests/<plugin>/<version>/<file>. Files ending in .tmpl are rendered
+// with the network Config; all others are applied verbatim.
+//
+//go:embed manifests
+var manifestFS embed.FS
+
+// SupportedVersions lists the manifest versions shipped for each plugin, newest first.
+// Cilium is installed from its Helm chart and is not listed here.
+var SupportedVersions = map[Plugin][]string{
+	Calico:  {"v3.29.2", "v3.28.5"},
+	Flannel: {"v0.28.4", "v0.25.7"},
+	Weave:   {"v2.8.1", "v2.8.0"},
+	Canal:   {"v3.28.5"},
+}
+
+// templateFuncs are the helpers available to manifest templates
+var templateFuncs = template.FuncMap{
+	// dnsLabel turns an address into something usable in a resource name
+	"dnsLabel": func(s string) string {
+		return strings.NewReplacer(".", "-", ":", "-").Replace(strings.ToLower(s))
+	},
+}
+
+// manifestData is what plugin templates are rendered with
+type manifestData struct {
+	*Config
+	FlannelBackend    string
+	FlannelIface      string
+	FlannelIfaceRegex string
+	CalicoBGP         string
+	CalicoNATOutgoing string
+	CalicoPools       []calicoPool
+	MigrationLabel    string // Set while migrating: only run on nodes with this label
+}
+
+// calicoPool is one IP pool in the Calico Installation
+type calicoPool struct {
+	Name          string
+	CIDR          string
+	BlockSize     int
+	Encapsulation string
+}
+
+// PluginVersion returns the manifest version to install: the configured one
+// if it is supported, the newest supported one if none is configured
+func PluginVersion(config *Config) (string, error) {
+	versions, ok := SupportedVersions[config.Plugin]
+	if !ok {
+		return "", fmt.Errorf("no embedded manifests for network plugin %s", config.Plugin)
+	}
+
+	if config.Version == "" {
+		return versions[0], nil
+	}
+
+	for _, v := range versions {
+		if v == config.Version {
+			return v, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s version %s is not supported (supported: %s)",
+		config.Plugin, config.Version, strings.Join(versions, ", "))
+}
+
+// RenderManifest returns the named manifest for the configured plugin and
+// version, rendering it if it is a template
+func RenderManifest(config *Config, name string) ([]byte, error) {
+	version, err := PluginVersion(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := path.Join("manifests", string(config.Plugin), version)
+
+	if data, err := fs.ReadFile(manifestFS, path.Join(dir, name)); err == nil {
+		return data, nil
+	}
+
+	text, err := fs.ReadFile(manifestFS, path.Join(dir, name+".tmpl"))
+	if err != nil {
+		return nil, fmt.Errorf("manifest %s not found for %s %s", name, config.Plugin, version)
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest template %s: %v", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, newManifestData(config)); err != nil {
+		return nil, fmt.Errorf("failed to render manifest %s: %v", name, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// newManifestData derives the plugin-specific template values from config
+func newManifestData(config *Config) *manifestData {
+	data := &manifestData{
+		Config:            config,
+		FlannelBackend:    "vxlan",
+		CalicoBGP:         "Enabled",
+		CalicoNATOutgoing: "Enabled",
+	}
+
+	if config.EnableEncryption {
+		data.FlannelBackend = "wireguard"
+	}
+
+	// Flannel picks the default-route interface unless told otherwise.
+	// The manifest runs on every node, so a CIDR selection is passed as a
+	// pattern for the address rather than one node's interface name.
+	if config.NodeInterface != "" {
+		data.FlannelIface = config.NodeInterface
+	} else if config.NodeAddressCIDR != "" {
+		if prefixes, err := ParseCIDRs(config.NodeAddressCIDR); err == nil {
+			data.FlannelIfaceRegex = addressRegex(prefixes)
+		}
+	}
+
+	if config.migrating {
+		data.MigrationLabel = MigrationLabel
+	}
+
+	if !config.EnableBGP {
+		data.CalicoBGP = "Disabled"
+	}
+
+	if !config.EnableNATOutgoing {
+		data.CalicoNATOutgoing = "Disabled"
+	}
+
+	if cidr := config.PodCIDRv4(); cidr != "" {
+		data.CalicoPools = append(data.CalicoPools, calicoPool{
+			Name:          "default-ipv4-ippool",
+			CIDR:          cidr,
+			BlockSize:     config.BlockSize,
+			Encapsulation: calicoPoolEncapsulation(config, false),
+		})
+	}
+
+	if cidr := config.PodCIDRv6(); cidr != "" {
+		data.CalicoPools = append(data.CalicoPools, calicoPool{
+			Name:          "default-ipv6-ippool",
+			CIDR:          cidr,
+			BlockSize:     config.BlockSizeV6,
+			Encapsulation: calicoPoolEncapsulation(config, true),
+		})
+	}
+
+	return data
+}
+
+// applyManifest server-side applies the manifest through the Kubernetes API.
+// KubeForge owns the plugin's objects, so fields last written by kubectl or
+// an earlier install are taken over on a reinstall, migration or switch to Canal.
+func applyManifest(manifest []byte) error {
+	client, err := kube.New()
+	if err != nil {
+		return err
+	}
+
+	return client.ForceApply(context.Background(), manifest)
+}
@@ -0,0 +1,211 @@
+package network
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/manifests")
+
+// manifestCases are the plugin settings each template is rendered with.
+// Every case is rendered for every supported version of its plugin.
+var manifestCases = []struct {
+	name  string
+	setup func(c *Config)
+}{
+	{"calico-ipip", func(c *Config) {
+		c.Plugin = Calico
+		c.EnableBGP = false
+	}},
+	{"calico-vxlan", func(c *Config) {
+		c.Plugin = Calico
+		c.Encapsulation = EncapsulationVXLAN
+		c.EnableBGP = false
+		c.MTU = 1450
+	}},
+	{"calico-bgp", func(c *Config) {
+		c.Plugin = Calico
+		c.Encapsulation = EncapsulationNone
+		c.ASNumber = 64512
+		c.BGPPeers = []BGPPeer{
+			{PeerIP: "10.20.0.1", ASNumber: 64500},
+			{PeerIP: "fd00::1", ASNumber: 64501, NodeSelector: "rack == 'a'"},
+		}
+	}},
+	{"calico-dualstack", func(c *Config) {
+		c.Plugin = Calico
+		c.PodCIDR = "10.244.0.0/16,fd00:10:244::/56"
+		c.EnableNATOutgoing = false
+	}},
+	{"calico-ipv6", func(c *Config) {
+		c.Plugin = Calico
+		c.PodCIDR = "fd00:10:244::/56"
+		c.Encapsulation = EncapsulationVXLAN
+	}},
+	{"calico-migration", func(c *Config) {
+		c.Plugin = Calico
+		c.migrating = true
+	}},
+	{"flannel", func(c *Config) {
+		c.Plugin = Flannel
+	}},
+	{"flannel-iface", func(c *Config) {
+		c.Plugin = Flannel
+		c.NodeInterface = "eth1"
+	}},
+	{"flannel-cidr", func(c *Config) {
+		c.Plugin = Flannel
+		c.NodeAddressCIDR = "10.20.16.0/20,fd00:20::/64"
+	}},
+	{"flannel-wireguard", func(c *Config) {
+		c.Plugin = Flannel
+		c.EnableEncryption = true
+	}},
+	{"flannel-dualstack", func(c *Config) {
+		c.Plugin = Flannel
+		c.PodCIDR = "10.244.0.0/16,fd00:10:244::/56"
+	}},
+	{"flannel-nftables", func(c *Config) {
+		c.Plugin = Flannel
+		c.KubeProxyNFTables = true
+	}},
+	{"flannel-migration", func(c *Config) {
+		c.Plugin = Flannel
+		c.migrating = true
+	}},
+	{"weave", func(c *Config) {
+		c.Plugin = Weave
+	}},
+	{"weave-mtu", func(c *Config) {
+		c.Plugin = Weave
+		c.PodCIDR = "10.32.0.0/12"
+		c.MTU = 1376
+	}},
+	{"canal", func(c *Config) {
+		c.Plugin = Canal
+	}},
+	{"canal-iface", func(c *Config) {
+		c.Plugin = Canal
+		c.NodeInterface = "eth1"
+		c.MTU = 1450
+	}},
+}
+
+func TestRenderManifestGolden(t *testing.T) {
+	for _, tc := range manifestCases {
+		config := DefaultConfig()
+		tc.setup(config)
+
+		for _, version := range SupportedVersions[config.Plugin] {
+			config.Version = version
+
+			templates, err := fs.Glob(manifestFS, path.Join("manifests", string(config.Plugin), version, "*.tmpl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(templates) == 0 {
+				t.Fatalf("no templates for %s %s", config.Plugin, version)
+			}
+
+			for _, tmpl := range templates {
+				name := strings.TrimSuffix(path.Base(tmpl), ".tmpl")
+				t.Run(tc.name+"/"+version+"/"+name, func(t *testing.T) {
+					got, err := RenderManifest(config, name)
+					if err != nil {
+						t.Fatalf("RenderManifest: %v", err)
+					}
+					checkGolden(t, filepath.Join("testdata", "manifests", tc.name, version, name), got)
+				})
+			}
+		}
+	}
+}
+
+// TestRenderManifestVerbatim checks that files without a template are
+// returned unchanged
+func TestRenderManifestVerbatim(t *testing.T) {
+	for _, version := range SupportedVersions[Calico] {
+		config := DefaultConfig()
+		config.Version = version
+
+		want, err := fs.ReadFile(manifestFS, path.Join("manifests", "calico", version, "tigera-operator.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := RenderManifest(config, "tigera-operator.yaml")
+		if err != nil {
+			t.Fatalf("RenderManifest %s: %v", version, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("tigera-operator.yaml %s was modified", version)
+		}
+	}
+}
+
+func TestRenderManifestErrors(t *testing.T) {
+	config := DefaultConfig()
+	config.Version = "v0.0.1"
+	if _, err := RenderManifest(config, "custom-resources.yaml"); err == nil {
+		t.Error("unsupported version rendered")
+	}
+
+	config.Version = ""
+	if _, err := RenderManifest(config, "missing.yaml"); err == nil {
+		t.Error("missing manifest rendered")
+	}
+
+	config.Plugin = Cilium
+	if _, err := RenderManifest(config, "cilium.yaml"); err == nil {
+		t.Error("cilium has no embedded manifests but one rendered")
+	}
+}
+
+// checkGolden compares got with the golden file, or rewrites it with -update
+func checkGolden(t *testing.T, golden string, got []byte) {
+	t.Helper()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the rendered manifest (run go test -update to accept):\n%s",
+			golden, firstDifference(string(want), string(got)))
+	}
+}
+
+// firstDifference describes the first line where want and got differ
+func firstDifference(want, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d:\n  want: %s\n  got:  %s", i+1, w, g)
+		}
+	}
+	return ""
+}
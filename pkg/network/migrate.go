@@ -0,0 +1,750 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/internal/wait"
+)
+
+// MigrationLabel marks the nodes switched to the new plugin while Migrate
+// runs. Calico and Flannel, which can't share a node with the old plugin,
+// only run on the labelled nodes.
+const MigrationLabel = "kubeforge.io/cni-migrated"
+
+// migratedFromAnnotation records the plugin a node was moved away from once
+// its connectivity check passed, so a rerun can finish an interrupted
+// migration and skip the node
+const migratedFromAnnotation = "kubeforge.io/cni-migrated-from"
+
+// ciliumMigrationLabel selects the nodes Cilium writes its CNI config on
+const ciliumMigrationLabel = "io.cilium.migration/cilium-default"
+
+// ciliumMigrationValues run Cilium next to another plugin without writing
+// a CNI config or enforcing policy, following the Cilium migration guide
+var ciliumMigrationValues = []string{
+	"cni.customConf=true",
+	"cni.uninstall=false",
+	"operator.unmanagedPodWatcher.restart=false",
+	"policyEnforcementMode=never",
+	"bpf.hostLegacyRouting=true",
+}
+
+// ciliumNodeConfig makes Cilium take over pod networking on the nodes
+// labelled with ciliumMigrationLabel, moving other CNI configs aside
+const ciliumNodeConfig = `apiVersion: cilium.io/v2alpha1
+kind: CiliumNodeConfig
+metadata:
+  namespace: kube-system
+  name: cilium-default
+spec:
+  nodeSelector:
+    matchLabels:
+      io.cilium.migration/cilium-default: "true"
+  defaults:
+    write-cni-conf-when-ready: /host/etc/cni/net.d/05-cilium.conflist
+    custom-cni-conf: "false"
+    cni-chaining-mode: "none"
+    cni-exclusive: "true"
+`
+
+// MigrationTargets lists the plugins Migrate can move a cluster to
+var MigrationTargets = []Plugin{Cilium, Calico, Flannel}
+
+// pluginManifests lists the embedded manifests of each plugin in install order
+var pluginManifests = map[Plugin][]string{
+	Calico:  {"tigera-operator.yaml", "custom-resources.yaml"},
+	Flannel: {"kube-flannel.yaml"},
+	Weave:   {"weave-daemonset.yaml"},
+	Canal:   {"canal.yaml"},
+}
+
+// Time limits for the per-node migration steps
+const (
+	drainTimeout      = 5 * time.Minute
+	nodeScriptTimeout = 2 * time.Minute
+)
+
+// Migrate moves the cluster's pod network from the installed plugin to
+// config.Plugin. Cilium is installed next to the old plugin and takes
+// over one node at a time; Calico and Flannel only run on nodes already
+// switched, so pods on migrated and remaining nodes can't reach each
+// other until the last node is done. Each node is cordoned, drained,
+// switched to the new CNI config and has its pods restarted, and pod
+// connectivity is checked before moving on. Finally the old plugin's
+// resources, interfaces and iptables chains are removed. Progress is kept
+// on the nodes, so running Migrate again after a failure continues where
+// it stopped. nodeSetup, when not empty, is a shell script run on every
+// node before the new plugin starts, such as one opening its firewall ports.
+// It returns the plugin that was replaced.
+func Migrate(config *Config, nodeSetup string, log *logger.Logger) (Plugin, error) {
+	if err := ValidateMigrationTarget(config.Plugin); err != nil {
+		return None, err
+	}
+
+	client, err := kube.New()
+	if err != nil {
+		return None, err
+	}
+	ctx := context.Background()
+
+	found, err := DetectPlugins(ctx, client)
+	if err != nil {
+		return None, err
+	}
+	done, err := migratedNodes(ctx, client)
+	if err != nil {
+		return None, err
+	}
+	from, resuming, err := migrationSource(found, done, config.Plugin)
+	if err != nil {
+		return None, err
+	}
+	switch {
+	case from.Plugin == Cilium:
+		return None, fmt.Errorf("migrating away from cilium is not supported")
+	case from.Plugin == Canal && config.Plugin != Cilium:
+		// Removing Canal would delete resources Calico and Flannel share with it
+		return None, fmt.Errorf("canal can only be migrated to cilium")
+	case !resuming && !from.Healthy:
+		return None, fmt.Errorf("%s is not healthy (%s), fix it before migrating", from.Plugin, from.Reason)
+	}
+
+	if err := ValidatePodCIDR(config); err != nil {
+		return None, err
+	}
+
+	nodes, err := migrationOrder(ctx, client)
+	if err != nil {
+		return None, err
+	}
+
+	if resuming {
+		// Migrated nodes already have pods in the new CIDR and, with Calico
+		// or Flannel, can't reach the others, so the checks made before the
+		// first run started no longer hold
+		log.Info("Resuming the migration from %s to %s, %d of %d nodes done", from.Plugin, config.Plugin, len(done), len(nodes))
+	} else {
+		log.Info("Migrating from %s to %s", from, config.Plugin)
+
+		if err := checkMigrationPodCIDR(ctx, client, config); err != nil {
+			return None, err
+		}
+
+		log.Info("Checking pod connectivity before the migration...")
+		if err := checkConnectivity(ctx, client, [2]string{}, log); err != nil {
+			return None, fmt.Errorf("pod network is not working before the migration: %v", err)
+		}
+	}
+
+	SetMigrationTunnelPort(config)
+
+	if nodeSetup != "" {
+		log.Info("Preparing %d nodes for %s...", len(nodes), config.Plugin)
+		for _, node := range nodes {
+			if _, err := runOnNode(ctx, client, node, nodeSetup); err != nil {
+				return None, fmt.Errorf("failed to prepare node %s, run the migration again to continue: %v", node, err)
+			}
+		}
+	}
+
+	config.migrating = true
+	if err := InstallPlugin(config, log); err != nil {
+		return None, err
+	}
+	if config.Plugin == Cilium {
+		if err := client.ForceApply(ctx, []byte(ciliumNodeConfig)); err != nil {
+			return None, fmt.Errorf("failed to apply CiliumNodeConfig: %v", err)
+		}
+	}
+
+	var migrated []string
+	for _, node := range nodes {
+		if done[node] != None {
+			log.Info("Node %s already runs %s, skipping", node, config.Plugin)
+			migrated = append(migrated, node)
+			continue
+		}
+
+		log.Info("Migrating node %s to %s...", node, config.Plugin)
+		if err := migrateNode(ctx, client, config, from.Plugin, node, log); err != nil {
+			return None, fmt.Errorf("failed to migrate node %s, run the migration again to continue: %v", node, err)
+		}
+
+		// Check against the first migrated node, which the new plugin
+		// can reach whatever the old one does
+		peer := node
+		if len(migrated) > 0 {
+			peer = migrated[0]
+		}
+		log.Info("Checking pod connectivity between %s and %s...", node, peer)
+		if err := checkConnectivity(ctx, client, [2]string{node, peer}, log); err != nil {
+			return None, fmt.Errorf("pod connectivity failed after migrating node %s, run the migration again to continue: %v", node, err)
+		}
+		if err := annotateNode(ctx, client, node, map[string]any{migratedFromAnnotation: string(from.Plugin)}); err != nil {
+			return None, err
+		}
+		migrated = append(migrated, node)
+	}
+
+	// Every node runs the new plugin; drop the migration settings
+	log.Info("Finishing the %s installation...", config.Plugin)
+	config.migrating = false
+	if err := InstallPlugin(config, log); err != nil {
+		return None, err
+	}
+	if config.Plugin == Cilium {
+		if err := client.Delete(ctx, []byte(ciliumNodeConfig)); err != nil {
+			return None, err
+		}
+	}
+
+	if err := removePlugin(ctx, client, config, from, log); err != nil {
+		return None, err
+	}
+
+	for _, node := range nodes {
+		log.Info("Removing %s interfaces and iptables chains from %s...", from.Plugin, node)
+		if _, err := runOnNode(ctx, client, node, hostCleanupScript(signatureOf(from.Plugin))); err != nil {
+			return None, fmt.Errorf("failed to clean up node %s: %v", node, err)
+		}
+	}
+
+	// The markers go last, so a rerun after a failed cleanup still knows
+	// which plugin to remove
+	for _, node := range nodes {
+		labels := map[string]any{MigrationLabel: nil, ciliumMigrationLabel: nil}
+		if err := labelNode(ctx, client, node, labels); err != nil {
+			return None, err
+		}
+		if err := annotateNode(ctx, client, node, map[string]any{migratedFromAnnotation: nil}); err != nil {
+			return None, err
+		}
+	}
+
+	log.Info("Checking pod connectivity after the migration...")
+	if err := checkConnectivity(ctx, client, [2]string{nodes[0], nodes[len(nodes)-1]}, log); err != nil {
+		return None, fmt.Errorf("pod connectivity failed after the migration: %v", err)
+	}
+
+	log.Info("Migration from %s to %s complete!", from.Plugin, config.Plugin)
+	return from.Plugin, nil
+}
+
+// migratedNodes returns the nodes an earlier run finished migrating, with
+// the plugin they were moved away from
+func migratedNodes(ctx context.Context, client *kube.Client) (map[string]Plugin, error) {
+	nodes, err := client.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: MigrationLabel + "=true"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	done := make(map[string]Plugin)
+	for _, node := range nodes.Items {
+		if from := node.Annotations[migratedFromAnnotation]; from != "" {
+			done[node.Name] = Plugin(from)
+		}
+	}
+	return done, nil
+}
+
+// migrationSource picks the plugin to migrate away from among the
+// detected ones, and reports whether an earlier run was interrupted
+func migrationSource(found []*PluginStatus, done map[string]Plugin, to Plugin) (*PluginStatus, bool, error) {
+	var source Plugin
+	for node, from := range done {
+		if source != None && from != source {
+			return nil, false, fmt.Errorf("nodes were migrated from both %s and %s (%s)", source, from, node)
+		}
+		source = from
+	}
+
+	var target *PluginStatus
+	var running []*PluginStatus
+	for _, status := range found {
+		switch {
+		case status.Plugin == to:
+			target = status
+		case status.DaemonSet != "":
+			running = append(running, status)
+		}
+	}
+
+	if source != None {
+		if target == nil || target.DaemonSet == "" {
+			return nil, false, fmt.Errorf("nodes were migrated away from %s, but not to %s", source, to)
+		}
+		for _, status := range found {
+			if status.Plugin == source {
+				return status, true, nil
+			}
+		}
+		// The old plugin's resources are already gone, only the node cleanup is left
+		return &PluginStatus{Plugin: source}, true, nil
+	}
+
+	switch {
+	case len(running) > 1:
+		var names []string
+		for _, status := range running {
+			names = append(names, string(status.Plugin))
+		}
+		return nil, false, fmt.Errorf("several network plugins are running (%s), remove all but one first", strings.Join(names, ", "))
+	case len(running) == 1:
+		// The target running too means an earlier run stopped before
+		// finishing its first node
+		return running[0], target != nil && target.DaemonSet != "", nil
+	case target != nil:
+		return nil, false, fmt.Errorf("%s is already installed", to)
+	default:
+		return nil, false, fmt.Errorf("no network plugin is installed, there is nothing to migrate from")
+	}
+}
+
+// SetMigrationTunnelPort moves Cilium off the standard VXLAN port, which
+// Flannel and Canal already listen on while both plugins run. Callers that
+// open firewall ports for the new plugin must call it first; Migrate does.
+func SetMigrationTunnelPort(config *Config) {
+	if config.Plugin == Cilium && config.TunnelPort == 0 && CiliumTunnelPort(config) == 8472 {
+		config.TunnelPort = 8473
+	}
+}
+
+// ValidateMigrationTarget checks that Migrate can move a cluster to plugin
+func ValidateMigrationTarget(plugin Plugin) error {
+	if slices.Contains(MigrationTargets, plugin) {
+		return nil
+	}
+	if plugin == Weave {
+		return fmt.Errorf("weave is archived upstream, migrate to cilium, calico or flannel instead")
+	}
+	return fmt.Errorf("unsupported network plugin: %s", plugin)
+}
+
+// migrateNode moves one node to the new plugin: cordon, drain, switch the
+// CNI config, restart the pods left on the node and uncordon. A node that
+// fails is uncordoned too, so it keeps serving on whichever plugin it runs.
+func migrateNode(ctx context.Context, client *kube.Client, config *Config, from Plugin, node string, log *logger.Logger) (err error) {
+	if err := cordon(ctx, client, node, true); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if uncordonErr := cordon(context.WithoutCancel(ctx), client, node, false); uncordonErr != nil {
+				log.Warn("Failed to uncordon %s: %v", node, uncordonErr)
+			}
+		}
+	}()
+
+	log.Info("Draining %s...", node)
+	drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+	defer cancel()
+	if err := drain(drainCtx, client, node); err != nil {
+		return err
+	}
+
+	sig := signatureOf(config.Plugin)
+	waitCtx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+
+	if config.Plugin == Cilium {
+		// The agent writes its CNI config and moves the old one aside on restart
+		labels := map[string]any{ciliumMigrationLabel: "true", MigrationLabel: "true"}
+		if err := labelNode(ctx, client, node, labels); err != nil {
+			return err
+		}
+		if err := deleteNodePods(ctx, client, node, "kube-system", sig.podSelector); err != nil {
+			return err
+		}
+	} else {
+		script := fmt.Sprintf("rm -f %s/*%s*", CNIConfigDir, signatureOf(from).cniConfig)
+		if _, err := runOnNode(ctx, client, node, script); err != nil {
+			return err
+		}
+		if err := labelNode(ctx, client, node, map[string]any{MigrationLabel: "true"}); err != nil {
+			return err
+		}
+	}
+
+	log.Info("Waiting for %s on %s...", config.Plugin, node)
+	if err := wait.Pods(waitCtx, client, sig.daemonSets[0].namespace, sig.podSelector, log); err != nil {
+		return err
+	}
+
+	// DaemonSet pods stay through a drain and still have old addresses
+	if err := restartPodNetworkPods(ctx, client, node); err != nil {
+		return err
+	}
+
+	return cordon(ctx, client, node, false)
+}
+
+// removePlugin deletes the old plugin's cluster resources
+func removePlugin(ctx context.Context, client *kube.Client, config *Config, from *PluginStatus, log *logger.Logger) error {
+	log.Info("Removing %s...", from.Plugin)
+
+	// Render the manifests the old plugin was installed from
+	old := DefaultConfig()
+	old.Plugin = from.Plugin
+	old.PodCIDR = config.PodCIDR
+	old.Version = "v" + strings.TrimPrefix(from.Version, "v")
+	if _, err := PluginVersion(old); err != nil {
+		old.Version = ""
+	}
+
+	// Remove the plugin's own resources first so an operator can tear
+	// down what it created before it goes itself
+	files := pluginManifests[from.Plugin]
+	for i := len(files) - 1; i >= 0; i-- {
+		manifest, err := RenderManifest(old, files[i])
+		if err != nil {
+			return err
+		}
+		if err := client.Delete(ctx, manifest); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", from.Plugin, err)
+		}
+
+		if i == len(files)-1 {
+			waitCtx, cancel := context.WithTimeout(ctx, pluginTimeout)
+			err := wait.Retry(waitCtx, 2*time.Second, func() error {
+				ds, err := findDaemonSet(waitCtx, client, signatureOf(from.Plugin).daemonSets)
+				if err == nil && ds != nil {
+					return fmt.Errorf("DaemonSet %s/%s still exists", ds.Namespace, ds.Name)
+				}
+				return err
+			})
+			cancel()
+			if err != nil {
+				return fmt.Errorf("failed to remove %s: %v", from.Plugin, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkMigrationPodCIDR makes sure the new plugin's pod CIDR is not in use
+// by the old one, and for Flannel covers the node pod CIDRs it relies on
+func checkMigrationPodCIDR(ctx context.Context, client *kube.Client, config *Config) error {
+	prefixes, err := ParseCIDRs(config.PodCIDR)
+	if err != nil {
+		return err
+	}
+	contains := func(addr netip.Addr) bool {
+		for _, prefix := range prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	pods, err := client.Clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %v", err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.HostNetwork {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			if addr, err := netip.ParseAddr(podIP.IP); err == nil && contains(addr) {
+				return fmt.Errorf("pod %s/%s has address %s in the new pod CIDR %s, pick a range the current plugin does not use",
+					pod.Namespace, pod.Name, podIP.IP, config.PodCIDR)
+			}
+		}
+	}
+
+	if config.Plugin != Flannel {
+		return nil
+	}
+
+	nodes, err := client.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+	for _, node := range nodes.Items {
+		if len(node.Spec.PodCIDRs) == 0 {
+			return fmt.Errorf("node %s has no pod CIDR, which Flannel needs", node.Name)
+		}
+		for _, cidr := range node.Spec.PodCIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil || !contains(prefix.Addr()) {
+				return fmt.Errorf("flannel uses the node pod CIDRs, but %s of node %s is outside %s", cidr, node.Name, config.PodCIDR)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrationOrder returns the node names with workers first and control
+// plane nodes last
+func migrationOrder(ctx context.Context, client *kube.Client) ([]string, error) {
+	nodes, err := client.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	if len(nodes.Items) == 0 {
+		return nil, fmt.Errorf("the cluster has no nodes")
+	}
+
+	items := nodes.Items
+	sort.SliceStable(items, func(i, j int) bool {
+		_, iControlPlane := items[i].Labels["node-role.kubernetes.io/control-plane"]
+		_, jControlPlane := items[j].Labels["node-role.kubernetes.io/control-plane"]
+		return !iControlPlane && jControlPlane
+	})
+
+	names := make([]string, len(items))
+	for i, node := range items {
+		names[i] = node.Name
+	}
+	return names, nil
+}
+
+// cordon marks a node unschedulable, or schedulable again
+func cordon(ctx context.Context, client *kube.Client, node string, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	_, err := client.Clientset.CoreV1().Nodes().Patch(ctx, node, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to cordon node %s: %v", node, err)
+	}
+	return nil
+}
+
+// labelNode sets node labels; a nil value removes the label
+func labelNode(ctx context.Context, client *kube.Client, node string, labels map[string]any) error {
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"labels": labels}})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Clientset.CoreV1().Nodes().Patch(ctx, node, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to label node %s: %v", node, err)
+	}
+	return nil
+}
+
+// annotateNode sets node annotations; a nil value removes the annotation
+func annotateNode(ctx context.Context, client *kube.Client, node string, annotations map[string]any) error {
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": annotations}})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Clientset.CoreV1().Nodes().Patch(ctx, node, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate node %s: %v", node, err)
+	}
+	return nil
+}
+
+// drain evicts the pods on a node, except DaemonSet and static pods, and
+// waits for them to terminate. Evictions blocked by a PodDisruptionBudget
+// are retried until ctx is done.
+func drain(ctx context.Context, client *kube.Client, node string) error {
+	pods, err := nodePods(ctx, client, node, "", "")
+	if err != nil {
+		return err
+	}
+
+	var evicted []corev1.Pod
+	for _, pod := range pods {
+		if ownedByDaemonSet(&pod) {
+			continue
+		}
+		if _, mirror := pod.Annotations[corev1.MirrorPodAnnotationKey]; mirror {
+			continue
+		}
+
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name}}
+		err := wait.Retry(ctx, 5*time.Second, func() error {
+			err := client.Clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to evict pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		evicted = append(evicted, pod)
+	}
+
+	return wait.Retry(ctx, 2*time.Second, func() error {
+		for _, pod := range evicted {
+			current, err := client.Clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) || (err == nil && current.UID != pod.UID) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("pod %s/%s is still terminating", pod.Namespace, pod.Name)
+		}
+		return nil
+	})
+}
+
+// restartPodNetworkPods deletes the running pods on a node that use the
+// pod network, so their controllers recreate them on the new plugin
+func restartPodNetworkPods(ctx context.Context, client *kube.Client, node string) error {
+	pods, err := nodePods(ctx, client, node, "", "")
+	if err != nil {
+		return err
+	}
+
+	for _, pod := range pods {
+		if pod.Spec.HostNetwork || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		err := client.Clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to restart pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteNodePods deletes the pods matching selector on a node
+func deleteNodePods(ctx context.Context, client *kube.Client, node, namespace, selector string) error {
+	pods, err := nodePods(ctx, client, node, namespace, selector)
+	if err != nil {
+		return err
+	}
+
+	for _, pod := range pods {
+		err := client.Clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// nodePods lists the pods on a node matching selector, in namespace or
+// all namespaces if empty
+func nodePods(ctx context.Context, client *kube.Client, node, namespace, selector string) ([]corev1.Pod, error) {
+	pods, err := client.Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + node,
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods on node %s: %v", node, err)
+	}
+	return pods.Items, nil
+}
+
+// ownedByDaemonSet reports whether a DaemonSet controls the pod
+func ownedByDaemonSet(pod *corev1.Pod) bool {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
+
+// runOnNode runs a shell script in the host namespaces of a node from a
+// privileged host-network pod, which needs no pod network, and returns
+// its output
+func runOnNode(ctx context.Context, client *kube.Client, node, script string) (string, error) {
+	privileged := true
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "kubeforge-node-", Namespace: "kube-system"},
+		Spec: corev1.PodSpec{
+			NodeName:      node,
+			HostNetwork:   true,
+			HostPID:       true,
+			RestartPolicy: corev1.RestartPolicyNever,
+			Tolerations:   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers: []corev1.Container{{
+				Name:            "node",
+				Image:           "busybox:stable",
+				Command:         []string{"nsenter", "-t", "1", "-m", "-u", "-i", "-n", "-p", "--", "sh", "-c", script},
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+			}},
+		},
+	}
+
+	pods := client.Clientset.CoreV1().Pods("kube-system")
+	pod, err := pods.Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to start pod on node %s: %v", node, err)
+	}
+	defer pods.Delete(context.Background(), pod.Name, metav1.DeleteOptions{})
+
+	waitCtx, cancel := context.WithTimeout(ctx, nodeScriptTimeout)
+	defer cancel()
+
+	var phase corev1.PodPhase
+	err = wait.Retry(waitCtx, 2*time.Second, func() error {
+		current, err := pods.Get(waitCtx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		phase = current.Status.Phase
+		if phase != corev1.PodSucceeded && phase != corev1.PodFailed {
+			return fmt.Errorf("pod %s on node %s is %s", pod.Name, node, phase)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	logs, err := pods.GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get output from node %s: %v", node, err)
+	}
+	if phase == corev1.PodFailed {
+		return "", fmt.Errorf("script failed on node %s: %s", node, strings.TrimSpace(string(logs)))
+	}
+	return string(logs), nil
+}
+
+// hostCleanupScript removes a plugin's CNI config, interfaces, state and
+// iptables chains from a node
+func hostCleanupScript(sig pluginSignature) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "rm -f %s/*%s*\n", CNIConfigDir, sig.cniConfig)
+	for _, link := range sig.links {
+		fmt.Fprintf(&b, "ip link delete %s 2>/dev/null\n", link)
+	}
+	for _, dir := range sig.dirs {
+		fmt.Fprintf(&b, "rm -rf %s\n", dir)
+	}
+
+	// Only the plugin's chains and the jumps into them go; rewriting the
+	// whole ruleset would race kube-proxy and the new plugin
+	fmt.Fprintf(&b, "for t in iptables ip6tables; do\n")
+	fmt.Fprintf(&b, "  command -v $t >/dev/null || continue\n")
+	fmt.Fprintf(&b, "  for table in filter nat mangle raw; do\n")
+	fmt.Fprintf(&b, "    chains=$($t -t $table -S 2>/dev/null | awk '$1 == \"-N\" {print $2}' | grep -E '^(%s)')\n", sig.chains)
+	fmt.Fprintf(&b, "    [ -n \"$chains\" ] || continue\n")
+	fmt.Fprintf(&b, "    $t -t $table -S | grep -E -- '-[jg] (%s)' | grep -v -E '^-A (%s)' | sed 's/^-A /-D /' |\n", sig.chains, sig.chains)
+	fmt.Fprintf(&b, "      while read -r rule; do eval \"$t -t $table $rule\" || exit 1; done || exit 1\n")
+	fmt.Fprintf(&b, "    for c in $chains; do $t -t $table -F $c || exit 1; done\n")
+	fmt.Fprintf(&b, "    for c in $chains; do $t -t $table -X $c || exit 1; done\n")
+	fmt.Fprintf(&b, "  done\n")
+	fmt.Fprintf(&b, "done\n")
+
+	return b.String()
+}
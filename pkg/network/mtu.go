@@ -0,0 +1,174 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Per-packet overhead of each encapsulation over an IPv4 underlay; an IPv6
+// underlay adds ipv6HeaderExtra to each
+const (
+	OverheadIPIP      = 20
+	OverheadVXLAN     = 50
+	OverheadGeneve    = 50
+	OverheadWireGuard = 60
+	ipv6HeaderExtra   = 20
+)
+
+// Cilium tunnel protocols
+const (
+	TunnelVXLAN  = "vxlan"
+	TunnelGeneve = "geneve"
+)
+
+// CiliumTunnelPort returns the UDP port Cilium tunnels on: config.TunnelPort,
+// the standard port of the tunnel protocol, or 0 with native routing
+func CiliumTunnelPort(config *Config) int {
+	switch {
+	case config.RoutingMode == RoutingNative:
+		return 0
+	case config.TunnelPort > 0:
+		return config.TunnelPort
+	case config.TunnelProtocol == TunnelGeneve:
+		return 6081
+	default:
+		return 8472
+	}
+}
+
+// DefaultRouteInterface returns the interface of the IPv4 default route
+// with the lowest metric, or of the IPv6 one if there is no IPv4 route
+func DefaultRouteInterface() (string, error) {
+	if iface, err := defaultRouteInterface4(); err == nil && iface != "" {
+		return iface, nil
+	}
+
+	iface, err := defaultRouteInterface6()
+	if err != nil {
+		return "", err
+	}
+	if iface == "" {
+		return "", fmt.Errorf("no default route found")
+	}
+
+	return iface, nil
+}
+
+// defaultRouteInterface4 returns the interface of the best IPv4 default route
+func defaultRouteInterface4() (string, error) {
+	return defaultRoute("/proc/net/route", func(fields []string) (string, int, bool) {
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			return "", 0, false
+		}
+		metric, _ := strconv.Atoi(fields[6])
+		return fields[0], metric, true
+	})
+}
+
+// defaultRouteInterface6 returns the interface of the best IPv6 default route
+func defaultRouteInterface6() (string, error) {
+	return defaultRoute("/proc/net/ipv6_route", func(fields []string) (string, int, bool) {
+		if len(fields) < 10 || strings.Trim(fields[0], "0") != "" || fields[1] != "00" || fields[9] == "lo" {
+			return "", 0, false
+		}
+		metric, _ := strconv.ParseInt(fields[5], 16, 64)
+		return fields[9], int(metric), true
+	})
+}
+
+// defaultRoute returns the interface of the lowest-metric line that
+// parse accepts in a /proc routing table
+func defaultRoute(path string, parse func([]string) (string, int, bool)) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	best, bestMetric := "", -1
+	for _, line := range strings.Split(string(data), "\n") {
+		iface, metric, ok := parse(strings.Fields(line))
+		if ok && (bestMetric < 0 || metric < bestMetric) {
+			best, bestMetric = iface, metric
+		}
+	}
+
+	return best, nil
+}
+
+// InterfaceMTU returns the MTU of a network interface
+func InterfaceMTU(name string) (int, error) {
+	data, err := os.ReadFile(filepath.Join("/sys/class/net", name, "mtu"))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// EncapsulationOverhead returns the bytes the plugin's encapsulation adds
+// to each pod packet. With WireGuard, traffic between nodes is carried by
+// the WireGuard tunnel, so the larger of the two overheads applies.
+func EncapsulationOverhead(config *Config) int {
+	overhead := 0
+
+	switch config.Plugin {
+	case Calico:
+		// Pods use the MTU of the most encapsulated pool
+		if config.PodCIDRv4() != "" {
+			overhead = calicoOverhead(calicoPoolEncapsulation(config, false))
+		}
+		if config.PodCIDRv6() != "" {
+			overhead = max(overhead, calicoOverhead(calicoPoolEncapsulation(config, true)))
+		}
+	case Flannel, Weave, Canal:
+		overhead = OverheadVXLAN
+	case Cilium:
+		switch {
+		case config.RoutingMode == RoutingNative:
+		case config.TunnelProtocol == TunnelGeneve:
+			overhead = OverheadGeneve
+		default:
+			overhead = OverheadVXLAN
+		}
+	}
+
+	if config.EnableEncryption && overhead < OverheadWireGuard {
+		overhead = OverheadWireGuard
+	}
+
+	if overhead > 0 && config.StackMode() == IPv6Only {
+		overhead += ipv6HeaderExtra
+	}
+
+	return overhead
+}
+
+// calicoOverhead returns the overhead of a Calico pool encapsulation
+func calicoOverhead(encapsulation string) int {
+	switch {
+	case strings.HasPrefix(encapsulation, EncapsulationIPIP):
+		return OverheadIPIP
+	case strings.HasPrefix(encapsulation, EncapsulationVXLAN):
+		return OverheadVXLAN
+	}
+	return 0
+}
+
+// DetectMTU returns the pod MTU for the plugin: the MTU of the
+// default-route interface less the encapsulation overhead
+func DetectMTU(config *Config) (int, error) {
+	iface, err := DefaultRouteInterface()
+	if err != nil {
+		return 0, err
+	}
+
+	mtu, err := InterfaceMTU(iface)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read MTU of %s: %v", iface, err)
+	}
+
+	return mtu - EncapsulationOverhead(config), nil
+}
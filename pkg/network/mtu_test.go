@@ -0,0 +1,68 @@
+package network
+
+import "testing"
+
+func TestEncapsulationOverhead(t *testing.T) {
+	const (
+		ipv4      = "10.244.0.0/16"
+		ipv6      = "fd00:10:244::/56"
+		dualStack = ipv4 + "," + ipv6
+	)
+
+	tests := []struct {
+		name          string
+		plugin        Plugin
+		podCIDR       string
+		encapsulation string // Calico pool encapsulation
+		routingMode   string // Cilium routing mode
+		tunnel        string // Cilium tunnel protocol
+		wireGuard     bool
+		want          int
+	}{
+		{"calico ipip", Calico, ipv4, EncapsulationIPIP, "", "", false, 20},
+		{"calico ipip cross-subnet", Calico, ipv4, EncapsulationIPIPCrossSubnet, "", "", false, 20},
+		{"calico vxlan", Calico, ipv4, EncapsulationVXLAN, "", "", false, 50},
+		{"calico vxlan cross-subnet", Calico, ipv4, EncapsulationVXLANCrossSubnet, "", "", false, 50},
+		{"calico unencapsulated", Calico, ipv4, EncapsulationNone, "", "", false, 0},
+		{"calico ipip ipv6-only pools are routed natively", Calico, ipv6, EncapsulationIPIP, "", "", false, 0},
+		{"calico ipip cross-subnet ipv6-only", Calico, ipv6, EncapsulationIPIPCrossSubnet, "", "", false, 0},
+		{"calico vxlan ipv6-only", Calico, ipv6, EncapsulationVXLAN, "", "", false, 70},
+		{"calico ipip dual-stack", Calico, dualStack, EncapsulationIPIP, "", "", false, 20},
+		{"calico vxlan dual-stack", Calico, dualStack, EncapsulationVXLAN, "", "", false, 50},
+		{"calico ipip wireguard", Calico, ipv4, EncapsulationIPIP, "", "", true, 60},
+		{"calico ipip wireguard ipv6-only", Calico, ipv6, EncapsulationIPIP, "", "", true, 80},
+		{"calico unencapsulated wireguard", Calico, ipv4, EncapsulationNone, "", "", true, 60},
+
+		{"flannel", Flannel, ipv4, "", "", "", false, 50},
+		{"flannel ipv6-only", Flannel, ipv6, "", "", "", false, 70},
+		{"flannel dual-stack", Flannel, dualStack, "", "", "", false, 50},
+		{"flannel wireguard", Flannel, ipv4, "", "", "", true, 60},
+		{"canal", Canal, ipv4, "", "", "", false, 50},
+		{"weave", Weave, ipv4, "", "", "", false, 50},
+
+		{"cilium vxlan", Cilium, ipv4, "", RoutingTunnel, TunnelVXLAN, false, 50},
+		{"cilium geneve", Cilium, ipv4, "", RoutingTunnel, TunnelGeneve, false, 50},
+		{"cilium vxlan ipv6-only", Cilium, ipv6, "", RoutingTunnel, TunnelVXLAN, false, 70},
+		{"cilium native", Cilium, ipv4, "", RoutingNative, "", false, 0},
+		{"cilium native ipv6-only", Cilium, ipv6, "", RoutingNative, "", false, 0},
+		{"cilium native wireguard", Cilium, ipv4, "", RoutingNative, "", true, 60},
+		{"cilium vxlan wireguard", Cilium, ipv4, "", RoutingTunnel, TunnelVXLAN, true, 60},
+		{"cilium native wireguard ipv6-only", Cilium, ipv6, "", RoutingNative, "", true, 80},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Plugin = tt.plugin
+			config.PodCIDR = tt.podCIDR
+			config.Encapsulation = tt.encapsulation
+			config.RoutingMode = tt.routingMode
+			config.TunnelProtocol = tt.tunnel
+			config.EnableEncryption = tt.wireGuard
+
+			if got := EncapsulationOverhead(config); got != tt.want {
+				t.Errorf("EncapsulationOverhead() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/internal/wait"
+)
+
+// PolicyEnforcers lists the plugins that implement NetworkPolicy. Flannel
+// only moves packets and ignores policies.
+var PolicyEnforcers = []Plugin{Calico, Weave, Cilium, Canal}
+
+const (
+	policyApp     = "kubeforge-policy"
+	policyTimeout = 3 * time.Minute
+	// Policies are programmed asynchronously after they are created
+	policySettleTimeout = 30 * time.Second
+	// Consecutive requests the denied client must fail to count as blocked
+	policyDeniedAttempts = 3
+)
+
+// flannelLeftovers removes what Flannel's CNI config leaves on a node once
+// Canal's has taken over and the pods using the old one are gone
+const flannelLeftovers = `rm -f ` + CNIConfigDir + `/*flannel*
+ip link delete cni0 2>/dev/null
+rm -rf /var/lib/cni/networks/cbr0
+true
+`
+
+// PolicyCheck is the outcome of VerifyPolicy
+type PolicyCheck struct {
+	Plugin  Plugin
+	Allowed bool   // The client the policy allows reached the server
+	Blocked bool   // The other client was blocked
+	Error   string // Why the last attempt did not behave as expected
+}
+
+// Enforced reports whether traffic was allowed and blocked as the policies say
+func (c *PolicyCheck) Enforced() bool {
+	return c.Allowed && c.Blocked
+}
+
+// CheckPolicyEnforcement verifies NetworkPolicy enforcement in the cluster
+// in the admin kubeconfig and reports it against the installed plugin
+func CheckPolicyEnforcement(log *logger.Logger) (*PolicyCheck, error) {
+	status, err := GetCurrentPlugin(log)
+	if err != nil {
+		return nil, err
+	}
+	if status.Plugin == None {
+		return nil, fmt.Errorf("no network plugin is installed")
+	}
+
+	client, err := kube.New()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), policyTimeout)
+	defer cancel()
+
+	log.Info("Verifying NetworkPolicy enforcement with %s...", status.Plugin)
+	check, err := VerifyPolicy(ctx, client, log)
+	if err != nil {
+		return nil, err
+	}
+	check.Plugin = status.Plugin
+
+	switch {
+	case check.Enforced():
+		log.Info("NetworkPolicy is enforced by %s", check.Plugin)
+	case !check.Allowed:
+		log.Warn("NetworkPolicy blocks allowed traffic with %s: %s", check.Plugin, check.Error)
+	default:
+		log.Warn("NetworkPolicy is NOT enforced: %s lets denied traffic through", check.Plugin)
+	}
+	if slices.Contains(PolicyEnforcers, check.Plugin) && !check.Blocked {
+		log.Warn("%s should enforce NetworkPolicy, check that it is healthy on every node", check.Plugin)
+	}
+	if check.Plugin == Flannel && !check.Blocked {
+		log.Warn("Flannel does not implement NetworkPolicy; Canal adds Calico's policy enforcement on top of it")
+	}
+
+	return check, nil
+}
+
+// VerifyPolicy runs a server and two clients in a scratch namespace,
+// applies a deny-all ingress policy and one that lets the first client
+// in, and checks that only the first client still reaches the server
+func VerifyPolicy(ctx context.Context, client *kube.Client, log *logger.Logger) (*PolicyCheck, error) {
+	namespace := fmt.Sprintf("%s-%d", policyApp, time.Now().Unix())
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+	if _, err := client.Clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to create policy test namespace: %v", err)
+	}
+	defer client.Clientset.CoreV1().Namespaces().Delete(context.Background(), namespace, metav1.DeleteOptions{})
+
+	log.Info("Starting policy test server and clients...")
+	pods := map[string]string{
+		"server":  fmt.Sprintf("echo %s > /tmp/index.html && exec httpd -f -p %d -h /tmp", policyApp, diagnosePort),
+		"allowed": "exec sleep 3600",
+		"denied":  "exec sleep 3600",
+	}
+	for role, command := range pods {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   role,
+				Labels: map[string]string{"app": policyApp, "role": role},
+			},
+			Spec: corev1.PodSpec{
+				TerminationGracePeriodSeconds: new(int64),
+				Containers: []corev1.Container{{
+					Name:    role,
+					Image:   "busybox:stable",
+					Command: []string{"sh", "-c", command},
+				}},
+			},
+		}
+		if _, err := client.Clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to create policy test pod %s: %v", role, err)
+		}
+	}
+
+	if err := wait.Pods(ctx, client, namespace, "app="+policyApp, log); err != nil {
+		return nil, fmt.Errorf("policy test pods are not ready: %v", err)
+	}
+
+	server, err := client.Clientset.CoreV1().Pods(namespace).Get(ctx, "server", metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get policy test server: %v", err)
+	}
+	address := "http://" + net.JoinHostPort(server.Status.PodIP, strconv.Itoa(diagnosePort)) + "/"
+
+	request := func(from string) error {
+		_, stderr, err := client.Exec(ctx, namespace, from, "wget", "-q", "-T", strconv.Itoa(probeTimeout), "-O", "-", address)
+		if err != nil {
+			return fmt.Errorf("%s client: %v: %s", from, err, strings.TrimSpace(stderr))
+		}
+		return nil
+	}
+
+	// Without policies both clients must get through, or a block proves nothing
+	for _, from := range []string{"allowed", "denied"} {
+		if err := request(from); err != nil {
+			return nil, fmt.Errorf("server is not reachable before applying policies: %v", err)
+		}
+	}
+
+	log.Info("Applying deny-all and allow-from-one policies...")
+	port := intstr.FromInt32(diagnosePort)
+	policies := []*networkingv1.NetworkPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "deny-all"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "allow-from-allowed"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "server"}},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{
+						PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": "allowed"}},
+					}},
+					Ports: []networkingv1.NetworkPolicyPort{{Port: &port}},
+				}},
+			},
+		},
+	}
+	for _, policy := range policies {
+		if _, err := client.Clientset.NetworkingV1().NetworkPolicies(namespace).Create(ctx, policy, metav1.CreateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to create NetworkPolicy %s: %v", policy.Name, err)
+		}
+	}
+
+	check := &PolicyCheck{}
+	settleCtx, cancel := context.WithTimeout(ctx, policySettleTimeout)
+	defer cancel()
+	err = wait.Retry(settleCtx, 2*time.Second, func() error {
+		allowedErr := request("allowed")
+		check.Allowed = allowedErr == nil
+
+		// A single failed request may be a dropped packet rather than the
+		// policy, the denied client has to fail every time
+		check.Blocked = true
+		for attempt := 1; attempt <= policyDeniedAttempts; attempt++ {
+			if request("denied") == nil {
+				check.Blocked = false
+				break
+			}
+		}
+
+		switch {
+		case allowedErr != nil:
+			return allowedErr
+		case !check.Blocked:
+			return fmt.Errorf("denied client reached the server")
+		}
+		return nil
+	})
+	if err != nil {
+		check.Error = err.Error()
+	}
+
+	return check, nil
+}
+
+// EnableCanal replaces Flannel with Canal, which runs the same Flannel
+// network with Calico enforcing NetworkPolicy. Pods keep their node's
+// subnet but are restarted, one node at a time, to move them to Calico's
+// CNI plugin. New pods can't be networked while Flannel is swapped out.
+func EnableCanal(config *Config, log *logger.Logger) error {
+	from, err := GetCurrentPlugin(log)
+	if err != nil {
+		return err
+	}
+	switch {
+	case from.Plugin != Flannel:
+		return fmt.Errorf("canal replaces flannel, but %s is installed", from.Plugin)
+	case !from.Healthy:
+		return fmt.Errorf("%s is not healthy (%s), fix it before adding policy enforcement", from.Plugin, from.Reason)
+	}
+
+	client, err := kube.New()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	nodes, err := migrationOrder(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	canal := *config
+	canal.Plugin = Canal
+	canal.Version = ""
+	if err := ValidatePodCIDR(&canal); err != nil {
+		return err
+	}
+	// Canal keeps the nodes' Flannel subnets, its pool has to be the
+	// cluster's pod CIDR or Calico's IPAM would hand out other addresses
+	if err := CheckClusterPodCIDR(ctx, client, canal.PodCIDR); err != nil {
+		return fmt.Errorf("%v, set the pod CIDR to the cluster's before enabling Canal", err)
+	}
+
+	// Canal runs its own flanneld, which would fight Flannel's, and reuses
+	// Flannel's RBAC names
+	if err := removePlugin(ctx, client, &canal, from, log); err != nil {
+		return err
+	}
+	if err := InstallPlugin(&canal, log); err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		log.Info("Moving pods on %s to Canal...", node)
+		if err := restartPodNetworkPods(ctx, client, node); err != nil {
+			return err
+		}
+		if _, err := runOnNode(ctx, client, node, flannelLeftovers); err != nil {
+			return fmt.Errorf("failed to clean up node %s: %v", node, err)
+		}
+	}
+
+	*config = canal
+	log.Info("Canal is installed, NetworkPolicy is now enforced by Calico")
+	return nil
+}
@@ -0,0 +1,147 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+)
+
+// settingsConfigMap is the kube-system ConfigMap the control plane records
+// the installed plugin in, for nodes joining later
+const settingsConfigMap = "kubeforge-network"
+
+// settingsReaders may read settingsConfigMap: joining nodes authenticate
+// with a kubeadm bootstrap token, joined ones as nodes. kubeadm grants the
+// same groups its kubeadm-config ConfigMap.
+var settingsReaders = []string{"system:bootstrappers:kubeadm:default-node-token", "system:nodes"}
+
+// ClusterSettings are the network settings a joining node has to match:
+// the plugin and the options that decide its ports and kernel modules
+type ClusterSettings struct {
+	Plugin           Plugin `json:"plugin"`
+	Encapsulation    string `json:"encapsulation,omitempty"`
+	EnableBGP        bool   `json:"enableBGP,omitempty"`
+	EnableEncryption bool   `json:"enableEncryption,omitempty"`
+	EnableHubble     bool   `json:"enableHubble,omitempty"`
+	RoutingMode      string `json:"routingMode,omitempty"`
+	TunnelProtocol   string `json:"tunnelProtocol,omitempty"`
+	TunnelPort       int    `json:"tunnelPort,omitempty"`
+}
+
+// settingsOf returns the cluster settings of config
+func settingsOf(config *Config) *ClusterSettings {
+	return &ClusterSettings{
+		Plugin:           config.Plugin,
+		Encapsulation:    config.Encapsulation,
+		EnableBGP:        config.EnableBGP,
+		EnableEncryption: config.EnableEncryption,
+		EnableHubble:     config.EnableHubble,
+		RoutingMode:      config.RoutingMode,
+		TunnelProtocol:   config.TunnelProtocol,
+		TunnelPort:       config.TunnelPort,
+	}
+}
+
+// ApplyTo sets the plugin and its options in config
+func (s *ClusterSettings) ApplyTo(config *Config) {
+	config.Plugin = s.Plugin
+	config.Encapsulation = s.Encapsulation
+	config.EnableBGP = s.EnableBGP
+	config.EnableEncryption = s.EnableEncryption
+	config.EnableHubble = s.EnableHubble
+	config.RoutingMode = s.RoutingMode
+	config.TunnelProtocol = s.TunnelProtocol
+	config.TunnelPort = s.TunnelPort
+}
+
+// settingsManifest renders the settings ConfigMap and the RBAC that lets
+// joining nodes read it
+func settingsManifest(config *Config) ([]byte, error) {
+	settings, err := json.Marshal(settingsOf(config))
+	if err != nil {
+		return nil, err
+	}
+
+	var subjects []map[string]any
+	for _, group := range settingsReaders {
+		subjects = append(subjects, map[string]any{
+			"apiGroup": "rbac.authorization.k8s.io",
+			"kind":     "Group",
+			"name":     group,
+		})
+	}
+
+	metadata := map[string]any{"name": settingsConfigMap, "namespace": metav1.NamespaceSystem}
+	return json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items": []map[string]any{
+			{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   metadata,
+				"data":       map[string]string{"settings.json": string(settings)},
+			},
+			{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "Role",
+				"metadata":   metadata,
+				"rules": []map[string]any{{
+					"apiGroups":     []string{""},
+					"resources":     []string{"configmaps"},
+					"resourceNames": []string{settingsConfigMap},
+					"verbs":         []string{"get"},
+				}},
+			},
+			{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "RoleBinding",
+				"metadata":   metadata,
+				"roleRef": map[string]any{
+					"apiGroup": "rbac.authorization.k8s.io",
+					"kind":     "Role",
+					"name":     settingsConfigMap,
+				},
+				"subjects": subjects,
+			},
+		},
+	})
+}
+
+// publishSettings records the installed plugin for nodes joining later
+func publishSettings(config *Config) error {
+	manifest, err := settingsManifest(config)
+	if err != nil {
+		return err
+	}
+	if err := applyManifest(manifest); err != nil {
+		return fmt.Errorf("failed to record the network settings: %v", err)
+	}
+	return nil
+}
+
+// ReadClusterSettings returns the network settings the control plane
+// recorded when it installed the plugin
+func ReadClusterSettings(ctx context.Context, client *kube.Client) (*ClusterSettings, error) {
+	cm, err := client.Clientset.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(ctx, settingsConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("the cluster has no %s ConfigMap, install the network plugin with KubeForge on the control plane first", settingsConfigMap)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s ConfigMap: %v", settingsConfigMap, err)
+	}
+
+	settings := &ClusterSettings{}
+	if err := json.Unmarshal([]byte(cm.Data["settings.json"]), settings); err != nil {
+		return nil, fmt.Errorf("failed to parse the %s ConfigMap: %v", settingsConfigMap, err)
+	}
+	if settings.Plugin == "" {
+		return nil, fmt.Errorf("the %s ConfigMap names no network plugin", settingsConfigMap)
+	}
+	return settings, nil
+}
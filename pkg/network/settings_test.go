@@ -0,0 +1,98 @@
+package network
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ochestra-tech/kubeforge/internal/kube"
+)
+
+func TestClusterSettingsRoundTrip(t *testing.T) {
+	config := DefaultConfig()
+	config.Plugin = Cilium
+	config.EnableEncryption = true
+	config.EnableHubble = true
+	config.TunnelProtocol = TunnelGeneve
+	config.TunnelPort = 8473
+
+	manifest, err := settingsManifest(config)
+	if err != nil {
+		t.Fatalf("settingsManifest() = %v", err)
+	}
+	objects, err := kube.DecodeManifest(manifest)
+	if err != nil {
+		t.Fatalf("DecodeManifest() = %v", err)
+	}
+
+	var kinds []string
+	var configMap *corev1.ConfigMap
+	for _, obj := range objects {
+		kinds = append(kinds, obj.GetKind())
+		if obj.GetNamespace() != metav1.NamespaceSystem || obj.GetName() != settingsConfigMap {
+			t.Errorf("%s is %s/%s, want %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName(), metav1.NamespaceSystem, settingsConfigMap)
+		}
+		if obj.GetKind() == "ConfigMap" {
+			data, _, _ := unstructured.NestedStringMap(obj.Object, "data")
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: obj.GetNamespace(), Name: obj.GetName()},
+				Data:       data,
+			}
+		}
+	}
+	if got := strings.Join(kinds, ","); got != "ConfigMap,Role,RoleBinding" {
+		t.Fatalf("settingsManifest() kinds = %s, want ConfigMap,Role,RoleBinding", got)
+	}
+
+	client := kube.NewForClients(fake.NewSimpleClientset(configMap), nil, nil)
+	settings, err := ReadClusterSettings(context.Background(), client)
+	if err != nil {
+		t.Fatalf("ReadClusterSettings() = %v", err)
+	}
+
+	// A worker with default settings ends up with the control plane's
+	worker := DefaultConfig()
+	settings.ApplyTo(worker)
+	if !reflect.DeepEqual(settingsOf(worker), settingsOf(config)) {
+		t.Errorf("worker settings = %+v, want %+v", settingsOf(worker), settingsOf(config))
+	}
+	if worker.Plugin != Cilium || worker.TunnelPort != 8473 || !worker.EnableEncryption {
+		t.Errorf("worker = %s on port %d, encryption %v, want cilium on 8473 with encryption",
+			worker.Plugin, worker.TunnelPort, worker.EnableEncryption)
+	}
+}
+
+func TestReadClusterSettingsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		wantErr string
+	}{
+		{"missing", nil, "has no kubeforge-network ConfigMap"},
+		{"no plugin", map[string]string{"settings.json": `{"encapsulation":"IPIP"}`}, "names no network plugin"},
+		{"invalid", map[string]string{"settings.json": "calico"}, "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			if tt.data != nil {
+				clientset = fake.NewSimpleClientset(&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceSystem, Name: settingsConfigMap},
+					Data:       tt.data,
+				})
+			}
+
+			_, err := ReadClusterSettings(context.Background(), kube.NewForClients(clientset, nil, nil))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadClusterSettings() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/pkg/distro"
+)
+
+// dropInName is the systemd drop-in file KubeForge writes for each service
+const dropInName = "http-proxy.conf"
+
+// services receive the proxy settings through a systemd drop-in
+var services = []string{"containerd", "kubelet"}
+
+// sysconfigProxy is the zypper and YaST proxy configuration
+const sysconfigProxy = "/etc/sysconfig/proxy"
+
+// clusterNoProxy are always excluded from proxying inside the cluster
+var clusterNoProxy = []string{"localhost", "127.0.0.1", "::1", ".svc", ".cluster.local"}
+
+// Config holds HTTP proxy settings
+type Config struct {
+	HTTPProxy  string `json:"httpProxy"`
+	HTTPSProxy string `json:"httpsProxy"`
+	NoProxy    string `json:"noProxy"`
+}
+
+// Enabled returns true if an HTTP or HTTPS proxy is configured
+func (c *Config) Enabled() bool {
+	return c.HTTPProxy != "" || c.HTTPSProxy != ""
+}
+
+// AddNoProxy appends entries to NO_PROXY, skipping empty values and duplicates
+func (c *Config) AddNoProxy(entries ...string) {
+	existing := make(map[string]bool)
+	var list []string
+	for _, entry := range strings.Split(c.NoProxy, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" && !existing[entry] {
+			existing[entry] = true
+			list = append(list, entry)
+		}
+	}
+
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry != "" && !existing[entry] {
+			existing[entry] = true
+			list = append(list, entry)
+		}
+	}
+
+	c.NoProxy = strings.Join(list, ",")
+}
+
+// AddClusterNoProxy excludes in-cluster traffic from the proxy: the pod and
+// service networks, the node addresses and names, such as the control plane
+// endpoint, and the cluster DNS suffixes
+func (c *Config) AddClusterNoProxy(podCIDR, serviceCIDR string, hosts []string) {
+	c.AddNoProxy(clusterNoProxy...)
+	c.AddNoProxy(strings.Split(podCIDR, ",")...)
+	c.AddNoProxy(strings.Split(serviceCIDR, ",")...)
+	c.AddNoProxy(hosts...)
+}
+
+// FromEnvironment is http.ProxyFromEnvironment without the caching: the
+// standard library reads the environment once, on the first request, so
+// NO_PROXY entries Configure adds later would be ignored
+func FromEnvironment(req *http.Request) (*url.URL, error) {
+	return httpproxy.FromEnvironment().ProxyFunc()(req.URL)
+}
+
+// Env returns the proxy variables in both upper and lower case, as tools disagree
+func (c *Config) Env() []string {
+	var env []string
+	add := func(name, value string) {
+		if value != "" {
+			env = append(env, name+"="+value, strings.ToLower(name)+"="+value)
+		}
+	}
+	add("HTTP_PROXY", c.HTTPProxy)
+	add("HTTPS_PROXY", c.HTTPSProxy)
+	add("NO_PROXY", c.NoProxy)
+	return env
+}
+
+// SetEnv sets the proxy variables for this process, so downloads and API
+// clients, which read them per request, use the current settings
+func (c *Config) SetEnv() {
+	for _, kv := range c.Env() {
+		name, value, _ := strings.Cut(kv, "=")
+		os.Setenv(name, value)
+	}
+}
+
+// Configure propagates the proxy to this process (and so downloads and Helm),
+// the package manager and the containerd and kubelet services
+func Configure(c *Config, dist *distro.Distribution, log *logger.Logger) error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	log.Info("Configuring HTTP proxy (no_proxy: %s)...", c.NoProxy)
+
+	c.SetEnv()
+
+	if err := configurePackageManager(c, dist); err != nil {
+		return fmt.Errorf("failed to configure package manager proxy: %v", err)
+	}
+
+	for _, service := range services {
+		if err := writeDropIn(c, service); err != nil {
+			return fmt.Errorf("failed to configure proxy for %s: %v", service, err)
+		}
+	}
+
+	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
+		return err
+	}
+
+	// Running services pick up the new environment; stopped ones get it on start
+	for _, service := range services {
+		exec.Command("systemctl", "try-restart", service).Run()
+	}
+
+	return nil
+}
+
+// writeDropIn writes the systemd drop-in that sets the proxy for service
+func writeDropIn(c *Config, service string) error {
+	dir := filepath.Join("/etc/systemd/system", service+".service.d")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("[Service]\n")
+	for _, kv := range c.Env() {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(kv))
+	}
+
+	return os.WriteFile(filepath.Join(dir, dropInName), []byte(b.String()), 0644)
+}
+
+// systemdQuote double-quotes a systemd setting value. % starts a specifier,
+// so percent-encoded credentials such as p%40ss need it doubled, and
+// backslashes and quotes are escapes.
+func systemdQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "%", "%%")
+	return `"` + value + `"`
+}
+
+// configurePackageManager persists the proxy in the package manager configuration
+func configurePackageManager(c *Config, dist *distro.Distribution) error {
+	switch dist.PackageCmd {
+	case "apt-get":
+		var b strings.Builder
+		if c.HTTPProxy != "" {
+			fmt.Fprintf(&b, "Acquire::http::Proxy \"%s\";\n", c.HTTPProxy)
+		}
+		if c.HTTPSProxy != "" {
+			fmt.Fprintf(&b, "Acquire::https::Proxy \"%s\";\n", c.HTTPSProxy)
+		}
+		return os.WriteFile("/etc/apt/apt.conf.d/95kubeforge-proxy", []byte(b.String()), 0644)
+
+	case "dnf", "yum":
+		// dnf and yum take a single proxy for all repositories
+		proxy := c.HTTPSProxy
+		if proxy == "" {
+			proxy = c.HTTPProxy
+		}
+		conf := "/etc/yum.conf"
+		if dist.PackageCmd == "dnf" {
+			conf = "/etc/dnf/dnf.conf"
+		}
+		return setINIKey(conf, "main", "proxy", proxy)
+
+	case "zypper":
+		// The file holds other settings, such as FTP_PROXY and GOPHER_PROXY
+		return setShellVars(sysconfigProxy, map[string]string{
+			"PROXY_ENABLED": "yes",
+			"HTTP_PROXY":    c.HTTPProxy,
+			"HTTPS_PROXY":   c.HTTPSProxy,
+			"NO_PROXY":      c.NoProxy,
+		})
+
+	default:
+		// pacman uses the environment set in Configure
+		return nil
+	}
+}
+
+// setINIKey sets key=value in the given section of an ini file, replacing an existing key
+func setINIKey(path, section, key, value string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+
+	header := "[" + section + "]"
+	inSection := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if inSection {
+				// Key not present in the section, insert before the next one
+				lines = append(lines[:i], append([]string{key + "=" + value}, lines[i:]...)...)
+				return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+			}
+			inSection = trimmed == header
+			continue
+		}
+		if inSection {
+			if k, _, ok := strings.Cut(trimmed, "="); ok && strings.TrimSpace(k) == key {
+				lines[i] = key + "=" + value
+				return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+			}
+		}
+	}
+
+	if !inSection {
+		lines = append(lines, header)
+	}
+	lines = append(lines, key+"="+value)
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// shellVarRe matches a (possibly commented) NAME="value" line of a sysconfig file
+var shellVarRe = regexp.MustCompile(`^\s*#?\s*([A-Z_]+)=`)
+
+// setShellVars sets NAME="value" lines in a sysconfig file, replacing
+// existing ones and keeping everything else
+func setShellVars(path string, vars map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+
+	set := make(map[string]bool)
+	for i, line := range lines {
+		m := shellVarRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if value, ok := vars[m[1]]; ok && !set[m[1]] {
+			lines[i] = fmt.Sprintf("%s=%q", m[1], value)
+			set[m[1]] = true
+		}
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if !set[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s=%q", name, vars[name]))
+	}
+
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,247 @@
+package system
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/pkg/distro"
+)
+
+// MaxClockOffset is the clock offset in seconds above which we warn.
+// etcd and certificate validation tolerate far less than kubeadm's defaults imply.
+const MaxClockOffset = 0.5
+
+// Markers around the /etc/hosts block managed by KubeForge
+const (
+	hostsFile       = "/etc/hosts"
+	hostsBlockBegin = "# BEGIN kubeforge"
+	hostsBlockEnd   = "# END kubeforge"
+)
+
+// chronyOffsetRe matches the "System time" line of chronyc tracking
+var chronyOffsetRe = regexp.MustCompile(`System time\s*:\s*([0-9.]+) seconds`)
+
+// ConfigureTimeSync makes sure the clock is synchronized, preferring a
+// synchronized systemd-timesyncd and installing chrony otherwise
+func ConfigureTimeSync(dist *distro.Distribution, log *logger.Logger) error {
+	log.Info("Configuring time synchronization...")
+
+	if exec.Command("systemctl", "is-active", "--quiet", "systemd-timesyncd").Run() == nil {
+		output, err := exec.Command("timedatectl", "show", "-p", "NTPSynchronized", "--value").Output()
+		if err == nil && strings.TrimSpace(string(output)) == "yes" {
+			log.Info("Clock is synchronized by systemd-timesyncd")
+			checkClockOffset(TimesyncOffset, "systemd-timesyncd", log)
+			return nil
+		}
+
+		// chrony can use the servers timesyncd failed to reach and steps
+		// the clock on start; both must not run at once
+		log.Warn("systemd-timesyncd is running but the clock is not synchronized, switching to chrony")
+		if err := exec.Command("systemctl", "disable", "--now", "systemd-timesyncd").Run(); err != nil {
+			return fmt.Errorf("failed to stop systemd-timesyncd: %v", err)
+		}
+	}
+
+	if err := dist.PackageManager().Install("chrony"); err != nil {
+		return fmt.Errorf("failed to install chrony: %v", err)
+	}
+
+	// Debian names the unit chrony, everyone else chronyd
+	service := "chronyd"
+	if dist.IsDebian() {
+		service = "chrony"
+	}
+	if err := exec.Command("systemctl", "enable", "--now", service).Run(); err != nil {
+		return fmt.Errorf("failed to enable %s: %v", service, err)
+	}
+
+	checkClockOffset(ClockOffset, "chrony", log)
+	return nil
+}
+
+// checkClockOffset warns when the clock is more than MaxClockOffset off NTP
+// time, as reported by the time daemon through offset
+func checkClockOffset(offset func() (float64, error), daemon string, log *logger.Logger) {
+	seconds, err := offset()
+	if err != nil {
+		log.Warn("Could not determine clock offset: %v", err)
+		return
+	}
+
+	if math.Abs(seconds) > MaxClockOffset {
+		log.Warn("Clock is %.3f seconds off NTP time; %s is correcting it", seconds, daemon)
+	} else {
+		log.Info("Clock offset: %.6f seconds", seconds)
+	}
+}
+
+// ClockOffset returns the offset from NTP time reported by chrony, in seconds
+func ClockOffset() (float64, error) {
+	output, err := exec.Command("chronyc", "tracking").Output()
+	if err != nil {
+		return 0, err
+	}
+
+	match := chronyOffsetRe.FindStringSubmatch(string(output))
+	if match == nil {
+		return 0, fmt.Errorf("unexpected chronyc tracking output")
+	}
+
+	return strconv.ParseFloat(match[1], 64)
+}
+
+// timesyncOffsetRe matches the "Offset" line of timedatectl timesync-status,
+// such as "Offset: -1.085ms" or "Offset: +1min 2.5s"
+var timesyncOffsetRe = regexp.MustCompile(`(?m)^\s*Offset:\s*([+-]?)(.+?)\s*$`)
+
+// TimesyncOffset returns the offset from NTP time reported by
+// systemd-timesyncd, in seconds
+func TimesyncOffset() (float64, error) {
+	output, err := exec.Command("timedatectl", "timesync-status").Output()
+	if err != nil {
+		return 0, err
+	}
+	return parseTimesyncOffset(string(output))
+}
+
+// parseTimesyncOffset reads the offset from timedatectl timesync-status
+// output. systemd prints time spans as space separated components, such as
+// 1min 2.5s or 850us.
+func parseTimesyncOffset(output string) (float64, error) {
+	match := timesyncOffsetRe.FindStringSubmatch(output)
+	if match == nil {
+		return 0, fmt.Errorf("unexpected timedatectl timesync-status output")
+	}
+
+	span := strings.NewReplacer("min", "m", "µs", "us", " ", "").Replace(match[2])
+	offset, err := time.ParseDuration(span)
+	if err != nil {
+		return 0, fmt.Errorf("unrecognized clock offset %q", match[2])
+	}
+
+	if match[1] == "-" {
+		return -offset.Seconds(), nil
+	}
+	return offset.Seconds(), nil
+}
+
+// SetHostname sets the system hostname if it differs from name
+func SetHostname(name string, log *logger.Logger) error {
+	current, err := os.Hostname()
+	if err == nil && current == name {
+		return nil
+	}
+
+	log.Info("Setting hostname to %s", name)
+	if err := exec.Command("hostnamectl", "set-hostname", name).Run(); err != nil {
+		return fmt.Errorf("failed to set hostname: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateHosts writes /etc/hosts entries for the names that DNS can't resolve.
+// The entries live in a marked block that is replaced on every run. The file
+// is rewritten in place rather than renamed over, since containers and some
+// cloud images bind mount it.
+func UpdateHosts(hosts map[string]string, log *logger.Logger) error {
+	data, err := os.ReadFile(hostsFile)
+	if err != nil {
+		return err
+	}
+
+	// A lookup would find the names already in our block through the block
+	// itself, so those stay without asking DNS
+	managed := hostsBlockNames(string(data))
+
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		if net.ParseIP(name) != nil {
+			continue
+		}
+		if !managed[name] {
+			if _, err := net.LookupHost(name); err == nil {
+				continue
+			}
+		}
+		log.Info("Adding /etc/hosts entry %s %s", hosts[name], name)
+		lines = append(lines, fmt.Sprintf("%s\t%s", hosts[name], name))
+	}
+
+	content := setHostsBlock(string(data), lines)
+	if content == string(data) {
+		return nil
+	}
+
+	return os.WriteFile(hostsFile, []byte(content), 0644)
+}
+
+// setHostsBlock replaces the KubeForge block in the hosts file content with
+// the given lines, dropping the block when there are none
+func setHostsBlock(content string, lines []string) string {
+	content = removeHostsBlock(content)
+	if len(lines) == 0 {
+		return content
+	}
+
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + hostsBlockBegin + "\n" + strings.Join(lines, "\n") + "\n" + hostsBlockEnd + "\n"
+}
+
+// hostsBlockNames returns the names the KubeForge block in the hosts file
+// content maps
+func hostsBlockNames(content string) map[string]bool {
+	names := make(map[string]bool)
+
+	start := strings.Index(content, hostsBlockBegin)
+	if start < 0 {
+		return names
+	}
+	block := content[start+len(hostsBlockBegin):]
+	if end := strings.Index(block, hostsBlockEnd); end >= 0 {
+		block = block[:end]
+	}
+
+	for _, line := range strings.Split(block, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, name := range fields[1:] {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// removeHostsBlock strips the KubeForge block from the hosts file content
+func removeHostsBlock(content string) string {
+	start := strings.Index(content, hostsBlockBegin)
+	if start < 0 {
+		return content
+	}
+
+	end := strings.Index(content[start:], hostsBlockEnd)
+	if end < 0 {
+		return content[:start]
+	}
+
+	return content[:start] + strings.TrimPrefix(content[start+end+len(hostsBlockEnd):], "\n")
+}
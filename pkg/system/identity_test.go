@@ -0,0 +1,93 @@
+package system
+
+import (
+	"maps"
+	"math"
+	"testing"
+)
+
+func TestSetHostsBlock(t *testing.T) {
+	const admin = "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost\n"
+
+	tests := []struct {
+		name    string
+		content string
+		lines   []string
+		want    string
+	}{
+		{"add block", admin, []string{"10.0.0.10\tcp1"}, admin + "# BEGIN kubeforge\n10.0.0.10\tcp1\n# END kubeforge\n"},
+		{"no trailing newline", "127.0.0.1\tlocalhost", []string{"10.0.0.10\tcp1"}, "127.0.0.1\tlocalhost\n# BEGIN kubeforge\n10.0.0.10\tcp1\n# END kubeforge\n"},
+		{
+			"replace block",
+			admin + "# BEGIN kubeforge\n10.0.0.10\tcp1\n10.0.0.11\tworker1\n# END kubeforge\n",
+			[]string{"10.0.0.12\tcp1"},
+			admin + "# BEGIN kubeforge\n10.0.0.12\tcp1\n# END kubeforge\n",
+		},
+		{
+			"replace block with entries after it",
+			"127.0.0.1\tlocalhost\n# BEGIN kubeforge\n10.0.0.10\tcp1\n# END kubeforge\n192.168.1.5\tnas\n",
+			[]string{"10.0.0.10\tcp1", "10.0.0.11\tworker1"},
+			"127.0.0.1\tlocalhost\n192.168.1.5\tnas\n# BEGIN kubeforge\n10.0.0.10\tcp1\n10.0.0.11\tworker1\n# END kubeforge\n",
+		},
+		{"remove block", admin + "# BEGIN kubeforge\n10.0.0.10\tcp1\n# END kubeforge\n", nil, admin},
+		{"unterminated block", admin + "# BEGIN kubeforge\n10.0.0.10\tcp1\n", []string{"10.0.0.11\tcp1"}, admin + "# BEGIN kubeforge\n10.0.0.11\tcp1\n# END kubeforge\n"},
+		{"nothing to do", admin, nil, admin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setHostsBlock(tt.content, tt.lines); got != tt.want {
+				t.Errorf("setHostsBlock() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostsBlockNames(t *testing.T) {
+	content := "127.0.0.1\tlocalhost\n10.0.0.1\tgateway\n# BEGIN kubeforge\n10.0.0.10\tcp1 cp1.example.com\n# 10.0.0.11\told\n10.0.0.12\tworker1\n# END kubeforge\n"
+	want := map[string]bool{"cp1": true, "cp1.example.com": true, "worker1": true}
+
+	if got := hostsBlockNames(content); !maps.Equal(got, want) {
+		t.Errorf("hostsBlockNames() = %v, want %v", got, want)
+	}
+	if got := hostsBlockNames("127.0.0.1\tlocalhost\n"); len(got) != 0 {
+		t.Errorf("hostsBlockNames() = %v without a block, want none", got)
+	}
+}
+
+func TestParseTimesyncOffset(t *testing.T) {
+	status := func(offset string) string {
+		return "       Server: 185.125.190.56 (ntp.ubuntu.com)\n" +
+			"Poll interval: 34min 8s (min: 32s; max 34min 8s)\n" +
+			"Root distance: 23.668ms (max: 5s)\n" +
+			"       Offset: " + offset + "\n" +
+			"        Delay: 45.226ms\n"
+	}
+
+	tests := []struct {
+		name    string
+		output  string
+		want    float64
+		wantErr bool
+	}{
+		{"milliseconds behind", status("-1.085ms"), -0.001085, false},
+		{"microseconds ahead", status("+850us"), 0.00085, false},
+		{"micro sign", status("-12µs"), -0.000012, false},
+		{"seconds", status("+2.5s"), 2.5, false},
+		{"minutes and seconds", status("-1min 2.5s"), -62.5, false},
+		{"no offset yet", "       Server: n/a\n", 0, true},
+		{"garbage", status("soon"), 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTimesyncOffset(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTimesyncOffset() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("parseTimesyncOffset() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package system
+
+import (
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/pkg/distro"
+)
+
+// Preflight reports host settings that influence the installation and
+// fails when the host can't be set up as requested
+func Preflight(dist *distro.Distribution, selinuxMode SELinuxMode, log *logger.Logger) error {
+	log.Info("Running preflight checks...")
+
+	log.Info("Distribution: %s %s (%s)", dist.Name, dist.Version, dist.Arch)
+	log.Info("SELinux: %s", SELinuxState())
+
+	return checkSELinuxMode(dist, selinuxMode)
+}
@@ -0,0 +1,160 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ochestra-tech/kubeforge/pkg/network"
+)
+
+// Profile is the declarative set of kernel modules and sysctls a node needs
+type Profile struct {
+	Modules []string
+	Sysctls map[string]string
+}
+
+// Drift describes a sysctl whose live value differs from the profile
+type Drift struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+// ipvsModules are the modules kube-proxy needs in IPVS mode
+var ipvsModules = []string{"ip_vs", "ip_vs_rr", "ip_vs_wrr", "ip_vs_sh"}
+
+// ipv6Sysctls let nodes route pod traffic in IPv6 and dual-stack clusters
+var ipv6Sysctls = map[string]string{
+	"net.ipv6.conf.all.disable_ipv6":     "0",
+	"net.ipv6.conf.all.forwarding":       "1",
+	"net.ipv6.conf.default.forwarding":   "1",
+	"net.ipv6.conf.default.disable_ipv6": "0",
+}
+
+// DefaultProfile returns the baseline profile for every Kubernetes node
+func DefaultProfile() *Profile {
+	return &Profile{
+		Modules: []string{"overlay", "br_netfilter", "nf_conntrack"},
+		Sysctls: map[string]string{
+			"net.bridge.bridge-nf-call-iptables":  "1",
+			"net.bridge.bridge-nf-call-ip6tables": "1",
+			"net.ipv4.ip_forward":                 "1",
+			"net.netfilter.nf_conntrack_max":      "1048576",
+			"fs.inotify.max_user_instances":       "8192",
+			"fs.inotify.max_user_watches":         "524288",
+		},
+	}
+}
+
+// AddModules adds kernel modules to the profile, skipping duplicates
+func (p *Profile) AddModules(modules ...string) {
+	for _, module := range modules {
+		found := false
+		for _, existing := range p.Modules {
+			if existing == module {
+				found = true
+				break
+			}
+		}
+		if !found {
+			p.Modules = append(p.Modules, module)
+		}
+	}
+}
+
+// SetSysctls sets sysctls on the profile, overriding existing values
+func (p *Profile) SetSysctls(sysctls map[string]string) {
+	for key, value := range sysctls {
+		p.Sysctls[key] = value
+	}
+}
+
+// AddIPVS adds the modules kube-proxy needs in IPVS mode, including the
+// one for scheduler
+func (p *Profile) AddIPVS(scheduler string) {
+	p.AddModules(ipvsModules...)
+	if scheduler != "" {
+		p.AddModules("ip_vs_" + scheduler)
+	}
+}
+
+// AddIPv6 adds the sysctls IPv6 and dual-stack clusters need
+func (p *Profile) AddIPv6() {
+	p.SetSysctls(ipv6Sysctls)
+}
+
+// AddNetwork adds the modules and sysctls the network plugin needs
+func (p *Profile) AddNetwork(config *network.Config) {
+	if config.StackMode() != network.IPv4Only {
+		p.AddIPv6()
+	}
+
+	if config.EnableEncryption {
+		p.AddModules("wireguard")
+	}
+
+	switch config.Plugin {
+	case network.Calico:
+		if config.UsesIPIP() {
+			p.AddModules("ipip")
+		}
+		if config.UsesVXLAN() {
+			p.AddModules("vxlan")
+		}
+	case network.Flannel, network.Canal, network.Cilium:
+		p.AddModules("vxlan")
+	}
+
+	// Cilium's datapath drops replies when strict reverse path filtering is on
+	if config.Plugin == network.Cilium {
+		p.SetSysctls(map[string]string{"net.ipv4.conf.all.rp_filter": "0"})
+	}
+}
+
+// modulesFile returns the modules-load.d content for the profile
+func (p *Profile) modulesFile() string {
+	return strings.Join(p.Modules, "\n") + "\n"
+}
+
+// sysctlFile returns the sysctl.d content for the profile in a stable order
+func (p *Profile) sysctlFile() string {
+	var b strings.Builder
+	for _, key := range p.sortedKeys() {
+		fmt.Fprintf(&b, "%s = %s\n", key, p.Sysctls[key])
+	}
+	return b.String()
+}
+
+// sortedKeys returns the sysctl keys in lexical order
+func (p *Profile) sortedKeys() []string {
+	keys := make([]string, 0, len(p.Sysctls))
+	for key := range p.Sysctls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Verify reads every sysctl back from /proc/sys and returns the ones that differ
+func (p *Profile) Verify() []Drift {
+	var drift []Drift
+	for _, key := range p.sortedKeys() {
+		expected := p.Sysctls[key]
+
+		data, err := os.ReadFile(filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/")))
+		if err != nil {
+			drift = append(drift, Drift{Key: key, Expected: expected, Actual: "unavailable"})
+			continue
+		}
+
+		// Multi-value sysctls are tab separated in /proc/sys
+		actual := strings.Join(strings.Fields(string(data)), " ")
+		if actual != strings.Join(strings.Fields(expected), " ") {
+			drift = append(drift, Drift{Key: key, Expected: expected, Actual: actual})
+		}
+	}
+	return drift
+}
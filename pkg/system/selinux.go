@@ -0,0 +1,179 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+	"github.com/ochestra-tech/kubeforge/pkg/distro"
+)
+
+// SELinuxMode selects how KubeForge configures SELinux
+type SELinuxMode string
+
+// Supported SELinux strategies
+const (
+	// SELinuxPermissive switches SELinux to permissive mode
+	SELinuxPermissive SELinuxMode = "permissive"
+	// SELinuxEnforcing keeps SELinux enforcing and labels the Kubernetes directories
+	SELinuxEnforcing SELinuxMode = "enforcing"
+)
+
+// SELinux states as reported by getenforce
+const (
+	SELinuxStateEnforcing  = "Enforcing"
+	SELinuxStatePermissive = "Permissive"
+	SELinuxStateDisabled   = "Disabled"
+)
+
+const selinuxConfig = "/etc/selinux/config"
+
+// selinuxPackages provide the container policy and semanage for enforcing mode
+var selinuxPackages = []string{"container-selinux", "policycoreutils-python-utils"}
+
+// selinuxFileContexts are the file contexts the control plane and kubelet need
+// when running confined. Static pods mount /etc/kubernetes and /var/lib/etcd.
+var selinuxFileContexts = []struct {
+	Path string
+	Type string
+}{
+	{"/etc/kubernetes", "container_file_t"},
+	{"/var/lib/etcd", "container_file_t"},
+	{"/var/lib/kubelet", "container_var_lib_t"},
+	{"/var/lib/kubelet/pods", "container_file_t"},
+}
+
+// selinuxBooleans are the booleans enabled for enforcing mode
+var selinuxBooleans = []string{
+	"container_manage_cgroup",
+}
+
+// SELinuxState returns the current SELinux state (Enforcing, Permissive or Disabled)
+func SELinuxState() string {
+	output, err := exec.Command("getenforce").Output()
+	if err != nil {
+		return SELinuxStateDisabled
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// ValidateSELinuxMode checks a mode from the configuration file. Empty
+// means KubeForge asks.
+func ValidateSELinuxMode(mode SELinuxMode) error {
+	switch mode {
+	case "", SELinuxPermissive, SELinuxEnforcing:
+		return nil
+	default:
+		return fmt.Errorf("unsupported SELinux mode %q, use %s or %s", mode, SELinuxEnforcing, SELinuxPermissive)
+	}
+}
+
+// checkSELinuxMode verifies that the host can run in the requested mode.
+// Enforcing needs SELinux enabled and the policy packages installable.
+func checkSELinuxMode(dist *distro.Distribution, mode SELinuxMode) error {
+	if mode != SELinuxEnforcing {
+		return nil
+	}
+	if SELinuxState() == SELinuxStateDisabled {
+		return fmt.Errorf("SELinux enforcing mode was requested but SELinux is disabled")
+	}
+
+	pm := dist.PackageManager()
+	var missing []string
+	for _, pkg := range selinuxPackages {
+		if !pm.Available(pkg) {
+			missing = append(missing, pkg)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("SELinux enforcing mode needs %s, which %s cannot install",
+			strings.Join(missing, ", "), pm.Cmd)
+	}
+	return nil
+}
+
+// ConfigureSELinux applies the selected SELinux strategy
+func ConfigureSELinux(dist *distro.Distribution, mode SELinuxMode, log *logger.Logger) error {
+	if SELinuxState() == SELinuxStateDisabled {
+		log.Info("SELinux is disabled, nothing to configure")
+		return nil
+	}
+
+	switch mode {
+	case SELinuxPermissive:
+		return setSELinuxPermissive(log)
+	case SELinuxEnforcing:
+		return setSELinuxEnforcing(dist, log)
+	default:
+		return fmt.Errorf("unsupported SELinux mode: %s", mode)
+	}
+}
+
+// setSELinuxPermissive switches SELinux to permissive now and after reboot
+func setSELinuxPermissive(log *logger.Logger) error {
+	log.Info("Setting SELinux to permissive mode...")
+
+	if err := exec.Command("setenforce", "0").Run(); err != nil {
+		return fmt.Errorf("failed to set SELinux to permissive: %v", err)
+	}
+
+	return setSELinuxConfig("permissive")
+}
+
+// setSELinuxEnforcing keeps SELinux enforcing with the policy and labels Kubernetes needs
+func setSELinuxEnforcing(dist *distro.Distribution, log *logger.Logger) error {
+	log.Info("Configuring SELinux in enforcing mode...")
+
+	// container-selinux ships the container policy, semanage comes from
+	// policycoreutils-python-utils
+	pm := dist.PackageManager()
+	if err := pm.Install(selinuxPackages...); err != nil {
+		return fmt.Errorf("failed to install SELinux packages: %v", err)
+	}
+
+	for _, fc := range selinuxFileContexts {
+		if err := os.MkdirAll(fc.Path, 0755); err != nil {
+			return err
+		}
+
+		spec := fc.Path + "(/.*)?"
+		err := exec.Command("semanage", "fcontext", "-a", "-t", fc.Type, spec).Run()
+		if err != nil {
+			// The rule may already exist, in which case it is modified instead
+			err = exec.Command("semanage", "fcontext", "-m", "-t", fc.Type, spec).Run()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set file context %s on %s: %v", fc.Type, fc.Path, err)
+		}
+	}
+
+	for _, fc := range selinuxFileContexts {
+		if err := exec.Command("restorecon", "-R", fc.Path).Run(); err != nil {
+			return fmt.Errorf("failed to restore file contexts on %s: %v", fc.Path, err)
+		}
+	}
+
+	for _, boolean := range selinuxBooleans {
+		if err := exec.Command("setsebool", "-P", boolean, "on").Run(); err != nil {
+			return fmt.Errorf("failed to enable SELinux boolean %s: %v", boolean, err)
+		}
+	}
+
+	if err := exec.Command("setenforce", "1").Run(); err != nil {
+		return fmt.Errorf("failed to set SELinux to enforcing: %v", err)
+	}
+
+	return setSELinuxConfig("enforcing")
+}
+
+// setSELinuxConfig persists the SELinux mode in /etc/selinux/config
+func setSELinuxConfig(mode string) error {
+	if _, err := os.Stat(selinuxConfig); err != nil {
+		return nil
+	}
+
+	sedCmd := exec.Command("sed", "-i", "-E", fmt.Sprintf("s/^SELINUX=(enforcing|permissive)$/SELINUX=%s/", mode), selinuxConfig)
+	return sedCmd.Run()
+}
@@ -0,0 +1,158 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ochestra-tech/kubeforge/internal/logger"
+)
+
+// zramGeneratorConfig overrides the distribution default; an empty file
+// tells zram-generator not to create any devices (the Fedora default)
+const zramGeneratorConfig = "/etc/systemd/zram-generator.conf"
+
+// DisableSwap disables swap memory (required for Kubernetes unless NodeSwap is used)
+func DisableSwap(log *logger.Logger) error {
+	log.Info("Disabling swap...")
+
+	// Turn off swap
+	swapoffCmd := exec.Command("swapoff", "-a")
+	err := swapoffCmd.Run()
+	if err != nil {
+		return err
+	}
+
+	// Comment out swap entries in /etc/fstab
+	if err := disableFstabSwap("/etc/fstab"); err != nil {
+		return err
+	}
+
+	// Swap units may also come from other generators or be written by hand
+	if err := maskSwapUnits(log); err != nil {
+		return err
+	}
+
+	if err := disableZram(log); err != nil {
+		return err
+	}
+
+	// Anything still listed was started outside fstab and systemd, e.g. a
+	// swapfile enabled by a cloud-init script
+	active, err := activeSwaps()
+	if err != nil {
+		return err
+	}
+	for _, device := range active {
+		log.Info("Turning off swap on %s", device)
+		if err := exec.Command("swapoff", device).Run(); err != nil {
+			return fmt.Errorf("failed to turn off swap on %s: %v", device, err)
+		}
+	}
+
+	if active, err = activeSwaps(); err != nil {
+		return err
+	}
+	if len(active) > 0 {
+		return fmt.Errorf("swap is still active on %s", strings.Join(active, ", "))
+	}
+
+	return nil
+}
+
+// disableFstabSwap comments out fstab entries whose filesystem type is swap
+func disableFstabSwap(path string) error {
+	fstabData, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(commentSwapEntries(string(fstabData))), 0644)
+}
+
+// commentSwapEntries returns the fstab contents with the swap entries
+// commented out
+func commentSwapEntries(fstab string) string {
+	lines := strings.Split(fstab, "\n")
+	for i, line := range lines {
+		// fstab fields: spec, file, vfstype, mntops, freq, passno
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[2] == "swap" {
+			lines[i] = "# " + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// maskSwapUnits stops and masks every systemd .swap unit so it isn't reactivated
+func maskSwapUnits(log *logger.Logger) error {
+	output, err := exec.Command("systemctl", "list-units", "--type=swap", "--all",
+		"--plain", "--no-legend", "--no-pager").Output()
+	if err != nil {
+		// No systemd on this host
+		return nil
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasSuffix(fields[0], ".swap") {
+			continue
+		}
+
+		unit := fields[0]
+		log.Info("Masking swap unit %s", unit)
+		exec.Command("systemctl", "stop", unit).Run()
+		if err := exec.Command("systemctl", "mask", unit).Run(); err != nil {
+			return fmt.Errorf("failed to mask swap unit %s: %v", unit, err)
+		}
+	}
+
+	return nil
+}
+
+// disableZram stops zram swap devices and keeps zram-generator from recreating them
+func disableZram(log *logger.Logger) error {
+	if _, err := os.Stat("/usr/lib/systemd/system-generators/zram-generator"); err != nil {
+		return nil
+	}
+
+	log.Info("Disabling zram-generator swap...")
+	if err := os.MkdirAll("/etc/systemd", 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(zramGeneratorConfig, []byte("# Disabled by KubeForge: Kubernetes requires swap to be off\n"), 0644); err != nil {
+		return err
+	}
+
+	output, _ := exec.Command("systemctl", "list-units", "systemd-zram-setup@*", "--all",
+		"--plain", "--no-legend", "--no-pager").Output()
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			exec.Command("systemctl", "stop", fields[0]).Run()
+		}
+	}
+
+	return exec.Command("systemctl", "daemon-reload").Run()
+}
+
+// activeSwaps returns the devices and files listed in /proc/swaps
+func activeSwaps() ([]string, error) {
+	data, err := os.ReadFile("/proc/swaps")
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []string
+	lines := strings.Split(string(data), "\n")
+	for _, line := range lines[1:] {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			devices = append(devices, fields[0])
+		}
+	}
+	return devices, nil
+}
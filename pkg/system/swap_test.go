@@ -0,0 +1,27 @@
+package system
+
+import "testing"
+
+func TestCommentSwapEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		fstab string
+		want  string
+	}{
+		{"device", "/dev/sda2 none swap sw 0 0\n", "# /dev/sda2 none swap sw 0 0\n"},
+		{"uuid spec", "UUID=2f1c6e5b-7b4a-4c1e-9d3e-5a6b7c8d9e0f none swap sw 0 0\n", "# UUID=2f1c6e5b-7b4a-4c1e-9d3e-5a6b7c8d9e0f none swap sw 0 0\n"},
+		{"label spec", "LABEL=swap\tnone\tswap\tdefaults\t0\t0\n", "# LABEL=swap\tnone\tswap\tdefaults\t0\t0\n"},
+		{"swapfile", "/swap.img none swap sw 0 0\n", "# /swap.img none swap sw 0 0\n"},
+		{"already commented", "#/dev/sda2 none swap sw 0 0\n# /dev/sda3 none swap sw 0 0\n", "#/dev/sda2 none swap sw 0 0\n# /dev/sda3 none swap sw 0 0\n"},
+		{"other filesystems kept", "UUID=1234 / ext4 errors=remount-ro 0 1\nLABEL=swap /mnt/swap xfs defaults 0 2\n", "UUID=1234 / ext4 errors=remount-ro 0 1\nLABEL=swap /mnt/swap xfs defaults 0 2\n"},
+		{"mixed", "# /etc/fstab\nUUID=1234 / ext4 defaults 0 1\n\nUUID=5678 none swap sw 0 0\n", "# /etc/fstab\nUUID=1234 / ext4 defaults 0 1\n\n# UUID=5678 none swap sw 0 0\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := commentSwapEntries(tt.fstab); got != tt.want {
+				t.Errorf("commentSwapEntries() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
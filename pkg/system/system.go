@@ -1,10 +1,11 @@
 package system
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
-	"strings"
+	"path/filepath"
 
 	"github.com/ochestra-tech/kubeforge/internal/logger"
 	"github.com/ochestra-tech/kubeforge/pkg/distro"
@@ -23,75 +24,49 @@ func CheckRoot() bool {
 func UpdateSystem(dist *distro.Distribution, log *logger.Logger) error {
 	log.Info("Updating system packages...")
 
-	var cmd *exec.Cmd
 	switch dist.Type {
-	case distro.Debian:
-		cmd = exec.Command("apt-get", "update")
-		err := cmd.Run()
-		if err != nil {
-			return err
-		}
-		cmd = exec.Command("apt-get", "upgrade", "-y")
-	case distro.RedHat:
-		cmd = exec.Command("yum", "update", "-y")
+	case distro.Debian, distro.RedHat, distro.Suse, distro.ArchLinux:
+		return dist.PackageManager().Upgrade()
 	default:
 		log.Warn("Unsupported distribution for automatic updates. Please update manually.")
 		return nil
 	}
-
-	return cmd.Run()
 }
 
 // InstallDependencies installs required dependencies
 func InstallDependencies(dist *distro.Distribution, log *logger.Logger) error {
 	log.Info("Installing dependencies...")
 
-	var cmd *exec.Cmd
+	pm := dist.PackageManager()
 	switch dist.Type {
 	case distro.Debian:
-		cmd = exec.Command("apt-get", "install", "-y",
-			"apt-transport-https", "ca-certificates",
+		return pm.Install("apt-transport-https", "ca-certificates",
 			"curl", "software-properties-common", "gnupg2")
 	case distro.RedHat:
-		cmd = exec.Command("yum", "install", "-y",
-			"yum-utils", "device-mapper-persistent-data", "lvm2", "curl")
+		// dnf-plugins-core provides "dnf config-manager", yum-utils the yum equivalent
+		configManager := "yum-utils"
+		if pm.Cmd == "dnf" {
+			configManager = "dnf-plugins-core"
+		}
+		return pm.Install(configManager, "device-mapper-persistent-data", "lvm2", "curl")
+	case distro.Suse:
+		return pm.Install("ca-certificates", "curl", "gpg2", "conntrack-tools", "socat", "ethtool")
+	case distro.ArchLinux:
+		return pm.Install("ca-certificates", "curl", "gnupg", "conntrack-tools", "socat", "ethtool", "ebtables")
 	default:
 		log.Warn("Unsupported distribution for automatic dependency installation. Please install dependencies manually.")
 		return nil
 	}
-
-	return cmd.Run()
 }
 
-// DisableSwap disables swap memory (required for Kubernetes)
-func DisableSwap(log *logger.Logger) error {
-	log.Info("Disabling swap...")
-
-	// Turn off swap
-	swapoffCmd := exec.Command("swapoff", "-a")
-	err := swapoffCmd.Run()
-	if err != nil {
-		return err
-	}
-
-	// Comment out swap entries in /etc/fstab
-	fstabData, err := os.ReadFile("/etc/fstab")
-	if err != nil {
-		return err
-	}
-
-	lines := strings.Split(string(fstabData), "\n")
-	for i, line := range lines {
-		if strings.Contains(line, "swap") && !strings.HasPrefix(strings.TrimSpace(line), "#") {
-			lines[i] = "# " + line
-		}
-	}
-
-	return os.WriteFile("/etc/fstab", []byte(strings.Join(lines, "\n")), 0644)
+// InstallIPVSTools installs ipset and ipvsadm, which kube-proxy uses in IPVS mode
+func InstallIPVSTools(dist *distro.Distribution, log *logger.Logger) error {
+	log.Info("Installing IPVS tools...")
+	return dist.PackageManager().Install("ipset", "ipvsadm")
 }
 
-// ConfigureSystem sets up system settings for Kubernetes
-func ConfigureSystem(log *logger.Logger) error {
+// ConfigureSystem applies the kernel module and sysctl profile for Kubernetes
+func ConfigureSystem(profile *Profile, log *logger.Logger) error {
 	log.Info("Configuring system settings for Kubernetes...")
 
 	// Create directory if it doesn't exist
@@ -101,39 +76,42 @@ func ConfigureSystem(log *logger.Logger) error {
 	}
 
 	// Set up kernel modules
-	kernelModules := `overlay
-br_netfilter
-`
-	err = os.WriteFile("/etc/modules-load.d/k8s.conf", []byte(kernelModules), 0644)
+	err = os.WriteFile("/etc/modules-load.d/k8s.conf", []byte(profile.modulesFile()), 0644)
 	if err != nil {
 		return err
 	}
 
-	// Load kernel modules
-	for _, module := range []string{"overlay", "br_netfilter"} {
+	// Load kernel modules; built-in modules show up in /sys/module without modprobe
+	for _, module := range profile.Modules {
 		cmd := exec.Command("modprobe", module)
-		err := cmd.Run()
-		if err != nil {
-			log.Warn("Failed to load module %s: %v", module, err)
+		if err := cmd.Run(); err != nil {
+			if _, statErr := os.Stat(filepath.Join("/sys/module", module)); statErr != nil {
+				return fmt.Errorf("failed to load kernel module %s: %v", module, err)
+			}
 		}
 	}
 
 	// Set up sysctl parameters
-	sysctlParams := `net.bridge.bridge-nf-call-iptables  = 1
-net.bridge.bridge-nf-call-ip6tables = 1
-net.ipv4.ip_forward                 = 1
-`
 	err = os.MkdirAll("/etc/sysctl.d", 0755)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile("/etc/sysctl.d/k8s.conf", []byte(sysctlParams), 0644)
+	err = os.WriteFile("/etc/sysctl.d/k8s.conf", []byte(profile.sysctlFile()), 0644)
 	if err != nil {
 		return err
 	}
 
 	// Apply sysctl parameters
 	cmd := exec.Command("sysctl", "--system")
-	return cmd.Run()
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	// Another file in /etc/sysctl.d may override ours, so check what the kernel reports
+	for _, d := range profile.Verify() {
+		log.Warn("sysctl %s is %s, expected %s", d.Key, d.Actual, d.Expected)
+	}
+
+	return nil
 }
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 )
 
@@ -84,19 +83,32 @@ func PromptYesNo(prompt string) bool {
 	}
 }
 
-// GetDefaultIP returns the default IP address
-func GetDefaultIP() string {
-	cmd := exec.Command("hostname", "-I")
-	output, err := cmd.Output()
-	if err != nil {
-		return "127.0.0.1"
-	}
+// PromptYesNoDefault prompts for a yes/no answer, returning defaultValue
+// when the answer is empty
+func PromptYesNoDefault(prompt string, defaultValue bool) bool {
+	reader := bufio.NewReader(os.Stdin)
 
-	// Use the first IP address
-	ips := strings.Fields(string(output))
-	if len(ips) > 0 {
-		return ips[0]
+	hint := "y/N"
+	if defaultValue {
+		hint = "Y/n"
 	}
 
-	return "127.0.0.1"
+	for {
+		fmt.Printf("%s (%s): ", prompt, hint)
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			return defaultValue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(input)) {
+		case "":
+			return defaultValue
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+
+		fmt.Println("Please enter 'y' or 'n'.")
+	}
 }